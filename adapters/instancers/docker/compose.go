@@ -11,8 +11,8 @@ import (
 
 	"github.com/compose-spec/compose-go/v2/cli"
 	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/fedstackjs/azukiiro/logging"
 	"github.com/fedstackjs/azukiiro/storage"
-	"github.com/sirupsen/logrus"
 )
 
 func LoadComposeProject(ctx context.Context, config *DockerAdapterConfig, instanceId string, path string, projectName string) (*types.Project, error) {
@@ -84,7 +84,7 @@ func TransformComposeProject(ctx context.Context, project *types.Project, config
 		} else {
 			for label := range service.Labels {
 				if strings.HasPrefix(label, "caddy") {
-					logrus.Infof("removing label %s from service %s", label, serviceName)
+					logging.FromContext(ctx).Infof("removing label %s from service %s", label, serviceName)
 					delete(service.Labels, label)
 				}
 			}
@@ -116,6 +116,17 @@ func WriteComposeProject(ctx context.Context, instancePath string, project *type
 	return nil
 }
 
+// runLogged runs cmd with its output sent to the task log
+func runLogged(ctx context.Context, cmd *exec.Cmd) error {
+	stdout := logging.Writer(ctx, "stdout")
+	defer stdout.Close()
+	stderr := logging.Writer(ctx, "stderr")
+	defer stderr.Close()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
 func StartComposeProject(ctx context.Context, instancePath string, timeout int) error {
 	execCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
 	defer cancel()
@@ -126,9 +137,7 @@ func StartComposeProject(ctx context.Context, instancePath string, timeout int)
 
 	cmd := exec.CommandContext(execCtx, name, args...)
 	cmd.Dir = instancePath
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+	if err := runLogged(ctx, cmd); err != nil {
 		return fmt.Errorf("failed to start compose project: %w", err)
 	}
 
@@ -141,9 +150,7 @@ func StopComposeProject(ctx context.Context, instanceId string) error {
 
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Dir = filepath.Join(storage.GetRootPath(), "instances", instanceId)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+	if err := runLogged(ctx, cmd); err != nil {
 		return fmt.Errorf("failed to stop compose project: %w", err)
 	}
 
@@ -156,9 +163,7 @@ func RemoveComposeProject(ctx context.Context, instanceId string) error {
 
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Dir = filepath.Join(storage.GetRootPath(), "instances", instanceId)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+	if err := runLogged(ctx, cmd); err != nil {
 		return fmt.Errorf("failed to remove compose project: %w", err)
 	}
 
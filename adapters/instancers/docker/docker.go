@@ -8,9 +8,8 @@ import (
 
 	"github.com/fedstackjs/azukiiro/client"
 	"github.com/fedstackjs/azukiiro/instancer"
+	"github.com/fedstackjs/azukiiro/logging"
 	"github.com/fedstackjs/azukiiro/storage"
-	"github.com/fedstackjs/azukiiro/utils"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
@@ -72,12 +71,12 @@ func (d *DockerAdapter) StartInstance(ctx context.Context, task instancer.Instan
 	updateMessage()
 
 	message += "- Extract problem data"
-	problemDir, err := utils.UnzipTemp(task.ProblemData(), "problem")
+	problemDir, release, err := storage.Extract(ctx, task.ProblemData())
 	if err != nil {
-		logrus.Infof("Failed to extract problem data: %v", err)
+		logging.FromContext(ctx).Infof("Failed to extract problem data: %v", err)
 		return updateError(err)
 	}
-	defer os.RemoveAll(problemDir)
+	defer release()
 	updateMessage()
 
 	message += "- Load Docker Compose Project"
@@ -86,7 +85,7 @@ func (d *DockerAdapter) StartInstance(ctx context.Context, task instancer.Instan
 	projectDomain := getProjectDomainForTask(task, config)
 	project, err := LoadComposeProject(ctx, config, task.InstanceId(), composeTemplateDir, projectName)
 	if err != nil {
-		logrus.Infof("Failed to load Docker Compose project: %v", err)
+		logging.FromContext(ctx).Infof("Failed to load Docker Compose project: %v", err)
 		return updateError(err)
 	}
 	updateMessage()
@@ -94,7 +93,7 @@ func (d *DockerAdapter) StartInstance(ctx context.Context, task instancer.Instan
 	message += "- Initialize Docker Compose Instance"
 	instancePath, err := InitComposeInstance(ctx, task.InstanceId(), composeTemplateDir)
 	if err != nil {
-		logrus.Infof("Failed to initialize Docker Compose instance: %v", err)
+		logging.FromContext(ctx).Infof("Failed to initialize Docker Compose instance: %v", err)
 		return updateError(err)
 	}
 	updateMessage()
@@ -102,7 +101,7 @@ func (d *DockerAdapter) StartInstance(ctx context.Context, task instancer.Instan
 	message += "- Transform Docker Compose Project"
 	err = TransformComposeProject(ctx, project, config, projectDomain)
 	if err != nil {
-		logrus.Infof("Failed to transform Docker Compose project: %v", err)
+		logging.FromContext(ctx).Infof("Failed to transform Docker Compose project: %v", err)
 		return updateError(err)
 	}
 	updateMessage()
@@ -110,7 +109,7 @@ func (d *DockerAdapter) StartInstance(ctx context.Context, task instancer.Instan
 	message += "- Write Docker Compose Project"
 	err = WriteComposeProject(ctx, instancePath, project)
 	if err != nil {
-		logrus.Infof("Failed to write Docker Compose project: %v", err)
+		logging.FromContext(ctx).Infof("Failed to write Docker Compose project: %v", err)
 		return updateError(err)
 	}
 	updateMessage()
@@ -118,7 +117,7 @@ func (d *DockerAdapter) StartInstance(ctx context.Context, task instancer.Instan
 	message += "- Start Docker Compose Project"
 	err = StartComposeProject(ctx, instancePath, config.StartTimeout)
 	if err != nil {
-		logrus.Infof("Failed to start Docker Compose project: %v", err)
+		logging.FromContext(ctx).Infof("Failed to start Docker Compose project: %v", err)
 		return updateError(err)
 	}
 	updateMessage()
@@ -174,7 +173,7 @@ func (d *DockerAdapter) DestroyInstance(ctx context.Context, task instancer.Inst
 	message += "- Stop Docker Compose Project"
 	err = StopComposeProject(ctx, task.InstanceId())
 	if err != nil {
-		logrus.Infof("Failed to stop Docker Compose project: %v", err)
+		logging.FromContext(ctx).Infof("Failed to stop Docker Compose project: %v", err)
 		return updateError(err)
 	}
 	updateMessage()
@@ -182,7 +181,7 @@ func (d *DockerAdapter) DestroyInstance(ctx context.Context, task instancer.Inst
 	message += "- Remove Docker Compose Project"
 	err = RemoveComposeProject(ctx, task.InstanceId())
 	if err != nil {
-		logrus.Infof("Failed to remove Docker Compose project: %v", err)
+		logging.FromContext(ctx).Infof("Failed to remove Docker Compose project: %v", err)
 		return updateError(err)
 	}
 	updateMessage()
@@ -190,7 +189,7 @@ func (d *DockerAdapter) DestroyInstance(ctx context.Context, task instancer.Inst
 	message += "- Clean up instance directory"
 	err = CleanupInstanceDirectory(ctx, task.InstanceId())
 	if err != nil {
-		logrus.Infof("Failed to clean up instance directory: %v", err)
+		logging.FromContext(ctx).Infof("Failed to clean up instance directory: %v", err)
 		return updateError(err)
 	}
 	updateMessage()
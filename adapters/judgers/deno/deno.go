@@ -18,9 +18,9 @@ import (
 
 	"github.com/fedstackjs/azukiiro/common"
 	"github.com/fedstackjs/azukiiro/judge"
+	"github.com/fedstackjs/azukiiro/logging"
 	"github.com/fedstackjs/azukiiro/storage"
 	"github.com/fedstackjs/azukiiro/utils"
-	"github.com/sirupsen/logrus"
 )
 
 func init() {
@@ -48,18 +48,18 @@ func (g *DenoAdapter) reportHandler(ctx context.Context, task judge.JudgeTask, p
 		}
 		k, v, err := parseKVLine(line)
 		if err != nil {
-			logrus.Warnf("Failed to parse report line: %v", err)
+			logging.FromContext(ctx).Warnf("Failed to parse report line: %v", err)
 			continue
 		}
 		switch k {
 		case "score":
 			score, err := strconv.ParseFloat(v, 64)
 			if err != nil {
-				logrus.Warnf("Failed to parse score: %v", err)
+				logging.FromContext(ctx).Warnf("Failed to parse score: %v", err)
 				continue
 			}
 			if score < 0 || score > 100 {
-				logrus.Warnf("Invalid score: %v", score)
+				logging.FromContext(ctx).Warnf("Invalid score: %v", score)
 				continue
 			}
 			request.Score = score
@@ -70,13 +70,13 @@ func (g *DenoAdapter) reportHandler(ctx context.Context, task judge.JudgeTask, p
 		case "metrics":
 			metrics := make(map[string]float64)
 			if err := json.Unmarshal([]byte(v), &metrics); err != nil {
-				logrus.Warnf("Failed to parse metrics: %v", err)
+				logging.FromContext(ctx).Warnf("Failed to parse metrics: %v", err)
 				continue
 			}
 			request.Metrics = &metrics
 		case "commit":
 			if err := task.Update(ctx, &request); err != nil {
-				logrus.Warnf("Failed to commit report: %v", err)
+				logging.FromContext(ctx).Warnf("Failed to commit report: %v", err)
 			}
 		}
 	}
@@ -90,11 +90,11 @@ func (g *DenoAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 		return err
 	}
 
-	problemDir, err := utils.UnzipTemp(task.ProblemData(), "problem-*")
+	problemDir, release, err := storage.Extract(ctx, task.ProblemData())
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(problemDir)
+	defer release()
 
 	scriptPath := filepath.Join(problemDir, adapterConfig.Script)
 	if _, err := os.Stat(scriptPath); err != nil {
@@ -103,7 +103,7 @@ func (g *DenoAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 
 	solutionDir, err := utils.UnzipTemp(task.SolutionData(), "solution-*")
 	if err != nil {
-		return err
+		return judge.BadSolutionArchive(err)
 	}
 	defer os.RemoveAll(solutionDir)
 
@@ -157,16 +157,20 @@ func (g *DenoAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 	cmd.Env = append(cmd.Env, "AZUKIIRO_SOLUTION_DATA_DIR="+solutionDir)
 	cmd.Env = append(cmd.Env, "AZUKIIRO_REPORT="+reportPath)
 	cmd.Env = append(cmd.Env, "AZUKIIRO_DETAILS="+detailsPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	stdout := logging.Writer(ctx, "stdout")
+	stderr := logging.Writer(ctx, "stderr")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	cmdErr := cmd.Run()
+	stdout.Close()
+	stderr.Close()
 
 	detailsJson, err = os.ReadFile(detailsPath)
 	if err != nil {
-		logrus.Warnf("Failed to read details: %v", err)
+		logging.FromContext(ctx).Warnf("Failed to read details: %v", err)
 	}
 	if err := json.Unmarshal(detailsJson, &details); err != nil {
-		logrus.Warnf("Failed to unmarshal details: %v", err)
+		logging.FromContext(ctx).Warnf("Failed to unmarshal details: %v", err)
 	}
 
 	if cmdErr != nil {
@@ -175,7 +179,7 @@ func (g *DenoAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 			Status:  "Judge Error",
 			Message: "Judge process exited abnormally",
 		}); err != nil {
-			logrus.Warnf("Failed to report error: %v", err)
+			logging.FromContext(ctx).Warnf("Failed to report error: %v", err)
 		}
 
 		details.Summary += "\n\n"
@@ -183,7 +187,7 @@ func (g *DenoAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 	}
 
 	if err := task.UploadDetails(ctx, &details); err != nil {
-		logrus.Warnf("Failed to save details: %v", err)
+		logging.FromContext(ctx).Warnf("Failed to save details: %v", err)
 	}
 
 	return nil
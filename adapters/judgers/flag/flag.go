@@ -39,7 +39,7 @@ func (g *FlagAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 
 	solutionDir, err := utils.UnzipTemp(task.SolutionData(), "solution-*")
 	if err != nil {
-		return err
+		return judge.BadSolutionArchive(err)
 	}
 	defer os.RemoveAll(solutionDir)
 
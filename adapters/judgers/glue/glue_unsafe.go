@@ -17,8 +17,8 @@ import (
 
 	"github.com/fedstackjs/azukiiro/common"
 	"github.com/fedstackjs/azukiiro/judge"
+	"github.com/fedstackjs/azukiiro/logging"
 	"github.com/fedstackjs/azukiiro/storage"
-	"github.com/sirupsen/logrus"
 )
 
 func init() {
@@ -51,18 +51,18 @@ func (g *GlueAdapter) reportHandler(ctx context.Context, task judge.JudgeTask, p
 		}
 		k, v, err := parseKVLine(line)
 		if err != nil {
-			logrus.Warnf("Failed to parse report line: %v", err)
+			logging.FromContext(ctx).Warnf("Failed to parse report line: %v", err)
 			continue
 		}
 		switch k {
 		case "score":
 			score, err := strconv.ParseFloat(v, 64)
 			if err != nil {
-				logrus.Warnf("Failed to parse score: %v", err)
+				logging.FromContext(ctx).Warnf("Failed to parse score: %v", err)
 				continue
 			}
 			if score < 0 || score > 100 {
-				logrus.Warnf("Invalid score: %v", score)
+				logging.FromContext(ctx).Warnf("Invalid score: %v", score)
 				continue
 			}
 			request.Score = score
@@ -73,13 +73,13 @@ func (g *GlueAdapter) reportHandler(ctx context.Context, task judge.JudgeTask, p
 		case "metrics":
 			metrics := make(map[string]float64)
 			if err := json.Unmarshal([]byte(v), &metrics); err != nil {
-				logrus.Warnf("Failed to parse metrics: %v", err)
+				logging.FromContext(ctx).Warnf("Failed to parse metrics: %v", err)
 				continue
 			}
 			request.Metrics = &metrics
 		case "commit":
 			if err := task.Update(ctx, &request); err != nil {
-				logrus.Warnf("Failed to commit report: %v", err)
+				logging.FromContext(ctx).Warnf("Failed to commit report: %v", err)
 			}
 		}
 	}
@@ -106,7 +106,7 @@ func (g *GlueAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 		os.WriteFile(scriptPath, []byte(fullScript), 0700)
 		// Warn if Command is set
 		if len(adapterConfig.Command) > 0 {
-			logrus.Warnf("Command is set, run script will be ignored")
+			logging.FromContext(ctx).Warnf("Command is set, run script will be ignored")
 		} else {
 			adapterConfig.Command = []string{scriptPath}
 		}
@@ -148,16 +148,20 @@ func (g *GlueAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 	cmd.Env = append(cmd.Env, "GLUE_SOLUTION_DATA="+solutionData)
 	cmd.Env = append(cmd.Env, "GLUE_REPORT="+reportPath)
 	cmd.Env = append(cmd.Env, "GLUE_DETAILS="+detailsPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	stdout := logging.Writer(ctx, "stdout")
+	stderr := logging.Writer(ctx, "stderr")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	cmdErr := cmd.Run()
+	stdout.Close()
+	stderr.Close()
 
 	detailsJson, err = os.ReadFile(detailsPath)
 	if err != nil {
-		logrus.Warnf("Failed to read details: %v", err)
+		logging.FromContext(ctx).Warnf("Failed to read details: %v", err)
 	}
 	if err := json.Unmarshal(detailsJson, &details); err != nil {
-		logrus.Warnf("Failed to unmarshal details: %v", err)
+		logging.FromContext(ctx).Warnf("Failed to unmarshal details: %v", err)
 	}
 
 	if cmdErr != nil {
@@ -166,7 +170,7 @@ func (g *GlueAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 			Status:  "Judge Error",
 			Message: "Judge process exited abnormally",
 		}); err != nil {
-			logrus.Warnf("Failed to report error: %v", err)
+			logging.FromContext(ctx).Warnf("Failed to report error: %v", err)
 		}
 
 		details.Summary += "\n\n"
@@ -174,7 +178,7 @@ func (g *GlueAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 	}
 
 	if err := task.UploadDetails(ctx, &details); err != nil {
-		logrus.Warnf("Failed to save details: %v", err)
+		logging.FromContext(ctx).Warnf("Failed to save details: %v", err)
 	}
 
 	return nil
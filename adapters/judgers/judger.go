@@ -5,6 +5,7 @@ import (
 	_ "github.com/fedstackjs/azukiiro/adapters/judgers/dummy"
 	_ "github.com/fedstackjs/azukiiro/adapters/judgers/flag"
 	_ "github.com/fedstackjs/azukiiro/adapters/judgers/glue"
+	_ "github.com/fedstackjs/azukiiro/adapters/judgers/native"
 	_ "github.com/fedstackjs/azukiiro/adapters/judgers/uoj"
 	_ "github.com/fedstackjs/azukiiro/adapters/judgers/vjudge"
 )
@@ -0,0 +1,63 @@
+//go:build linux
+
+package native
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+type checker func(output []byte, answer []byte) bool
+
+func exactChecker(output []byte, answer []byte) bool {
+	return bytes.Equal(output, answer)
+}
+
+// linesChecker ignores trailing whitespace on each line and trailing empty lines
+func linesChecker(output []byte, answer []byte) bool {
+	normalize := func(content []byte) [][]byte {
+		lines := bytes.Split(content, []byte("\n"))
+		for i, line := range lines {
+			lines[i] = bytes.TrimRight(line, " \t\r")
+		}
+		for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+			lines = lines[:len(lines)-1]
+		}
+		return lines
+	}
+	outputLines := normalize(output)
+	answerLines := normalize(answer)
+	if len(outputLines) != len(answerLines) {
+		return false
+	}
+	for i := range outputLines {
+		if !bytes.Equal(outputLines[i], answerLines[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func getChecker(name string) (checker, error) {
+	switch name {
+	case "", "lines":
+		return linesChecker, nil
+	case "exact":
+		return exactChecker, nil
+	default:
+		return nil, fmt.Errorf("unknown checker: %s", name)
+	}
+}
+
+func checkFiles(check checker, outputPath string, answerPath string) (bool, error) {
+	output, err := os.ReadFile(outputPath)
+	if err != nil {
+		return false, err
+	}
+	answer, err := os.ReadFile(answerPath)
+	if err != nil {
+		return false, err
+	}
+	return check(output, answer), nil
+}
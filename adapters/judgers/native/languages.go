@@ -0,0 +1,39 @@
+//go:build linux
+
+package native
+
+type language struct {
+	Source  string
+	Compile []string
+	Run     []string
+}
+
+func cxx(std string) language {
+	return language{
+		Source:  "main.cpp",
+		Compile: []string{"g++", "-O2", "-std=" + std, "-DONLINE_JUDGE", "-o", "main", "main.cpp", "-lm"},
+		Run:     []string{"./main"},
+	}
+}
+
+var languages = map[string]language{
+	"C": {
+		Source:  "main.c",
+		Compile: []string{"gcc", "-O2", "-std=c11", "-DONLINE_JUDGE", "-o", "main", "main.c", "-lm"},
+		Run:     []string{"./main"},
+	},
+	"C++":   cxx("c++17"),
+	"C++11": cxx("c++11"),
+	"C++14": cxx("c++14"),
+	"C++17": cxx("c++17"),
+	"C++20": cxx("c++20"),
+	"Python3": {
+		Source: "main.py",
+		Run:    []string{"python3", "main.py"},
+	},
+}
+
+const defaultLanguage = "C++17"
+
+// Submissions made through the plain editor are named answer.code
+const fallbackSource = "answer.code"
@@ -0,0 +1,442 @@
+//go:build linux
+
+package native
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/judge"
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/fedstackjs/azukiiro/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("judge.native.cgroupPath", "/sys/fs/cgroup/azukiiro")
+	judge.RegisterAdapter(&NativeAdapter{})
+}
+
+type NativeSubtask struct {
+	Name  string   `json:"name"`
+	Score float64  `json:"score"`
+	Type  string   `json:"type"`
+	Tests []string `json:"tests"`
+}
+
+type NativeAdapterConfig struct {
+	// Time limit per test in milliseconds
+	TimeLimit int `json:"timeLimit"`
+	// Memory limit per test in MiB
+	MemoryLimit int `json:"memoryLimit"`
+	// Output limit per test in MiB
+	OutputLimit int             `json:"outputLimit"`
+	PidsLimit   int             `json:"pidsLimit"`
+	Checker     string          `json:"checker"`
+	DataDir     string          `json:"dataDir"`
+	Subtasks    []NativeSubtask `json:"subtasks"`
+}
+
+type SolutionMetadata struct {
+	Language string `json:"language"`
+}
+
+type NativeAdapter struct{}
+
+func (n *NativeAdapter) Name() string {
+	return "native"
+}
+
+var compileLimits = sandboxLimits{
+	Time:   10 * time.Second,
+	Memory: 1024 << 20,
+	Pids:   64,
+	Output: 1 << 20,
+}
+
+type nativeTest struct {
+	name   string
+	input  string
+	answer string
+}
+
+type nativeSubtask struct {
+	name  string
+	score float64
+	min   bool
+	tests []nativeTest
+}
+
+func resolveTest(problemDir string, name string) (nativeTest, error) {
+	base := filepath.Join(problemDir, filepath.Clean("/"+name))
+	test := nativeTest{name: name, input: base + ".in"}
+	if _, err := os.Stat(test.input); err != nil {
+		return test, fmt.Errorf("input of test %s not found", name)
+	}
+	for _, ext := range []string{".ans", ".out"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			test.answer = base + ext
+			return test, nil
+		}
+	}
+	return test, fmt.Errorf("answer of test %s not found", name)
+}
+
+func loadSubtasks(problemDir string, config *NativeAdapterConfig) ([]nativeSubtask, error) {
+	if len(config.Subtasks) == 0 {
+		dataDir := filepath.Join(problemDir, filepath.Clean("/"+config.DataDir))
+		inputs, err := filepath.Glob(filepath.Join(dataDir, "*.in"))
+		if err != nil {
+			return nil, err
+		}
+		if len(inputs) == 0 {
+			return nil, fmt.Errorf("no tests found in problem data")
+		}
+		slices.SortFunc(inputs, func(a, b string) int {
+			if len(a) != len(b) {
+				return len(a) - len(b)
+			}
+			return strings.Compare(a, b)
+		})
+		config.Subtasks = []NativeSubtask{{Name: "Default", Score: 100}}
+		for _, input := range inputs {
+			rel, _ := filepath.Rel(problemDir, strings.TrimSuffix(input, ".in"))
+			config.Subtasks[0].Tests = append(config.Subtasks[0].Tests, rel)
+		}
+	}
+	result := []nativeSubtask{}
+	for i, subtask := range config.Subtasks {
+		if len(subtask.Tests) == 0 {
+			return nil, fmt.Errorf("subtask %d has no tests", i+1)
+		}
+		if subtask.Name == "" {
+			subtask.Name = fmt.Sprintf("Subtask %d", i+1)
+		}
+		item := nativeSubtask{name: subtask.Name, score: subtask.Score, min: subtask.Type == "min"}
+		for _, name := range subtask.Tests {
+			test, err := resolveTest(problemDir, name)
+			if err != nil {
+				return nil, err
+			}
+			item.tests = append(item.tests, test)
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func readSource(solutionDir string, lang language) ([]byte, error) {
+	for _, name := range []string{lang.Source, fallbackSource} {
+		content, err := os.ReadFile(filepath.Join(solutionDir, name))
+		if err == nil {
+			return content, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &judge.SimpleSolutionError{
+		S: "Bad Solution",
+		M: "Source file not found",
+		D: fmt.Sprintf("Expected %s in solution", lang.Source),
+	}
+}
+
+func statusOf(res *sandboxResult) string {
+	switch res.Status {
+	case runTimeLimitExceeded:
+		return "Time Limit Exceed"
+	case runMemoryLimitExceeded:
+		return "Memory Limit Exceed"
+	case runOutputLimitExceeded:
+		return "Output Limit Exceed"
+	case runRuntimeError:
+		return "Runtime Error"
+	default:
+		return "Accepted"
+	}
+}
+
+func toCodeBlock(v interface{}) string {
+	return fmt.Sprintf("```\n%s\n```", v)
+}
+
+type nativeJudge struct {
+	sandbox  *sandbox
+	check    checker
+	limits   sandboxLimits
+	buildDir string
+	workDir  string
+	run      []string
+
+	maxTime   time.Duration
+	maxMemory int64
+}
+
+func (j *nativeJudge) runTest(ctx context.Context, test nativeTest) (*common.SolutionDetailsTest, error) {
+	runDir, err := j.sandbox.mkdirWork("run-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(runDir)
+	if err := os.CopyFS(runDir, os.DirFS(j.buildDir)); err != nil {
+		return nil, err
+	}
+	outputPath := filepath.Join(j.workDir, "output")
+	defer os.Remove(outputPath)
+
+	res, err := j.sandbox.run(ctx, &sandboxCmd{
+		Args:   j.run,
+		Dir:    runDir,
+		Stdin:  test.input,
+		Stdout: outputPath,
+		Limits: j.limits,
+	})
+	if err != nil {
+		return nil, err
+	}
+	j.maxTime = max(j.maxTime, res.CPUTime)
+	j.maxMemory = max(j.maxMemory, res.Memory)
+
+	status := statusOf(res)
+	if res.Status == runOK {
+		ok, err := checkFiles(j.check, outputPath, test.answer)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			status = "Wrong Answer"
+		}
+	}
+	result := &common.SolutionDetailsTest{
+		Name:    test.name,
+		Status:  status,
+		Summary: fmt.Sprintf("Time: `%d ms`\tMemory: `%d KB`", res.CPUTime.Milliseconds(), res.Memory>>10),
+	}
+	if status == "Accepted" {
+		result.Score = 100
+	}
+	if res.Status == runRuntimeError {
+		result.Summary += fmt.Sprintf("\n\nExit code: `%d`", res.ExitCode)
+		if res.Stderr != "" {
+			result.Summary += "\n\nStderr:\n\n" + toCodeBlock(res.Stderr)
+		}
+	}
+	return result, nil
+}
+
+func (n *NativeAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
+	config := task.Config()
+
+	adapterConfig := NativeAdapterConfig{
+		TimeLimit:   1000,
+		MemoryLimit: 256,
+		OutputLimit: 64,
+		PidsLimit:   16,
+	}
+	if err := json.Unmarshal([]byte(config.Judge.Config), &adapterConfig); err != nil {
+		return err
+	}
+	check, err := getChecker(adapterConfig.Checker)
+	if err != nil {
+		return err
+	}
+	sb, err := newSandbox()
+	if err != nil {
+		return err
+	}
+
+	problemDir, release, err := storage.Extract(ctx, task.ProblemData())
+	if err != nil {
+		return err
+	}
+	defer release()
+	subtasks, err := loadSubtasks(problemDir, &adapterConfig)
+	if err != nil {
+		return err
+	}
+
+	solutionDir, err := utils.UnzipTemp(task.SolutionData(), "solution-*")
+	if err != nil {
+		return judge.BadSolutionArchive(err)
+	}
+	defer os.RemoveAll(solutionDir)
+
+	languageName := defaultLanguage
+	if content, err := os.ReadFile(filepath.Join(solutionDir, ".metadata.json")); err == nil {
+		var metadata SolutionMetadata
+		if err := json.Unmarshal(content, &metadata); err != nil {
+			return &judge.SimpleSolutionError{
+				S: "Bad Solution",
+				M: "Invalid metadata",
+				D: fmt.Sprintf("Failed to parse .metadata.json: %v", err),
+			}
+		}
+		if metadata.Language != "" {
+			languageName = metadata.Language
+		}
+	}
+	lang, ok := languages[languageName]
+	if !ok {
+		return &judge.SimpleSolutionError{
+			S: "Bad Solution",
+			M: "Unsupported language",
+			D: fmt.Sprintf("Language %s is not supported", languageName),
+		}
+	}
+	source, err := readSource(solutionDir, lang)
+	if err != nil {
+		return err
+	}
+
+	buildDir, err := sb.mkdirWork("build-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(buildDir)
+	if err := os.WriteFile(filepath.Join(buildDir, lang.Source), source, 0644); err != nil {
+		return err
+	}
+	workDir, err := storage.MkdirTemp("work-native-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	if lang.Compile != nil {
+		if err := task.Update(ctx, &common.SolutionInfo{
+			Score:   0,
+			Status:  "Running",
+			Message: "Compiling",
+		}); err != nil {
+			return err
+		}
+		compileLog := filepath.Join(workDir, "compile.log")
+		res, err := sb.run(ctx, &sandboxCmd{
+			Args:   lang.Compile,
+			Dir:    buildDir,
+			Stderr: compileLog,
+			Limits: compileLimits,
+		})
+		if err != nil {
+			return err
+		}
+		if res.Status != runOK {
+			logging.FromContext(ctx).Infof("Compilation failed: %s", statusOf(res))
+			if err := task.Update(ctx, &common.SolutionInfo{
+				Score:   0,
+				Status:  "Compile Error",
+				Message: "Compile Error",
+			}); err != nil {
+				return err
+			}
+			details := &common.SolutionDetails{
+				Version: 1,
+				Jobs:    []*common.SolutionDetailsJob{},
+				Summary: fmt.Sprintf("Compilation failed (%s):\n\n%s", statusOf(res), toCodeBlock(res.Stderr)),
+			}
+			// The summary only has the head of the output, the full log is attached
+			if artifact, err := judge.UploadArtifactFile(ctx, task, "compile.log", compileLog); err != nil {
+				logging.FromContext(ctx).Warnln("Failed to upload compile log:", err)
+			} else {
+				details.Artifacts = append(details.Artifacts, artifact)
+			}
+			return task.UploadDetails(ctx, details)
+		}
+	}
+
+	j := &nativeJudge{
+		sandbox:  sb,
+		check:    check,
+		buildDir: buildDir,
+		workDir:  workDir,
+		run:      lang.Run,
+		limits: sandboxLimits{
+			Time:   time.Duration(adapterConfig.TimeLimit) * time.Millisecond,
+			Memory: int64(adapterConfig.MemoryLimit) << 20,
+			Pids:   int64(adapterConfig.PidsLimit),
+			Output: int64(adapterConfig.OutputLimit) << 20,
+		},
+	}
+
+	info := common.SolutionInfo{
+		Status:  "Accepted",
+		Message: "Native Judger OK",
+	}
+	details := common.SolutionDetails{
+		Version: 1,
+		Jobs:    []*common.SolutionDetailsJob{},
+		Summary: fmt.Sprintf("Language: `%s`", languageName),
+	}
+	total, finished, totalScale, score := 0, 0, 0.0, 0.0
+	for _, subtask := range subtasks {
+		total += len(subtask.tests)
+		totalScale += subtask.score
+	}
+	for _, subtask := range subtasks {
+		job := &common.SolutionDetailsJob{
+			Name:       subtask.name,
+			ScoreScale: subtask.score,
+			Status:     "Accepted",
+			Tests:      []*common.SolutionDetailsTest{},
+		}
+		accepted := 0
+		for _, test := range subtask.tests {
+			finished++
+			if subtask.min && job.Status != "Accepted" {
+				job.Tests = append(job.Tests, &common.SolutionDetailsTest{
+					Name:   test.name,
+					Status: "Skipped",
+				})
+				continue
+			}
+			if err := task.Update(ctx, &common.SolutionInfo{
+				Score:   0,
+				Status:  "Running",
+				Message: fmt.Sprintf("Running on test %d/%d", finished, total),
+			}); err != nil {
+				return err
+			}
+			result, err := j.runTest(ctx, test)
+			if err != nil {
+				return err
+			}
+			result.ScoreScale = subtask.score / float64(len(subtask.tests))
+			if result.Status == "Accepted" {
+				accepted++
+			} else if job.Status == "Accepted" {
+				job.Status = result.Status
+			}
+			job.Tests = append(job.Tests, result)
+		}
+		if !subtask.min || accepted == len(subtask.tests) {
+			job.Score = float64(accepted) / float64(len(subtask.tests)) * 100
+		}
+		if totalScale > 0 {
+			score += job.Score * subtask.score / totalScale
+		}
+		if info.Status == "Accepted" && job.Status != "Accepted" {
+			info.Status = job.Status
+		}
+		details.Jobs = append(details.Jobs, job)
+	}
+
+	info.Score = score
+	info.Metrics = &map[string]float64{
+		"cpu": float64(j.maxTime.Milliseconds()),
+		"mem": float64(j.maxMemory >> 10),
+	}
+	if err := task.Update(ctx, &info); err != nil {
+		return err
+	}
+	return task.UploadDetails(ctx, &details)
+}
@@ -0,0 +1,257 @@
+//go:build linux
+
+package native
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// The sandbox starts the runner binary again under this name to build the root filesystem of the
+// submission inside its new mount namespace before executing it, as exec.Cmd cannot run code
+// between clone and exec.
+const sandboxInitName = "azukiiro-sandbox-init"
+
+// Paths of the host bind-mounted read-only into the sandbox unless judge.native.mounts is set.
+// Missing paths are skipped and symlinks are recreated, so merged /usr layouts work.
+var defaultMounts = []string{
+	"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/libx32",
+	"/etc/alternatives", "/etc/ld.so.cache", "/etc/ld.so.conf", "/etc/ld.so.conf.d",
+}
+
+// Devices bound into the /dev of the sandbox
+var sandboxDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom"}
+
+// File descriptors passed to the helper after stdin, stdout and stderr
+const (
+	// Setup errors are written here, it is closed on exec
+	statusFd = 3
+	// cgroup.procs of the cgroup of the submission, if any
+	cgroupFd = 4
+)
+
+type rootfsSpec struct {
+	// Empty host directory the tmpfs root is mounted on
+	Root string `json:"root"`
+	// Work directory, bind-mounted writable at the same path
+	Dir    string   `json:"dir"`
+	Mounts []string `json:"mounts"`
+	Uid    int      `json:"uid"`
+	Gid    int      `json:"gid"`
+	Args   []string `json:"args"`
+	// Whether cgroup.procs of the cgroup to join is passed
+	Cgroup bool `json:"cgroup"`
+}
+
+func init() {
+	if len(os.Args) == 2 && os.Args[0] == sandboxInitName {
+		sandboxInit(os.Args[1])
+	}
+}
+
+// sandboxInit sets up the sandbox and executes the submission, it only returns by exiting
+func sandboxInit(specJson string) {
+	status := os.NewFile(statusFd, "status")
+	// Without the flag the submission would inherit the pipe and keep the runner waiting
+	unix.CloseOnExec(statusFd)
+	err := func() error {
+		var spec rootfsSpec
+		if err := json.Unmarshal([]byte(specJson), &spec); err != nil {
+			return err
+		}
+		runtime.LockOSThread()
+		if err := spec.setup(); err != nil {
+			return err
+		}
+		path, err := exec.LookPath(spec.Args[0])
+		if err != nil {
+			return err
+		}
+		return unix.Exec(path, spec.Args, os.Environ())
+	}()
+	fmt.Fprint(status, err)
+	os.Exit(127)
+}
+
+// setup builds the root, switches to it and drops privileges
+func (spec *rootfsSpec) setup() error {
+	// Keep the mounts below from propagating to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	if err := unix.Mount("tmpfs", spec.Root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0755,size=16m"); err != nil {
+		return fmt.Errorf("mount root: %w", err)
+	}
+	for _, path := range spec.Mounts {
+		if err := bindHost(spec.Root, path, unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(filepath.Join(spec.Root, "dev"), 0755); err != nil {
+		return err
+	}
+	for _, path := range sandboxDevices {
+		if err := bindHost(spec.Root, path, unix.MS_NOSUID|unix.MS_NOEXEC); err != nil {
+			return err
+		}
+	}
+	tmp := filepath.Join(spec.Root, "tmp")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", tmp, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777,size=64m"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+	proc := filepath.Join(spec.Root, "proc")
+	if err := os.Mkdir(proc, 0755); err != nil {
+		return err
+	}
+	// The process runs in a new PID namespace, so only its own processes are visible
+	if err := unix.Mount("proc", proc, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mount /proc: %w", err)
+	}
+	// Bound last, so the mounts above cannot hide it
+	if err := bind(spec.Dir, filepath.Join(spec.Root, spec.Dir), true, unix.MS_NOSUID|unix.MS_NODEV); err != nil {
+		return err
+	}
+
+	if err := unix.Chdir(spec.Root); err != nil {
+		return err
+	}
+	// Stacks the old root below the new one, so it can be detached without a directory for it
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot root: %w", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("detach old root: %w", err)
+	}
+	if err := unix.Mount("", "/", "", unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remount root read-only: %w", err)
+	}
+	if err := unix.Chdir(spec.Dir); err != nil {
+		return err
+	}
+
+	// Join the cgroup last, so the memory used by the helper is not counted
+	if spec.Cgroup {
+		cgroup := os.NewFile(cgroupFd, "cgroup.procs")
+		if _, err := cgroup.WriteString("0"); err != nil {
+			return fmt.Errorf("join cgroup: %w", err)
+		}
+		cgroup.Close()
+	}
+	if err := unix.Setgroups(nil); err != nil {
+		return err
+	}
+	if err := unix.Setgid(spec.Gid); err != nil {
+		return err
+	}
+	if err := unix.Setuid(spec.Uid); err != nil {
+		return err
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	// Changing credentials clears the parent death signal
+	return unix.Prctl(unix.PR_SET_PDEATHSIG, uintptr(unix.SIGKILL), 0, 0, 0)
+}
+
+// bindHost makes a host path available at the same path below root
+func bindHost(root string, path string, flags uintptr) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	}
+	return bind(path, target, info.IsDir(), flags)
+}
+
+// bind mounts source on target with flags, creating target as an empty directory or file
+func bind(source string, target string, dir bool, flags uintptr) error {
+	if dir {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(target, nil, 0644); err != nil {
+		return err
+	}
+	if err := unix.Mount(source, target, "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", source, err)
+	}
+	// Flags of a bind mount only take effect on remount
+	if err := unix.Mount("", target, "", unix.MS_REMOUNT|unix.MS_BIND|flags, ""); err != nil {
+		return fmt.Errorf("remount %s: %w", source, err)
+	}
+	return nil
+}
+
+// command returns the command running c as the sandbox user in a minimal root, and the pipe reporting
+// whether the sandbox was set up. The process joins the cgroup behind cgroupProcs if it is not nil.
+func (s *sandbox) command(c *sandboxCmd, root string, cgroupProcs *os.File) (*exec.Cmd, *os.File, error) {
+	spec, err := json.Marshal(&rootfsSpec{
+		Root:   root,
+		Dir:    c.Dir,
+		Mounts: s.mounts,
+		Uid:    int(s.credential.Uid),
+		Gid:    int(s.credential.Gid),
+		Args:   c.Args,
+		Cgroup: cgroupProcs != nil,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	status, statusWriter, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd := &exec.Cmd{
+		Path:       "/proc/self/exe",
+		Args:       []string{sandboxInitName, string(spec)},
+		Env:        []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=" + c.Dir, "TMPDIR=/tmp", "LANG=C.UTF-8"},
+		ExtraFiles: []*os.File{statusWriter},
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+			Pdeathsig:  syscall.SIGKILL,
+		},
+	}
+	if cgroupProcs != nil {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, cgroupProcs)
+	}
+	return cmd, status, nil
+}
+
+// started waits until the sandboxed program of cmd was executed, returning the setup error otherwise
+func started(cmd *exec.Cmd, status *os.File) error {
+	// Only the process may keep the write end open, so the read below ends once it executes or exits
+	cmd.ExtraFiles[0].Close()
+	msg, err := io.ReadAll(status)
+	if err != nil {
+		return err
+	}
+	if len(msg) > 0 {
+		return fmt.Errorf("failed to set up sandbox: %s", msg)
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+//go:build linux
+
+package native
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+type runStatus int
+
+const (
+	runOK runStatus = iota
+	runTimeLimitExceeded
+	runMemoryLimitExceeded
+	runOutputLimitExceeded
+	runRuntimeError
+)
+
+type sandboxLimits struct {
+	Time   time.Duration
+	Memory int64
+	Pids   int64
+	Output int64
+}
+
+type sandboxCmd struct {
+	Args   []string
+	Dir    string
+	Stdin  string
+	Stdout string
+	// File to write the complete stderr to, up to the output limit
+	Stderr string
+	Limits sandboxLimits
+}
+
+type sandboxResult struct {
+	Status   runStatus
+	ExitCode int
+	CPUTime  time.Duration
+	WallTime time.Duration
+	Memory   int64
+	Stderr   string
+}
+
+type sandbox struct {
+	cgroupPath string
+	workPath   string
+	credential *syscall.Credential
+	mounts     []string
+}
+
+func newSandbox() (*sandbox, error) {
+	s := &sandbox{
+		cgroupPath: viper.GetString("judge.native.cgroupPath"),
+		workPath:   viper.GetString("judge.native.workPath"),
+	}
+	if s.workPath == "" {
+		// The storage path is only known once the config is loaded
+		s.workPath = filepath.Join(storage.GetRootPath(), "sandbox")
+	}
+	if err := os.MkdirAll(s.cgroupPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	// Controllers may already be enabled by the service manager
+	if err := os.WriteFile(filepath.Join(s.cgroupPath, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644); err != nil {
+		logrus.Warnf("Failed to enable cgroup controllers: %v", err)
+	}
+	// The sandbox user must be able to enter its own work dir, but not list others
+	if err := os.MkdirAll(s.workPath, 0711); err != nil {
+		return nil, fmt.Errorf("failed to create sandbox work dir: %w", err)
+	}
+	// Submissions running as the runner could read its config and key, or tamper with other tasks
+	if os.Geteuid() != 0 {
+		return nil, errors.New("native sandbox must run as root to isolate submissions")
+	}
+	uid, gid := viper.GetInt("judge.native.uid"), viper.GetInt("judge.native.gid")
+	if uid <= 0 || gid <= 0 {
+		return nil, errors.New("judge.native.uid and judge.native.gid must name a dedicated unprivileged user")
+	}
+	s.credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	s.mounts = defaultMounts
+	if viper.IsSet("judge.native.mounts") {
+		s.mounts = viper.GetStringSlice("judge.native.mounts")
+	}
+	return s, nil
+}
+
+// mkdirWork creates a directory writable by the sandboxed process
+func (s *sandbox) mkdirWork(pattern string) (string, error) {
+	dir, err := os.MkdirTemp(s.workPath, pattern)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	if s.credential != nil {
+		if err := os.Chown(dir, int(s.credential.Uid), int(s.credential.Gid)); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+type cgroup struct {
+	path string
+}
+
+func (s *sandbox) createCgroup(limits sandboxLimits) (*cgroup, error) {
+	path, err := os.MkdirTemp(s.cgroupPath, "run-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	cg := &cgroup{path: path}
+	if err := cg.write("memory.max", strconv.FormatInt(limits.Memory, 10)); err != nil {
+		cg.remove()
+		return nil, err
+	}
+	// Swap accounting is optional, ignore if not available
+	if err := cg.write("memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		cg.remove()
+		return nil, err
+	}
+	if err := cg.write("pids.max", strconv.FormatInt(limits.Pids, 10)); err != nil {
+		cg.remove()
+		return nil, err
+	}
+	return cg, nil
+}
+
+func (cg *cgroup) write(name string, value string) error {
+	return os.WriteFile(filepath.Join(cg.path, name), []byte(value), 0644)
+}
+
+func (cg *cgroup) readKeyed(name string, key string) int64 {
+	content, err := os.ReadFile(filepath.Join(cg.path, name))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		k, v, found := strings.Cut(line, " ")
+		if found && k == key {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+func (cg *cgroup) cpuUsage() time.Duration {
+	return time.Duration(cg.readKeyed("cpu.stat", "usage_usec")) * time.Microsecond
+}
+
+func (cg *cgroup) memoryPeak() int64 {
+	content, err := os.ReadFile(filepath.Join(cg.path, "memory.peak"))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	return n
+}
+
+func (cg *cgroup) oomKilled() bool {
+	return cg.readKeyed("memory.events", "oom_kill") > 0
+}
+
+func (cg *cgroup) kill() {
+	if err := cg.write("cgroup.kill", "1"); err != nil {
+		logrus.Warnf("Failed to kill cgroup %s: %v", cg.path, err)
+	}
+}
+
+func (cg *cgroup) remove() {
+	// Killed processes may take a moment to be reaped
+	for i := 0; i < 50; i++ {
+		err := os.Remove(cg.path)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	logrus.Warnf("Failed to remove cgroup %s", cg.path)
+}
+
+// limitWriter calls onExceed once more than n bytes are written
+type limitWriter struct {
+	w        io.Writer
+	n        int64
+	onExceed func()
+	exceeded atomic.Bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		if !l.exceeded.Swap(true) {
+			l.onExceed()
+		}
+		return 0, fmt.Errorf("output limit exceeded")
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// tailBuffer keeps at most max bytes of the head of the stream
+type tailBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	if remaining := t.max - t.buf.Len(); remaining > 0 {
+		t.buf.Write(p[:min(len(p), remaining)])
+	}
+	return len(p), nil
+}
+
+func (s *sandbox) run(ctx context.Context, c *sandboxCmd) (*sandboxResult, error) {
+	cg, err := s.createCgroup(c.Limits)
+	if err != nil {
+		return nil, err
+	}
+	defer cg.remove()
+	cgroupProcs, err := os.OpenFile(filepath.Join(cg.path, "cgroup.procs"), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer cgroupProcs.Close()
+	root, err := os.MkdirTemp(s.workPath, "root-")
+	if err != nil {
+		return nil, err
+	}
+	// The root is only mounted in the namespace of the sandbox, the host sees an empty directory
+	defer os.Remove(root)
+
+	cmd, status, err := s.command(c, root, cgroupProcs)
+	if err != nil {
+		return nil, err
+	}
+	defer status.Close()
+	if c.Stdin != "" {
+		stdin, err := os.Open(c.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		defer stdin.Close()
+		cmd.Stdin = stdin
+	}
+	var stdout io.Writer = io.Discard
+	if c.Stdout != "" {
+		file, err := os.Create(c.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		stdout = file
+	}
+	output := &limitWriter{w: stdout, n: c.Limits.Output, onExceed: cg.kill}
+	cmd.Stdout = output
+	stderr := &tailBuffer{max: 4096}
+	cmd.Stderr = stderr
+	// Only set if the complete stderr is kept, which then counts against the output limit too
+	var errOutput *limitWriter
+	if c.Stderr != "" {
+		file, err := os.Create(c.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		errOutput = &limitWriter{w: file, n: c.Limits.Output, onExceed: cg.kill}
+		cmd.Stderr = io.MultiWriter(stderr, errOutput)
+	}
+
+	if err := cmd.Start(); err != nil {
+		cmd.ExtraFiles[0].Close()
+		return nil, fmt.Errorf("failed to start sandbox: %w", err)
+	}
+	if err := started(cmd, status); err != nil {
+		cmd.Wait()
+		return nil, err
+	}
+	start := time.Now()
+	// CPU time is checked after exit, the wall clock limit catches sleeping processes
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(c.Limits.Time*2+time.Second, func() {
+		timedOut.Store(true)
+		cg.kill()
+	})
+	stop := context.AfterFunc(ctx, cg.kill)
+	cmd.Wait()
+	timer.Stop()
+	stop()
+	// Make sure no orphan survives before the cgroup is removed
+	cg.kill()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result := &sandboxResult{
+		Status:   runOK,
+		ExitCode: cmd.ProcessState.ExitCode(),
+		CPUTime:  cg.cpuUsage(),
+		WallTime: time.Since(start),
+		Memory:   cg.memoryPeak(),
+		Stderr:   stderr.buf.String(),
+	}
+	switch {
+	case output.exceeded.Load() || (errOutput != nil && errOutput.exceeded.Load()):
+		result.Status = runOutputLimitExceeded
+	case cg.oomKilled():
+		result.Status = runMemoryLimitExceeded
+	case timedOut.Load() || result.CPUTime > c.Limits.Time:
+		result.Status = runTimeLimitExceeded
+	case !cmd.ProcessState.Success():
+		result.Status = runRuntimeError
+	}
+	return result, nil
+}
@@ -0,0 +1,208 @@
+//go:build linux
+
+package native
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const nobody = 65534
+
+func requireRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("sandbox tests must run as root")
+	}
+}
+
+// newTestSandbox returns a sandbox with a cgroup, skipping the test if cgroups v2 controllers are not available
+func newTestSandbox(t *testing.T) *sandbox {
+	requireRoot(t)
+	controllers, err := os.ReadFile("/sys/fs/cgroup/cgroup.controllers")
+	for _, name := range []string{"cpu", "memory", "pids"} {
+		if err != nil || !strings.Contains(string(controllers), name) {
+			t.Skip("cgroups v2 with cpu, memory and pids controllers is not available")
+		}
+	}
+	viper.Set("judge.native.cgroupPath", "/sys/fs/cgroup/azukiiro-test")
+	viper.Set("judge.native.workPath", t.TempDir())
+	viper.Set("judge.native.uid", nobody)
+	viper.Set("judge.native.gid", nobody)
+	s, err := newSandbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+var testLimits = sandboxLimits{
+	Time:   time.Second,
+	Memory: 64 << 20,
+	Pids:   16,
+	Output: 1 << 20,
+}
+
+func runShell(t *testing.T, s *sandbox, script string, limits sandboxLimits) *sandboxResult {
+	dir, err := s.mkdirWork("run-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.run(context.Background(), &sandboxCmd{
+		Args:   []string{"sh", "-c", script},
+		Dir:    dir,
+		Limits: limits,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestNewSandboxRequiresDedicatedUser(t *testing.T) {
+	requireRoot(t)
+	viper.Set("judge.native.cgroupPath", t.TempDir())
+	viper.Set("judge.native.workPath", t.TempDir())
+	viper.Set("judge.native.uid", 0)
+	viper.Set("judge.native.gid", 0)
+	if _, err := newSandbox(); err == nil {
+		t.Fatal("sandbox running submissions as root was created")
+	}
+}
+
+func TestSandboxIsolation(t *testing.T) {
+	requireRoot(t)
+	s := &sandbox{
+		workPath:   t.TempDir(),
+		credential: &syscall.Credential{Uid: nobody, Gid: nobody},
+		mounts:     defaultMounts,
+	}
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("runner-key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := s.mkdirWork("run-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := os.MkdirTemp(s.workPath, "root-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := "id -u; cat " + secret + "; echo pwned > " + secret + "; touch /escaped; ls /proc/1/root/" + filepath.Base(s.workPath) + "; echo ok > result"
+	cmd, status, err := s.command(&sandboxCmd{Args: []string{"sh", "-c", script}, Dir: dir}, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer status.Close()
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := started(cmd, status); err != nil {
+		cmd.Wait()
+		t.Fatal(err)
+	}
+	cmd.Wait()
+
+	if !strings.HasPrefix(stdout.String(), "65534\n") {
+		t.Errorf("submission did not run as the sandbox user: %q", stdout.String())
+	}
+	if strings.Contains(stdout.String(), "runner-key") {
+		t.Error("submission read a file outside the sandbox")
+	}
+	if content, _ := os.ReadFile(secret); string(content) != "runner-key" {
+		t.Errorf("submission wrote a file outside the sandbox: %q", content)
+	}
+	if _, err := os.Stat("/escaped"); err == nil {
+		os.Remove("/escaped")
+		t.Error("submission wrote to the host root")
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "result")); string(content) != "ok\n" {
+		t.Errorf("submission could not write its work dir: %q", content)
+	}
+}
+
+func TestSandboxSetupError(t *testing.T) {
+	requireRoot(t)
+	s := &sandbox{
+		workPath:   t.TempDir(),
+		credential: &syscall.Credential{Uid: nobody, Gid: nobody},
+		mounts:     defaultMounts,
+	}
+	dir, err := s.mkdirWork("run-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, status, err := s.command(&sandboxCmd{Args: []string{"no-such-program"}, Dir: dir}, filepath.Join(s.workPath, "missing"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer status.Close()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	err = started(cmd, status)
+	cmd.Wait()
+	if err == nil {
+		t.Fatal("setup error was not reported")
+	}
+}
+
+func TestSandboxTimeLimit(t *testing.T) {
+	s := newTestSandbox(t)
+	limits := testLimits
+	limits.Time = 200 * time.Millisecond
+	res := runShell(t, s, "while :; do :; done", limits)
+	if res.Status != runTimeLimitExceeded {
+		t.Errorf("status = %v, want time limit exceeded", res.Status)
+	}
+}
+
+func TestSandboxMemoryLimit(t *testing.T) {
+	s := newTestSandbox(t)
+	limits := testLimits
+	limits.Memory = 32 << 20
+	res := runShell(t, s, `a=$(head -c 134217728 /dev/zero | tr '\0' x); echo ${#a}`, limits)
+	if res.Status != runMemoryLimitExceeded {
+		t.Errorf("status = %v, want memory limit exceeded", res.Status)
+	}
+}
+
+func TestSandboxOK(t *testing.T) {
+	s := newTestSandbox(t)
+	res := runShell(t, s, "echo ok > result", testLimits)
+	if res.Status != runOK {
+		t.Errorf("status = %v, want ok: %s", res.Status, res.Stderr)
+	}
+}
+
+func TestSandboxStderrLimit(t *testing.T) {
+	s := newTestSandbox(t)
+	dir, err := s.mkdirWork("run-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := testLimits
+	limits.Output = 1024
+	res, err := s.run(context.Background(), &sandboxCmd{
+		Args:   []string{"sh", "-c", "head -c 65536 /dev/zero >&2"},
+		Dir:    dir,
+		Stderr: filepath.Join(t.TempDir(), "stderr"),
+		Limits: limits,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != runOutputLimitExceeded {
+		t.Errorf("status = %v, want output limit exceeded", res.Status)
+	}
+}
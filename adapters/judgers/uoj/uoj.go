@@ -17,9 +17,9 @@ import (
 
 	"github.com/fedstackjs/azukiiro/common"
 	"github.com/fedstackjs/azukiiro/judge"
+	"github.com/fedstackjs/azukiiro/logging"
 	"github.com/fedstackjs/azukiiro/storage"
 	"github.com/fedstackjs/azukiiro/utils"
-	"github.com/sirupsen/logrus"
 )
 
 func init() {
@@ -253,18 +253,18 @@ func (u *UojAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 	judgerPath := "/opt/uoj_judger"
 
 	// unzip data
-	problemDir, err := utils.UnzipTemp(problemData, "problem-*")
+	problemDir, release, err := storage.Extract(ctx, problemData)
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(problemDir)
+	defer release()
 	problemConf, err := parseProblemConf(problemDir)
 	if err != nil {
 		return err
 	}
 	solutionDir, err := utils.UnzipTemp(solutionData, "solution-*")
 	if err != nil {
-		return err
+		return judge.BadSolutionArchive(err)
 	}
 	defer os.RemoveAll(solutionDir)
 	workDir, err := storage.MkdirTemp("work-uoj-*")
@@ -318,8 +318,15 @@ func (u *UojAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 		"--die-with-parent",
 		"/opt/uoj_judger/main_judger", "/tmp/solution", "/tmp/problem")
 	cmd.Dir = judgerPath
-	logrus.Infof("Running %s", cmd)
-	if err := cmd.Run(); err != nil {
+	logging.FromContext(ctx).Infof("Running %s", cmd)
+	stdout := logging.Writer(ctx, "stdout")
+	stderr := logging.Writer(ctx, "stderr")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
 		return err
 	}
 
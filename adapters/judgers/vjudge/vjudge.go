@@ -6,17 +6,17 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sync"
 
 	"github.com/fedstackjs/azukiiro/common"
 	"github.com/fedstackjs/azukiiro/judge"
+	"github.com/fedstackjs/azukiiro/transport"
 	"github.com/fedstackjs/azukiiro/utils"
 	"github.com/go-resty/resty/v2"
 )
 
 func init() {
-	judge.RegisterAdapter(&VjudgeAdapter{
-		client: resty.New(),
-	})
+	judge.RegisterAdapter(&VjudgeAdapter{})
 }
 
 type VjSolution struct {
@@ -52,7 +52,17 @@ type VjudgeConfig struct {
 }
 
 type VjudgeAdapter struct {
-	client *resty.Client
+	clientOnce sync.Once
+	httpClient *resty.Client
+}
+
+// client returns the HTTP client of the adapter, created on first use since the config is not
+// loaded when adapters are registered
+func (d *VjudgeAdapter) client() *resty.Client {
+	d.clientOnce.Do(func() {
+		d.httpClient = transport.Resty("adapters.vjudge")
+	})
+	return d.httpClient
 }
 
 func (d *VjudgeAdapter) Name() string {
@@ -60,7 +70,7 @@ func (d *VjudgeAdapter) Name() string {
 }
 
 func (d *VjudgeAdapter) getSolution(solutionId string, shareCode string) (result VjSolution, err error) {
-	_, err = d.client.R().
+	_, err = d.client().R().
 		SetHeader("Accept", "*/*").
 		SetHeader("Accept-Language", "zh-CN,zh;q=0.9,en-US;q=0.8,en;q=0.7,ja;q=0.6").
 		SetHeader("Cache-Control", "no-cache").
@@ -78,7 +88,7 @@ func (d *VjudgeAdapter) getSolution(solutionId string, shareCode string) (result
 }
 
 func (d *VjudgeAdapter) getUserId(userName string) (string, error) {
-	resp, err := d.client.R().
+	resp, err := d.client().R().
 		SetPathParam("userName", userName).
 		Get("https://vjudge.net/user/{userName}")
 	if err != nil {
@@ -102,7 +112,7 @@ func (d *VjudgeAdapter) Judge(ctx context.Context, task judge.JudgeTask) error {
 
 	solutionDir, err := utils.UnzipTemp(task.SolutionData(), "solution-*")
 	if err != nil {
-		return err
+		return judge.BadSolutionArchive(err)
 	}
 	defer os.RemoveAll(solutionDir)
 
@@ -0,0 +1,315 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/registry"
+	"github.com/fedstackjs/azukiiro/secret"
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/sirupsen/logrus"
+)
+
+type RoleStatus struct {
+	Role   string              `json:"role"`
+	Paused bool                `json:"paused"`
+	Tasks  []registry.TaskInfo `json:"tasks"`
+}
+
+type StatusResponse struct {
+	Roles []RoleStatus        `json:"roles"`
+	Cache *storage.CacheUsage `json:"cache,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// selectRoles returns the registry named by the role query parameter, or all registries if it is empty
+func selectRoles(r *http.Request) ([]*registry.Registry, bool) {
+	role := r.URL.Query().Get("role")
+	if role == "" {
+		return registry.All(), true
+	}
+	reg, ok := registry.Get(role)
+	if !ok {
+		return nil, false
+	}
+	return []*registry.Registry{reg}, true
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	res := StatusResponse{Roles: []RoleStatus{}}
+	for _, reg := range registry.All() {
+		res.Roles = append(res.Roles, RoleStatus{
+			Role:   reg.Role(),
+			Paused: reg.Paused(),
+			Tasks:  reg.List(),
+		})
+	}
+	if usage, err := storage.GetCacheUsage(); err != nil {
+		logrus.Warnln("Failed to get cache usage:", err)
+	} else {
+		res.Cache = usage
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func handleTasks(w http.ResponseWriter, r *http.Request) {
+	regs, ok := selectRoles(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "role not found")
+		return
+	}
+	tasks := []registry.TaskInfo{}
+	for _, reg := range regs {
+		tasks = append(tasks, reg.List()...)
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// handleCancel cancels a task of the server named by the server query parameter, or of the default
+// server if it is empty
+func handleCancel(w http.ResponseWriter, r *http.Request) {
+	// Names of servers are config keys, which are case insensitive
+	server := strings.ToLower(r.URL.Query().Get("server"))
+	reg, ok := registry.Get(r.PathValue("role"))
+	if !ok || !reg.Cancel(server, r.PathValue("taskId")) {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	if server != "" {
+		logrus.Infof("Task %s/%s of server %s cancelled through admin API", r.PathValue("role"), r.PathValue("taskId"), server)
+	} else {
+		logrus.Infof("Task %s/%s cancelled through admin API", r.PathValue("role"), r.PathValue("taskId"))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		regs, ok := selectRoles(r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "role not found")
+			return
+		}
+		for _, reg := range regs {
+			if paused {
+				reg.Pause()
+				logrus.Infof("%s polling paused through admin API", reg.Role())
+			} else {
+				reg.Resume()
+				logrus.Infof("%s polling resumed through admin API", reg.Role())
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleCache(w http.ResponseWriter, r *http.Request) {
+	usage, err := storage.GetCacheUsage()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// handleRotateKey rotates the key of the client named by the server query parameter, or of the
+// default server if it is empty
+func handleRotateKey(clients []*client.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("server")
+		i := slices.IndexFunc(clients, func(c *client.Client) bool { return strings.EqualFold(c.Name(), name) })
+		if i < 0 {
+			writeError(w, http.StatusNotFound, "server not found")
+			return
+		}
+		c := clients[i]
+		keyName := client.ConfigKey(name, "runnerKey")
+		if err := secret.CheckWritable(keyName); err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		key, err := c.RotateKey(r.Context())
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		if err := secret.Store(keyName, key); err != nil {
+			// The old key no longer works, keep the new one where only the runner user can read it,
+			// it is never sent over the socket
+			c.Logger().Errorln("Failed to store rotated runner key:", err)
+			message := "failed to store the new key: " + err.Error()
+			if path, err := secret.SaveRecovery(storage.GetRootPath(), keyName, key); err != nil {
+				c.Logger().Errorln("Failed to save rotated runner key:", err)
+				message += "; the runner keeps using it until it exits, fix the problem and rotate the key again"
+			} else {
+				c.Logger().Warnln("Rotated runner key saved to", path)
+				message += "; it was saved to " + path + ", move it to where runnerKey is loaded from"
+			}
+			writeError(w, http.StatusInternalServerError, message)
+			return
+		}
+		c.Logger().Infoln("Runner key rotated through admin API")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TokenPath returns the file holding the token required by the admin API on TCP addresses
+func TokenPath() string {
+	return filepath.Join(storage.GetRootPath(), "admin.token")
+}
+
+// newToken writes a new random token to TokenPath
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.MkdirAll(storage.GetRootPath(), 0755); err != nil {
+		return "", err
+	}
+	if err := secret.WriteFile(TokenPath(), token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// guard rejects requests sent by browsers, and requests without the bearer token if token is not empty
+func guard(next http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers send Origin with every cross-site POST, the CLI and curl do not
+		if r.Header.Get("Origin") != "" {
+			writeError(w, http.StatusForbidden, "requests from browsers are not allowed")
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler returns the admin API, clients are the servers the runner serves. If token is not empty,
+// requests must carry it as a bearer token.
+func Handler(clients []*client.Client, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", handleStatus)
+	mux.HandleFunc("GET /tasks", handleTasks)
+	mux.HandleFunc("POST /tasks/{role}/{taskId}/cancel", handleCancel)
+	mux.HandleFunc("POST /pause", handlePause(true))
+	mux.HandleFunc("POST /resume", handlePause(false))
+	mux.HandleFunc("GET /cache", handleCache)
+	mux.HandleFunc("POST /rotate-key", handleRotateKey(clients))
+	return guard(mux, token)
+}
+
+// listen listens on a Unix socket if addr starts with unix:, otherwise on a loopback TCP address
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		if err := checkLoopback(addr); err != nil {
+			return nil, err
+		}
+		return net.Listen("tcp", addr)
+	}
+	// Remove the socket left by a previous run
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return listenUnix(path)
+}
+
+// checkLoopback rejects TCP addresses reachable from other hosts
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("admin API must listen on a loopback address or a unix: socket, not %s", addr)
+	}
+	return nil
+}
+
+// Post sends a POST request to the admin API of a running runner listening on addr
+func Post(ctx context.Context, addr string, path string) (*http.Response, error) {
+	transport := &http.Transport{}
+	host := addr
+	token := ""
+	if socket, ok := strings.CutPrefix(addr, "unix:"); ok {
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		host = "localhost"
+	} else {
+		content, err := os.ReadFile(TokenPath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the admin token: %w", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return (&http.Client{Transport: transport}).Do(req)
+}
+
+// Serve serves the admin API on addr until ctx is done. Other users can connect to TCP addresses,
+// so they require the token written to TokenPath, the unix socket is only accessible by the runner user.
+func Serve(ctx context.Context, addr string, clients []*client.Client) error {
+	token := ""
+	if !strings.HasPrefix(addr, "unix:") {
+		var err error
+		if token, err = newToken(); err != nil {
+			return fmt.Errorf("failed to write the admin token: %w", err)
+		}
+	}
+	listener, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler: Handler(clients, token),
+	}
+	context.AfterFunc(ctx, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	logrus.Infoln("Serving admin API on", addr)
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fedstackjs/azukiiro/aoitest"
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/registry"
+	"github.com/spf13/viper"
+)
+
+func TestListenRejectsRemoteAddresses(t *testing.T) {
+	for _, addr := range []string{":0", "0.0.0.0:0", "[::]:0", "192.0.2.1:0", "example.com:0"} {
+		if listener, err := listen(addr); err == nil {
+			listener.Close()
+			t.Errorf("listen(%q) succeeded", addr)
+		}
+	}
+	for _, addr := range []string{"127.0.0.1:0", "localhost:0"} {
+		listener, err := listen(addr)
+		if err != nil {
+			t.Errorf("listen(%q): %v", addr, err)
+			continue
+		}
+		listener.Close()
+	}
+}
+
+func TestListenSocketPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket permissions are not checked on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	listener, err := listen("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		t.Errorf("socket mode is %04o, want no access for group and others", perm)
+	}
+}
+
+func TestRotateKeyStoreFailure(t *testing.T) {
+	srv := aoitest.NewServer()
+	defer srv.Close()
+	srv.Configure()
+	c, err := client.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagePath := t.TempDir()
+	viper.Set("storagePath", storagePath)
+	// A file: reference to a missing directory passes the writability check but fails to store
+	keyFile := filepath.Join(t.TempDir(), "missing", "key")
+	viper.Set("runnerKey", "file:"+keyFile)
+
+	rec := httptest.NewRecorder()
+	Handler([]*client.Client{c}, "").ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rotate-key", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500: %s", rec.Code, rec.Body)
+	}
+	// Keys handed out by the fake server are numbered, the initial one is runner-key
+	if strings.Contains(rec.Body.String(), "runner-key-") {
+		t.Fatalf("rotated key was sent in the response: %s", rec.Body)
+	}
+	matches, _ := filepath.Glob(filepath.Join(storagePath, "runnerKey-*.recovered"))
+	if len(matches) != 1 {
+		t.Fatalf("found %d recovery files, want 1", len(matches))
+	}
+	content, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := strings.TrimSpace(string(content)); !strings.HasPrefix(key, "runner-key-") {
+		t.Errorf("recovery file has %q, want the rotated key", key)
+	}
+	if !strings.Contains(rec.Body.String(), matches[0]) {
+		t.Errorf("response does not name the recovery file: %s", rec.Body)
+	}
+}
+
+func TestCancelTaskOfServer(t *testing.T) {
+	// Registries live for the process, reuse the one of an earlier run
+	reg, ok := registry.Get("admin-test")
+	if !ok {
+		reg = registry.New("admin-test")
+	}
+	ctx := context.Background()
+	defaultCtx, unregisterDefault := reg.Add(ctx, "", "task", "solution", "")
+	defer unregisterDefault()
+	stagingCtx, unregisterStaging := reg.Add(ctx, "staging", "task", "solution", "")
+	defer unregisterStaging()
+	handler := Handler(nil, "")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks?role=admin-test", nil))
+	tasks := []registry.TaskInfo{}
+	if err := json.NewDecoder(rec.Body).Decode(&tasks); err != nil {
+		t.Fatal(err)
+	}
+	servers := []string{}
+	for _, task := range tasks {
+		servers = append(servers, task.Server)
+	}
+	slices.Sort(servers)
+	if !slices.Equal(servers, []string{"", "staging"}) {
+		t.Fatalf("listed servers %q, want the default server and staging", servers)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/admin-test/task/cancel?server=Staging", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %s", rec.Code, rec.Body)
+	}
+	if !registry.IsCancelled(stagingCtx) {
+		t.Error("task of staging was not cancelled")
+	}
+	if defaultCtx.Err() != nil {
+		t.Error("task of the default server with the same id was cancelled")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/admin-test/task/cancel?server=other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status of a task of another server = %d, want 404", rec.Code)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	viper.Set("storagePath", t.TempDir())
+	token, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(TokenPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm&0077 != 0 {
+			t.Errorf("token file mode is %04o, want no access for group and others", perm)
+		}
+	}
+	handler := Handler(nil, token)
+	for _, tc := range []struct {
+		name    string
+		header  http.Header
+		want    int
+		noToken bool
+	}{
+		{"token", http.Header{"Authorization": {"Bearer " + token}}, http.StatusNoContent, false},
+		{"no token", http.Header{}, http.StatusUnauthorized, false},
+		{"wrong token", http.Header{"Authorization": {"Bearer " + token + "0"}}, http.StatusUnauthorized, false},
+		{"browser", http.Header{"Authorization": {"Bearer " + token}, "Origin": {"http://example.com"}}, http.StatusForbidden, false},
+		{"browser on unix socket", http.Header{"Origin": {"http://example.com"}}, http.StatusForbidden, true},
+	} {
+		h := handler
+		if tc.noToken {
+			h = Handler(nil, "")
+		}
+		req := httptest.NewRequest(http.MethodPost, "/resume", nil)
+		req.Header = tc.header
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
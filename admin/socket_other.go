@@ -0,0 +1,10 @@
+//go:build !unix
+
+package admin
+
+import "net"
+
+// listenUnix creates the socket, its access is controlled by the permissions of its directory
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package admin
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix creates the socket under a umask that leaves it accessible only by the runner user,
+// so it is never reachable by others, not even between creating and restricting it
+func listenUnix(path string) (net.Listener, error) {
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,98 @@
+package aoitest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// queuedLocked wakes held poll requests and event subscribers after a task of kind was queued,
+// s.mu must be held
+func (s *Server) queuedLocked(kind string) {
+	close(s.queued)
+	s.queued = make(chan struct{})
+	for _, sub := range s.subscribers {
+		select {
+		case sub <- kind:
+		default:
+		}
+	}
+}
+
+// pollWait returns how long a poll request may be held, marking the response as a long poll.
+// It is 0 unless LongPoll is set and the runner asked to wait.
+func (s *Server) pollWait(w http.ResponseWriter, r *http.Request) time.Duration {
+	if !s.LongPoll {
+		return 0
+	}
+	seconds, err := strconv.ParseFloat(r.URL.Query().Get("wait"), 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	w.Header().Set("X-AOI-Long-Poll", "1")
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// pollQueue pops a task from q, waiting up to wait for one to be queued
+func pollQueue[T any](s *Server, r *http.Request, q *taskQueue[T], id func(T) string, wait time.Duration) (T, bool) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		t, ok := q.pop(id)
+		queued := s.queued
+		s.mu.Unlock()
+		if ok || wait <= 0 {
+			return t, ok
+		}
+		select {
+		case <-queued:
+		case <-timer.C:
+			return t, false
+		case <-r.Context().Done():
+			return t, false
+		case <-s.closed:
+			return t, false
+		}
+	}
+}
+
+// handleEvents streams an event named after the task kind whenever a task is queued
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request, body []byte) {
+	flusher, ok := w.(http.Flusher)
+	if !s.Events || !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	sub := make(chan string, 16)
+	s.mu.Lock()
+	s.subscribers = append(s.subscribers, sub)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, other := range s.subscribers {
+			if other == sub {
+				s.subscribers = append(s.subscribers[:i], s.subscribers[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		case kind := <-sub:
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", kind)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,314 @@
+// Package aoitest provides an in-process AOI server implementing the runner API, for end-to-end
+// tests of the judge, instancer and ranker roles without network access.
+//
+// A typical test starts a server, points the client at it and queues tasks:
+//
+//	srv := aoitest.NewServer()
+//	defer srv.Close()
+//	srv.Configure()
+//	c, _ := client.Load("")
+//	task := srv.EnqueueSolution(config, problemZip, solutionZip)
+//	judge.Poll(ctx, c)
+//	<-task.Done()
+//	info := task.LastPatch()
+package aoitest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/spf13/viper"
+)
+
+// Request is a request received by the server
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   []byte
+}
+
+type fault struct {
+	pattern string
+	status  int
+	times   int
+}
+
+// Server is a fake AOI server. The zero value is not usable, use NewServer.
+type Server struct {
+	*httptest.Server
+
+	RunnerId string
+	// RunnerKey is replaced when the runner rotates its key
+	RunnerKey         string
+	RegistrationToken string
+	// LongPoll makes poll requests with a wait parameter block until a task is queued
+	LongPoll bool
+	// Events serves task notifications on /api/runner/events
+	Events bool
+	// NoRenew answers renew requests with 404, like servers without task leases
+	NoRenew bool
+
+	// closed is closed by Close to end held poll requests and event streams
+	closed      chan struct{}
+	closeOnce   sync.Once
+	mu          sync.Mutex
+	queued      chan struct{}
+	subscribers []chan string
+	nextId      int
+	requests    []Request
+	faults      []*fault
+	files       map[string][]byte
+	objects     map[string][]byte
+	solutions   taskQueue[*SolutionTask]
+	instances   taskQueue[*InstanceTask]
+	ranklists   taskQueue[*RanklistTask]
+}
+
+// NewServer starts a fake AOI server, it should be closed with Close
+func NewServer() *Server {
+	s := &Server{
+		RunnerId:  "runner",
+		RunnerKey: "runner-key",
+		closed:    make(chan struct{}),
+		queued:    make(chan struct{}),
+		files:     make(map[string][]byte),
+		objects:   make(map[string][]byte),
+		solutions: newTaskQueue[*SolutionTask](),
+		instances: newTaskQueue[*InstanceTask](),
+		ranklists: newTaskQueue[*RanklistTask](),
+	}
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
+
+// Close ends held requests and shuts down the server
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+	s.Server.Close()
+}
+
+// Configure points the config of the default server at the server, load the client with client.Load("") afterwards
+func (s *Server) Configure() {
+	s.ConfigureAs("")
+}
+
+// ConfigureAs points the config of the named server at the server, so that one runner can be tested
+// against several servers
+func (s *Server) ConfigureAs(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	viper.Set(client.ConfigKey(name, "serverAddr"), s.URL)
+	viper.Set(client.ConfigKey(name, "runnerId"), s.RunnerId)
+	viper.Set(client.ConfigKey(name, "runnerKey"), s.RunnerKey)
+}
+
+func (s *Server) newId(prefix string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextId++
+	return fmt.Sprintf("%s-%d", prefix, s.nextId)
+}
+
+// AddFile serves content for download and returns its URL and sha256 hash
+func (s *Server) AddFile(content []byte) (string, string) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	s.mu.Lock()
+	s.files[hash] = content
+	s.mu.Unlock()
+	return s.URL + "/files/" + hash, hash
+}
+
+// Object returns the content uploaded to key, such as solution/<taskId>/details
+func (s *Server) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.objects[key]
+	return content, ok
+}
+
+// objectUrl returns the upload URL of key
+func (s *Server) objectUrl(key string) string {
+	return s.URL + "/objects/" + key
+}
+
+// Requests returns the API requests received so far, downloads and uploads are not included
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Fail makes the next times API requests matching pattern fail with status. The pattern has the form
+// "METHOD /path" and may use path.Match wildcards, such as "POST /api/runner/solution/task/*/*/complete".
+func (s *Server) Fail(pattern string, status int, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault{pattern: pattern, status: status, times: times})
+}
+
+// record stores the request and returns the status of a matching fault, or 0
+func (s *Server) record(r *http.Request, body []byte) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	for _, f := range s.faults {
+		if f.times <= 0 {
+			continue
+		}
+		if ok, _ := path.Match(f.pattern, r.Method+" "+r.URL.Path); ok {
+			f.times--
+			return f.status
+		}
+	}
+	return 0
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// api wraps a runner API handler with request recording, fault injection and authentication
+func (s *Server) api(auth bool, handler func(w http.ResponseWriter, r *http.Request, body []byte)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if status := s.record(r, body); status != 0 {
+			writeError(w, status, "injected failure")
+			return
+		}
+		s.mu.Lock()
+		runnerId, runnerKey := s.RunnerId, s.RunnerKey
+		s.mu.Unlock()
+		if auth && (r.Header.Get("X-AOI-Runner-Id") != runnerId || r.Header.Get("X-AOI-Runner-Key") != runnerKey) {
+			writeError(w, http.StatusUnauthorized, "invalid runner credentials")
+			return
+		}
+		handler(w, r, body)
+	}
+}
+
+func decode(w http.ResponseWriter, body []byte, v any) bool {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return true
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/runner/register", s.api(false, s.handleRegister))
+	mux.HandleFunc("POST /api/runner/rotateKey", s.api(true, s.handleRotateKey))
+	mux.HandleFunc("GET /api/runner/events", s.api(true, s.handleEvents))
+
+	mux.HandleFunc("POST /api/runner/solution/poll", s.api(true, s.handleSolutionPoll))
+	mux.HandleFunc("PATCH /api/runner/solution/task/{solutionId}/{taskId}", s.api(true, s.handleSolutionPatch))
+	mux.HandleFunc("POST /api/runner/solution/task/{solutionId}/{taskId}/complete", s.api(true, s.handleSolutionComplete))
+	mux.HandleFunc("POST /api/runner/solution/task/{solutionId}/{taskId}/renew", s.api(true, s.handleSolutionRenew))
+	mux.HandleFunc("POST /api/runner/solution/task/{solutionId}/{taskId}/release", s.api(true, s.handleSolutionRelease))
+	mux.HandleFunc("GET /api/runner/solution/task/{solutionId}/{taskId}/details/{urlType}", s.api(true, s.handleSolutionDetailsUrl))
+	mux.HandleFunc("GET /api/runner/solution/task/{solutionId}/{taskId}/artifact/{name}/upload", s.api(true, s.handleSolutionArtifactUrl))
+
+	mux.HandleFunc("POST /api/runner/instance/poll", s.api(true, s.handleInstancePoll))
+	mux.HandleFunc("PATCH /api/runner/instance/task/{instanceId}/{taskId}", s.api(true, s.handleInstancePatch))
+	mux.HandleFunc("POST /api/runner/instance/task/{instanceId}/{taskId}/complete", s.api(true, s.handleInstanceComplete))
+
+	mux.HandleFunc("POST /api/runner/ranklist/poll", s.api(true, s.handleRanklistPoll))
+	mux.HandleFunc("GET /api/runner/ranklist/task/{contestId}/{taskId}/uploadUrls", s.api(true, s.handleRanklistUploadUrls))
+	mux.HandleFunc("POST /api/runner/ranklist/task/{contestId}/{taskId}/complete", s.api(true, s.handleRanklistComplete))
+	mux.HandleFunc("GET /api/runner/ranklist/task/{contestId}/{taskId}/solutions", s.api(true, s.handleRanklistSolutions))
+	mux.HandleFunc("GET /api/runner/ranklist/task/{contestId}/{taskId}/participants", s.api(true, s.handleRanklistParticipants))
+	mux.HandleFunc("GET /api/runner/ranklist/task/{contestId}/{taskId}/problems", s.api(true, s.handleRanklistProblems))
+
+	mux.HandleFunc("GET /files/{hash}", s.handleFile)
+	mux.HandleFunc("PUT /objects/{key...}", s.handleObject)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not found")
+	})
+	return mux
+}
+
+type registerRequest struct {
+	Name              string `json:"name"`
+	RegistrationToken string `json:"registrationToken"`
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request, body []byte) {
+	req := &registerRequest{}
+	if !decode(w, body, req) {
+		return
+	}
+	if s.RegistrationToken != "" && req.RegistrationToken != s.RegistrationToken {
+		writeError(w, http.StatusForbidden, "invalid registration token")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{"runnerId": s.RunnerId, "runnerKey": s.RunnerKey})
+}
+
+// handleRotateKey replaces RunnerKey, requests with the old key are rejected afterwards
+func (s *Server) handleRotateKey(w http.ResponseWriter, r *http.Request, body []byte) {
+	key := s.newId("runner-key")
+	s.mu.Lock()
+	s.RunnerKey = key
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{"runnerKey": key})
+}
+
+// handleFile serves files added with AddFile, with range support for resumed downloads
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	content, ok := s.files[r.PathValue("hash")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+func (s *Server) handleObject(w http.ResponseWriter, r *http.Request) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := strings.TrimPrefix(r.PathValue("key"), "/")
+	s.mu.Lock()
+	s.objects[key] = content
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,500 @@
+package aoitest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/common"
+)
+
+// taskQueue holds the tasks waiting to be polled and the tasks held by runners, guarded by Server.mu
+type taskQueue[T any] struct {
+	queued []T
+	active map[string]T
+}
+
+func newTaskQueue[T any]() taskQueue[T] {
+	return taskQueue[T]{active: make(map[string]T)}
+}
+
+// pop moves the first queued task to the active tasks
+func (q *taskQueue[T]) pop(id func(T) string) (T, bool) {
+	var zero T
+	if len(q.queued) == 0 {
+		return zero, false
+	}
+	t := q.queued[0]
+	q.queued = q.queued[1:]
+	q.active[id(t)] = t
+	return t, true
+}
+
+// taskState is shared by all task kinds
+type taskState struct {
+	mu       sync.Mutex
+	done     chan struct{}
+	doneOnce sync.Once
+	revoked  bool
+}
+
+func newTaskState() *taskState {
+	return &taskState{done: make(chan struct{})}
+}
+
+// Done is closed when the runner completes or releases the task
+func (t *taskState) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *taskState) finish() {
+	t.doneOnce.Do(func() { close(t.done) })
+}
+
+// SolutionTask is a judge task, the embedded response is returned to the runner that polls it
+type SolutionTask struct {
+	client.PollSolutionResponse
+	*taskState
+	patches   []common.SolutionInfo
+	renewals  int
+	completed bool
+	released  *client.ReleaseSolutionTaskRequest
+}
+
+// Patches returns the solution info reported by the runner so far
+func (t *SolutionTask) Patches() []common.SolutionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]common.SolutionInfo(nil), t.patches...)
+}
+
+// LastPatch returns the last solution info reported by the runner, or nil
+func (t *SolutionTask) LastPatch() *common.SolutionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.patches) == 0 {
+		return nil
+	}
+	info := t.patches[len(t.patches)-1]
+	return &info
+}
+
+// Renewals returns the number of lease renewals
+func (t *SolutionTask) Renewals() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.renewals
+}
+
+func (t *SolutionTask) Completed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.completed
+}
+
+// Released returns the release request if the runner handed the task back, or nil
+func (t *SolutionTask) Released() *client.ReleaseSolutionTaskRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.released
+}
+
+// EnqueueSolution queues a judge task for the given problem and solution archives
+func (s *Server) EnqueueSolution(config common.ProblemConfig, problemData []byte, solutionData []byte) *SolutionTask {
+	res := client.PollSolutionResponse{
+		TaskId:        s.newId("task"),
+		SolutionId:    s.newId("solution"),
+		UserId:        "user",
+		ProblemConfig: config,
+	}
+	res.ProblemDataUrl, res.ProblemDataHash = s.AddFile(problemData)
+	res.SolutionDataUrl, res.SolutionDataHash = s.AddFile(solutionData)
+	return s.EnqueueSolutionResponse(res)
+}
+
+// EnqueueSolutionResponse queues a judge task returning res as is, for scripting unusual responses
+func (s *Server) EnqueueSolutionResponse(res client.PollSolutionResponse) *SolutionTask {
+	t := &SolutionTask{PollSolutionResponse: res, taskState: newTaskState()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.solutions.queued = append(s.solutions.queued, t)
+	s.queuedLocked(client.KindSolution)
+	return t
+}
+
+// Revoke makes later requests for the task fail as if its lease was taken over by another runner
+func (s *Server) Revoke(taskId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.solutions.active[taskId]; ok {
+		t.mu.Lock()
+		t.revoked = true
+		t.mu.Unlock()
+	}
+}
+
+// Details returns the solution details uploaded for a judge task
+func (s *Server) Details(taskId string) (*common.SolutionDetails, bool) {
+	content, ok := s.Object("solution/" + taskId + "/details")
+	if !ok {
+		return nil, false
+	}
+	details := &common.SolutionDetails{}
+	if err := json.Unmarshal(content, details); err != nil {
+		return nil, false
+	}
+	return details, true
+}
+
+// Artifact returns the content of an artifact uploaded for a judge task
+func (s *Server) Artifact(taskId string, name string) ([]byte, bool) {
+	return s.Object("solution/" + taskId + "/artifacts/" + name)
+}
+
+// solutionTask returns the active task of the request, writing an error if it is unknown or revoked
+func (s *Server) solutionTask(w http.ResponseWriter, r *http.Request) (*SolutionTask, bool) {
+	s.mu.Lock()
+	t, ok := s.solutions.active[r.PathValue("taskId")]
+	s.mu.Unlock()
+	if !ok || t.SolutionId != r.PathValue("solutionId") {
+		writeError(w, http.StatusNotFound, "task not found")
+		return nil, false
+	}
+	t.mu.Lock()
+	revoked := t.revoked
+	t.mu.Unlock()
+	if revoked {
+		writeError(w, http.StatusConflict, "task revoked")
+		return nil, false
+	}
+	return t, true
+}
+
+func (s *Server) handleSolutionPoll(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := pollQueue(s, r, &s.solutions, func(t *SolutionTask) string { return t.TaskId }, s.pollWait(w, r))
+	if !ok {
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+	writeJSON(w, http.StatusOK, t.PollSolutionResponse)
+}
+
+func (s *Server) handleSolutionPatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.solutionTask(w, r)
+	if !ok {
+		return
+	}
+	info := common.SolutionInfo{}
+	if !decode(w, body, &info) {
+		return
+	}
+	t.mu.Lock()
+	t.patches = append(t.patches, info)
+	t.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleSolutionComplete(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.solutionTask(w, r)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.completed = true
+	t.mu.Unlock()
+	t.finish()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleSolutionRenew(w http.ResponseWriter, r *http.Request, body []byte) {
+	if s.NoRenew {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	t, ok := s.solutionTask(w, r)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.renewals++
+	t.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleSolutionRelease(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.solutionTask(w, r)
+	if !ok {
+		return
+	}
+	req := &client.ReleaseSolutionTaskRequest{}
+	if !decode(w, body, req) {
+		return
+	}
+	t.mu.Lock()
+	t.released = req
+	t.mu.Unlock()
+	t.finish()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleSolutionDetailsUrl(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.solutionTask(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, client.UrlResponse{Url: s.objectUrl("solution/" + t.TaskId + "/details")})
+}
+
+func (s *Server) handleSolutionArtifactUrl(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.solutionTask(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, client.UrlResponse{Url: s.objectUrl("solution/" + t.TaskId + "/artifacts/" + r.PathValue("name"))})
+}
+
+// InstanceTask is an instance task, the embedded response is returned to the runner that polls it
+type InstanceTask struct {
+	client.PollInstanceResponse
+	*taskState
+	messages []string
+	complete *client.CompleteTaskRequest
+}
+
+// Messages returns the messages patched by the runner so far
+func (t *InstanceTask) Messages() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.messages...)
+}
+
+// Result returns the completion request of the runner, or nil
+func (t *InstanceTask) Result() *client.CompleteTaskRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.complete
+}
+
+// EnqueueInstance queues an instance task, state is one of the client.InstanceState constants
+func (s *Server) EnqueueInstance(config common.ProblemConfig, problemData []byte, state int) *InstanceTask {
+	res := client.PollInstanceResponse{
+		TaskId:        s.newId("task"),
+		InstanceId:    s.newId("instance"),
+		UserId:        "user",
+		State:         state,
+		ProblemConfig: config,
+	}
+	res.ProblemDataUrl, res.ProblemDataHash = s.AddFile(problemData)
+	return s.EnqueueInstanceResponse(res)
+}
+
+// EnqueueInstanceResponse queues an instance task returning res as is
+func (s *Server) EnqueueInstanceResponse(res client.PollInstanceResponse) *InstanceTask {
+	t := &InstanceTask{PollInstanceResponse: res, taskState: newTaskState()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances.queued = append(s.instances.queued, t)
+	s.queuedLocked(client.KindInstance)
+	return t
+}
+
+func (s *Server) instanceTask(w http.ResponseWriter, r *http.Request) (*InstanceTask, bool) {
+	s.mu.Lock()
+	t, ok := s.instances.active[r.PathValue("taskId")]
+	s.mu.Unlock()
+	if !ok || t.InstanceId != r.PathValue("instanceId") {
+		writeError(w, http.StatusNotFound, "task not found")
+		return nil, false
+	}
+	return t, true
+}
+
+func (s *Server) handleInstancePoll(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := pollQueue(s, r, &s.instances, func(t *InstanceTask) string { return t.TaskId }, s.pollWait(w, r))
+	if !ok {
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+	writeJSON(w, http.StatusOK, t.PollInstanceResponse)
+}
+
+func (s *Server) handleInstancePatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.instanceTask(w, r)
+	if !ok {
+		return
+	}
+	req := &client.PatchInstanceTaskRequest{}
+	if !decode(w, body, req) {
+		return
+	}
+	if req.Message != nil {
+		t.mu.Lock()
+		t.messages = append(t.messages, *req.Message)
+		t.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleInstanceComplete(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.instanceTask(w, r)
+	if !ok {
+		return
+	}
+	req := &client.CompleteTaskRequest{}
+	if !decode(w, body, req) {
+		return
+	}
+	t.mu.Lock()
+	t.complete = req
+	t.mu.Unlock()
+	t.finish()
+	w.WriteHeader(http.StatusOK)
+}
+
+// RanklistTask is a ranklist task, the embedded response is returned to the runner that polls it.
+// Solutions, Participants and Problems are served to the runner, paged by their update time and id.
+type RanklistTask struct {
+	client.PollRanklistResponse
+	Solutions    client.GetRanklistSolutionsResponse
+	Participants client.GetRanklistParticipantsResponse
+	Problems     client.GetRanklistProblemsResponse
+	*taskState
+	complete *client.CompleteRanklistTaskRequest
+}
+
+// Result returns the completion request of the runner, or nil
+func (t *RanklistTask) Result() *client.CompleteRanklistTaskRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.complete
+}
+
+// EnqueueRanklist queues a ranklist task, TaskId and ContestId are generated if empty
+func (s *Server) EnqueueRanklist(t *RanklistTask) *RanklistTask {
+	if t.TaskId == "" {
+		t.TaskId = s.newId("task")
+	}
+	if t.ContestId == "" {
+		t.ContestId = s.newId("contest")
+	}
+	t.taskState = newTaskState()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ranklists.queued = append(s.ranklists.queued, t)
+	s.queuedLocked(client.KindRanklist)
+	return t
+}
+
+// Ranklist returns the ranklist uploaded for key by a ranklist task
+func (s *Server) Ranklist(taskId string, key string) (*client.Ranklist, bool) {
+	content, ok := s.Object("ranklist/" + taskId + "/" + key)
+	if !ok {
+		return nil, false
+	}
+	ranklist := &client.Ranklist{}
+	if err := json.Unmarshal(content, ranklist); err != nil {
+		return nil, false
+	}
+	return ranklist, true
+}
+
+func (s *Server) ranklistTask(w http.ResponseWriter, r *http.Request) (*RanklistTask, bool) {
+	s.mu.Lock()
+	t, ok := s.ranklists.active[r.PathValue("taskId")]
+	s.mu.Unlock()
+	if !ok || t.ContestId != r.PathValue("contestId") {
+		writeError(w, http.StatusNotFound, "task not found")
+		return nil, false
+	}
+	return t, true
+}
+
+func (s *Server) handleRanklistPoll(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := pollQueue(s, r, &s.ranklists, func(t *RanklistTask) string { return t.TaskId }, s.pollWait(w, r))
+	if !ok {
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+	writeJSON(w, http.StatusOK, t.PollRanklistResponse)
+}
+
+func (s *Server) handleRanklistUploadUrls(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.ranklistTask(w, r)
+	if !ok {
+		return
+	}
+	type uploadUrl struct {
+		Key string `json:"key"`
+		Url string `json:"url"`
+	}
+	urls := []uploadUrl{}
+	for _, ranklist := range t.Ranklists {
+		urls = append(urls, uploadUrl{Key: ranklist.Key, Url: s.objectUrl("ranklist/" + t.TaskId + "/" + ranklist.Key)})
+	}
+	writeJSON(w, http.StatusOK, urls)
+}
+
+func (s *Server) handleRanklistComplete(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.ranklistTask(w, r)
+	if !ok {
+		return
+	}
+	req := &client.CompleteRanklistTaskRequest{}
+	if !decode(w, body, req) {
+		return
+	}
+	t.mu.Lock()
+	t.complete = req
+	t.mu.Unlock()
+	t.finish()
+	w.WriteHeader(http.StatusOK)
+}
+
+// page reports whether an item with the given update time and id comes after the since and lastId query
+func page(r *http.Request, updatedAt int, id string) bool {
+	since := 0
+	json.Unmarshal([]byte(r.URL.Query().Get("since")), &since)
+	lastId := r.URL.Query().Get("lastId")
+	return updatedAt > since || (updatedAt == since && id > lastId)
+}
+
+func (s *Server) handleRanklistSolutions(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.ranklistTask(w, r)
+	if !ok {
+		return
+	}
+	res := client.GetRanklistSolutionsResponse{}
+	for _, solution := range t.Solutions {
+		if page(r, solution.CompletedAt, solution.Id) {
+			res = append(res, solution)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleRanklistParticipants(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.ranklistTask(w, r)
+	if !ok {
+		return
+	}
+	res := client.GetRanklistParticipantsResponse{}
+	for _, participant := range t.Participants {
+		if page(r, participant.UpdatedAt, participant.Id) {
+			res = append(res, participant)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleRanklistProblems(w http.ResponseWriter, r *http.Request, body []byte) {
+	t, ok := s.ranklistTask(w, r)
+	if !ok {
+		return
+	}
+	res := t.Problems
+	if res == nil {
+		res = client.GetRanklistProblemsResponse{}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
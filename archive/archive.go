@@ -0,0 +1,211 @@
+package archive
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrUnsafePath     = errors.New("unsafe path")
+	ErrUnsupported    = errors.New("unsupported entry type")
+	ErrTooLarge       = errors.New("extracted size exceeds limit")
+	ErrTooManyEntries = errors.New("too many entries")
+	ErrRatio          = errors.New("compression ratio exceeds limit")
+	ErrInvalid        = errors.New("invalid archive")
+	ErrConflict       = errors.New("conflicts with another entry")
+)
+
+// Error reports a problem with the content of an archive, as opposed to failures of the host
+type Error struct {
+	Name string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Name == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsBadArchive reports whether err was caused by the content of the archive
+func IsBadArchive(err error) bool {
+	var archiveErr *Error
+	return errors.As(err, &archiveErr)
+}
+
+type Limits struct {
+	// Total size of extracted files in bytes, 0 for unlimited
+	MaxBytes int64
+	// Number of entries, 0 for unlimited
+	MaxEntries int
+	// Ratio of the extracted size to the archive size, 0 for unlimited
+	MaxRatio float64
+}
+
+func init() {
+	viper.SetDefault("archive.maxBytes", 1<<30)
+	viper.SetDefault("archive.maxEntries", 10000)
+	viper.SetDefault("archive.maxRatio", 200)
+	// Problem data comes from the server and often compresses far better than solutions
+	viper.SetDefault("archive.problem.maxBytes", 1<<34)
+	viper.SetDefault("archive.problem.maxEntries", 0)
+	viper.SetDefault("archive.problem.maxRatio", 0)
+}
+
+func readLimits(section string) Limits {
+	return Limits{
+		MaxBytes:   viper.GetInt64(section + ".maxBytes"),
+		MaxEntries: viper.GetInt(section + ".maxEntries"),
+		MaxRatio:   viper.GetFloat64(section + ".maxRatio"),
+	}
+}
+
+// DefaultLimits reads the archive config section, which applies to submitted solutions
+func DefaultLimits() Limits {
+	return readLimits("archive")
+}
+
+// ProblemLimits reads the archive.problem config section, which applies to problem data from the server
+func ProblemLimits() Limits {
+	return readLimits("archive.problem")
+}
+
+type extractor struct {
+	limits  Limits
+	dest    string
+	maxSize int64
+	written int64
+	// Whether each extracted path is a directory
+	entries map[string]bool
+}
+
+// checkName validates an entry name and returns its path under dest
+func (x *extractor) checkName(name string) (string, error) {
+	name = strings.TrimSuffix(name, "/")
+	if strings.Contains(name, "\\") || strings.ContainsRune(name, 0) || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", &Error{Name: name, Err: ErrUnsafePath}
+	}
+	return filepath.Join(x.dest, filepath.FromSlash(name)), nil
+}
+
+// claim records the entry at path, rejecting it if an earlier entry of another type is in its way
+func (x *extractor) claim(name string, path string, dir bool) error {
+	for parent := filepath.Dir(path); parent != x.dest && parent != filepath.Dir(parent); parent = filepath.Dir(parent) {
+		if isDir, ok := x.entries[parent]; ok && !isDir {
+			return &Error{Name: name, Err: ErrConflict}
+		}
+		x.entries[parent] = true
+	}
+	if isDir, ok := x.entries[path]; ok && isDir != dir {
+		return &Error{Name: name, Err: ErrConflict}
+	}
+	x.entries[path] = dir
+	return nil
+}
+
+// safeMode keeps only the owner executable bit of the entry
+func safeMode(mode os.FileMode) os.FileMode {
+	if mode&0100 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+func (x *extractor) extractFile(f *zip.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	reader, err := f.Open()
+	if err != nil {
+		return &Error{Name: f.Name, Err: fmt.Errorf("%w: %v", ErrInvalid, err)}
+	}
+	defer reader.Close()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, safeMode(f.Mode()))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// Never trust the sizes in the headers, count what is actually written
+	remaining := x.maxSize - x.written
+	limit := remaining
+	if limit < math.MaxInt64 {
+		// One more byte tells an entry at the limit from one over it
+		limit++
+	}
+	n, err := io.Copy(file, io.LimitReader(reader, limit))
+	x.written += n
+	if err != nil {
+		if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return &Error{Name: f.Name, Err: fmt.Errorf("%w: %v", ErrInvalid, err)}
+		}
+		return err
+	}
+	if n > remaining {
+		if x.limits.MaxBytes > 0 && x.written > x.limits.MaxBytes {
+			return &Error{Name: f.Name, Err: ErrTooLarge}
+		}
+		return &Error{Name: f.Name, Err: ErrRatio}
+	}
+	return file.Close()
+}
+
+// ExtractZip extracts the zip archive at source into the existing directory dest.
+// Entries with unsafe paths, symlinks and other special files are rejected.
+func ExtractZip(source string, dest string, limits Limits) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	reader, err := zip.OpenReader(source)
+	if err != nil {
+		return &Error{Err: fmt.Errorf("%w: %v", ErrInvalid, err)}
+	}
+	defer reader.Close()
+	if limits.MaxEntries > 0 && len(reader.File) > limits.MaxEntries {
+		return &Error{Err: fmt.Errorf("%w: %d > %d", ErrTooManyEntries, len(reader.File), limits.MaxEntries)}
+	}
+
+	x := &extractor{limits: limits, dest: filepath.Clean(dest), maxSize: math.MaxInt64, entries: make(map[string]bool)}
+	if limits.MaxBytes > 0 {
+		x.maxSize = limits.MaxBytes
+	}
+	if limits.MaxRatio > 0 {
+		x.maxSize = min(x.maxSize, int64(limits.MaxRatio*float64(max(info.Size(), 1))))
+	}
+	for _, f := range reader.File {
+		path, err := x.checkName(f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		if err := x.claim(f.Name, path, mode.IsDir()); err != nil {
+			return err
+		}
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err := x.extractFile(f, path); err != nil {
+				return err
+			}
+		default:
+			return &Error{Name: f.Name, Err: fmt.Errorf("%w: %v", ErrUnsupported, mode.Type())}
+		}
+	}
+	return nil
+}
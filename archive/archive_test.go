@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+type entry struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+// writeZip writes the entries into a zip archive and returns its path
+func writeZip(t *testing.T, entries ...entry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "archive.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := zip.NewWriter(file)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.mode != 0 {
+			header.SetMode(e.mode)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractZip(t *testing.T) {
+	path := writeZip(t,
+		entry{name: "dir/", mode: os.ModeDir | 0755},
+		entry{name: "dir/data.txt", content: "data", mode: 0666},
+		entry{name: "run.sh", content: "#!/bin/sh", mode: 0777},
+	)
+	dest := t.TempDir()
+	if err := ExtractZip(path, dest, Limits{MaxBytes: 1 << 20, MaxEntries: 10, MaxRatio: 100}); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dest, "dir", "data.txt"))
+	if err != nil || string(content) != "data" {
+		t.Fatalf("data.txt = %q, %v", content, err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	for name, want := range map[string]os.FileMode{"dir/data.txt": 0644, "run.sh": 0755} {
+		info, err := os.Stat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The umask may clear more bits
+		if perm := info.Mode().Perm(); perm&^want != 0 {
+			t.Errorf("%s has mode %04o, want at most %04o", name, perm, want)
+		}
+	}
+}
+
+func TestExtractZipWithinLimits(t *testing.T) {
+	path := writeZip(t, entry{name: "x.txt", content: "hello"})
+	for _, limits := range []Limits{
+		{},
+		{MaxBytes: 1 << 20},
+		{MaxRatio: 100},
+		{MaxEntries: 1},
+	} {
+		dest := t.TempDir()
+		if err := ExtractZip(path, dest, limits); err != nil {
+			t.Errorf("%+v: %v", limits, err)
+			continue
+		}
+		if content, err := os.ReadFile(filepath.Join(dest, "x.txt")); err != nil || string(content) != "hello" {
+			t.Errorf("%+v: x.txt = %q, %v", limits, content, err)
+		}
+	}
+}
+
+func TestExtractZipRejectsConflictingEntries(t *testing.T) {
+	for _, entries := range [][]entry{
+		{{name: "a", content: "x"}, {name: "a/", mode: os.ModeDir | 0755}},
+		{{name: "a", content: "x"}, {name: "a/b", content: "x"}},
+		{{name: "a/", mode: os.ModeDir | 0755}, {name: "a", content: "x"}},
+		{{name: "a/b", content: "x"}, {name: "a", content: "x"}},
+	} {
+		err := ExtractZip(writeZip(t, entries...), t.TempDir(), Limits{})
+		if !errors.Is(err, ErrConflict) || !IsBadArchive(err) {
+			t.Errorf("%s then %s: err = %v, want a conflict", entries[0].name, entries[1].name, err)
+		}
+	}
+}
+
+func TestExtractZipRejectsUnsafeEntries(t *testing.T) {
+	for _, e := range []entry{
+		{name: "../escape", content: "x"},
+		{name: "dir/../../escape", content: "x"},
+		{name: "/absolute", content: "x"},
+		{name: `dir\..\..\escape`, content: "x"},
+	} {
+		err := ExtractZip(writeZip(t, e), t.TempDir(), Limits{})
+		if !errors.Is(err, ErrUnsafePath) || !IsBadArchive(err) {
+			t.Errorf("%q: err = %v, want unsafe path", e.name, err)
+		}
+	}
+	err := ExtractZip(writeZip(t, entry{name: "link", content: "/etc/passwd", mode: os.ModeSymlink | 0777}), t.TempDir(), Limits{})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("symlink: err = %v, want unsupported", err)
+	}
+}
+
+func TestExtractZipLimits(t *testing.T) {
+	zeros := string(make([]byte, 1<<20))
+	for _, tc := range []struct {
+		name    string
+		entries []entry
+		limits  Limits
+		want    error
+	}{
+		{"entries", []entry{{name: "a"}, {name: "b"}, {name: "c"}}, Limits{MaxEntries: 2}, ErrTooManyEntries},
+		{"bytes", []entry{{name: "a", content: "0123456789"}, {name: "b", content: "0123456789"}}, Limits{MaxBytes: 15}, ErrTooLarge},
+		{"ratio", []entry{{name: "zeros", content: zeros}}, Limits{MaxRatio: 10}, ErrRatio},
+	} {
+		err := ExtractZip(writeZip(t, tc.entries...), t.TempDir(), tc.limits)
+		if !errors.Is(err, tc.want) || !IsBadArchive(err) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	// Limits are checked against the extracted data, within them the archive is fine
+	if err := ExtractZip(writeZip(t, entry{name: "zeros", content: zeros}), t.TempDir(), Limits{MaxBytes: 2 << 20, MaxRatio: 0}); err != nil {
+		t.Errorf("archive within limits: %v", err)
+	}
+}
+
+func TestExtractZipDeclaredSizeMismatch(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 1<<16)
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(content)
+	fw.Close()
+
+	path := filepath.Join(t.TempDir(), "archive.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(file)
+	// The header claims a tiny file
+	f, err := w.CreateRaw(&zip.FileHeader{
+		Name:               "bomb",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(content),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(compressed.Bytes())
+	w.Close()
+	file.Close()
+
+	dest := t.TempDir()
+	if err := ExtractZip(path, dest, Limits{MaxBytes: 1 << 10}); !IsBadArchive(err) {
+		t.Errorf("err = %v, want a bad archive", err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "bomb")); err == nil && info.Size() > 1<<10 {
+		t.Errorf("extracted %d bytes, over the limit", info.Size())
+	}
+}
+
+func TestExtractZipInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.zip")
+	if err := os.WriteFile(path, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExtractZip(path, t.TempDir(), Limits{}); !errors.Is(err, ErrInvalid) || !IsBadArchive(err) {
+		t.Errorf("err = %v, want invalid archive", err)
+	}
+	if err := ExtractZip(filepath.Join(t.TempDir(), "missing.zip"), t.TempDir(), Limits{}); err == nil || IsBadArchive(err) {
+		t.Errorf("missing archive: err = %v, want a host error", err)
+	}
+}
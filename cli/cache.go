@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	commands = append(commands, &cacheCmd{})
+}
+
+type cacheCmd struct{}
+
+func (c *cacheCmd) Mount(ctx context.Context, root *cobra.Command) {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the download cache",
+	}
+	cacheCmd.AddCommand(cacheFetchCmd(ctx))
+	root.AddCommand(cacheCmd)
+}
+
+func cacheFetchCmd(ctx context.Context) *cobra.Command {
+	var listFile string
+	var concurrency int
+	cmd := &cobra.Command{
+		Use:   "fetch [<sha256> <url>]...",
+		Short: "Download files into the cache ahead of time",
+		Long: "Download files into the cache ahead of time, such as problem data before a contest.\n" +
+			"Files are given as pairs of arguments, or with --file, one \"<sha256> <url>\" pair per line.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args)%2 != 0 {
+				return fmt.Errorf("expected pairs of <sha256> <url>")
+			}
+			items := []storage.WarmItem{}
+			if listFile != "" {
+				listed, err := storage.ReadWarmList(listFile)
+				if err != nil {
+					return err
+				}
+				items = append(items, listed...)
+			}
+			for i := 0; i < len(args); i += 2 {
+				item, err := storage.ParseWarmItem(args[i], args[i+1])
+				if err != nil {
+					return err
+				}
+				items = append(items, item)
+			}
+			if len(items) == 0 {
+				return fmt.Errorf("no files given")
+			}
+			if err := storage.Hold(ctx); err != nil {
+				return err
+			}
+			if failed := storage.Warm(ctx, items, concurrency, logWarmProgress); failed > 0 {
+				return fmt.Errorf("%d of %d files could not be fetched", failed, len(items))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&listFile, "file", "f", "", "File listing \"<sha256> <url>\" pairs")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 4, "Number of files downloaded at the same time")
+	return cmd
+}
+
+func logWarmProgress(p *storage.WarmProgress) {
+	if p.Err != nil {
+		logrus.Warnf("[%d/%d] Failed to fetch %s: %v", p.Done, p.Total, p.Item.Hash, p.Err)
+	} else {
+		logrus.Infof("[%d/%d] Fetched %s in %v", p.Done, p.Total, p.Item.Hash, p.Elapsed.Round(time.Millisecond))
+	}
+}
+
+// startCacheWarm downloads the files listed by cache.warm.file and cache.warm.items in the background,
+// file overrides cache.warm.file if it is not empty
+func startCacheWarm(ctx context.Context, file string) {
+	items := []storage.WarmItem{}
+	if file == "" {
+		file = viper.GetString("cache.warm.file")
+	}
+	if file != "" {
+		listed, err := storage.ReadWarmList(file)
+		if err != nil {
+			logrus.Errorln("Cache warm-up disabled:", err)
+			return
+		}
+		items = append(items, listed...)
+	}
+	configured := []storage.WarmItem{}
+	if err := viper.UnmarshalKey("cache.warm.items", &configured); err != nil {
+		logrus.Errorln("Cache warm-up disabled:", err)
+		return
+	}
+	for _, item := range configured {
+		item, err := storage.ParseWarmItem(item.Hash, item.Url)
+		if err != nil {
+			logrus.Errorln("Cache warm-up disabled:", err)
+			return
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return
+	}
+	concurrency := viper.GetInt("cache.warm.concurrency")
+	if concurrency <= 0 {
+		concurrency = 2
+	}
+	go func() {
+		logrus.Infof("Warming cache with %d files", len(items))
+		failed := storage.Warm(ctx, items, concurrency, logWarmProgress)
+		logrus.Infof("Cache warm-up finished, %d of %d files failed", failed, len(items))
+	}()
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/fedstackjs/azukiiro/logging"
 	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/fedstackjs/azukiiro/transport"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -31,12 +33,16 @@ func Execute(ctx context.Context) {
 		}
 
 		viper.SetDefault("storagePath", "/var/lib/azukiiro")
+		viper.SetDefault("heartbeatInterval", 30)
+		viper.SetDefault("log.capture.lines", 50)
 
 		if err := viper.ReadInConfig(); err != nil {
 			fmt.Println("Can't read config:", err)
 			os.Exit(1)
 		}
 
+		logging.Setup()
+		transport.Init()
 		storage.Initialize()
 	})
 
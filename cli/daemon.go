@@ -2,11 +2,9 @@ package cli
 
 import (
 	"context"
-	"sync"
 	"time"
 
-	"github.com/fedstackjs/azukiiro/client"
-	"github.com/fedstackjs/azukiiro/judge"
+	"github.com/fedstackjs/azukiiro/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -27,57 +25,40 @@ func (c *daemonCommand) Mount(ctx context.Context, root *cobra.Command) {
 	}
 	daemonCmd.Flags().IntVar(&daemonArgs.concurrency, "concurrency", 1, "Concurrency")
 	daemonCmd.Flags().Float32Var(&daemonArgs.pollInterval, "poll-interval", 1, "Poll interval in seconds")
+	daemonCmd.Flags().IntVar(&daemonArgs.prefetchConcurrency, "prefetch-concurrency", 2, "Number of tasks downloading data at the same time")
+	daemonCmd.Flags().IntVar(&daemonArgs.queueDepth, "queue-depth", 1, "Number of tasks accepted ahead of free judgers")
+	daemonCmd.Flags().Float32Var(&daemonArgs.drainTimeout, "drain-timeout", 60, "Time in seconds to wait for running tasks on shutdown")
+	daemonCmd.Flags().StringVar(&daemonArgs.warmCache, "warm-cache", "", "File listing \"<sha256> <url>\" pairs to download into the cache on start")
 	root.AddCommand(daemonCmd)
 }
 
 type daemonArgs struct {
-	concurrency  int
-	pollInterval float32
+	judgeRoleArgs
+	drainTimeout float32
+	warmCache    string
+}
+
+// withDrain returns the context for running tasks, which may finish within drainTimeout after ctx is done
+func withDrain(ctx context.Context, drainTimeout float32) (context.Context, context.CancelFunc) {
+	context.AfterFunc(ctx, func() {
+		logrus.Infof("Draining, waiting up to %vs for running tasks", drainTimeout)
+	})
+	return utils.WithGracePeriod(ctx, time.Duration(drainTimeout*float32(time.Second)))
 }
 
 func runDaemon(ctx context.Context, daemonArgs *daemonArgs) func(*cobra.Command, []string) error {
 	return func(cmd *cobra.Command, args []string) error {
 		logrus.Println("Starting daemon")
-		client.InitFromConfig()
-		if daemonArgs.concurrency == 1 {
-			logrus.Infoln("Serial poller started")
-			for {
-				cont, err := judge.Poll(ctx)
-				if err != nil {
-					logrus.Println("Error:", err)
-				}
-				waitDur := time.Duration(0)
-				if !cont {
-					waitDur = time.Duration(daemonArgs.pollInterval) * time.Second
-				}
-				timer := time.NewTimer(waitDur)
-				select {
-				case <-ctx.Done():
-					if !timer.Stop() {
-						<-timer.C
-					}
-					logrus.Infoln("Serial poller stopped")
-					return nil
-				case <-timer.C:
-				}
-			}
-		} else {
-			wg := sync.WaitGroup{}
-			queue := make(chan *judge.RemoteJudgeTask)
-			wg.Add(1)
-			go func() {
-				judge.ParallelPoller(ctx, daemonArgs.pollInterval, queue)
-				wg.Done()
-			}()
-			for i := 0; i < daemonArgs.concurrency; i++ {
-				wg.Add(1)
-				go func() {
-					judge.ParallelJudger(ctx, queue)
-					wg.Done()
-				}()
-			}
-			wg.Wait()
-			return nil
-		}
+		clients := loadClients()
+		taskCtx, cancel := withDrain(ctx, daemonArgs.drainTimeout)
+		defer cancel()
+		startMetrics(taskCtx)
+		startAdmin(taskCtx, clients)
+		startEvents(ctx, clients)
+		holdStorage(taskCtx)
+		startCacheJanitor(taskCtx)
+		startCacheWarm(taskCtx, daemonArgs.warmCache)
+		runJudgeRole(ctx, taskCtx, clients, &daemonArgs.judgeRoleArgs)
+		return nil
 	}
 }
@@ -2,10 +2,7 @@ package cli
 
 import (
 	"context"
-	"time"
 
-	"github.com/fedstackjs/azukiiro/client"
-	"github.com/fedstackjs/azukiiro/instancer"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -25,37 +22,29 @@ func (c *instancerCommand) Mount(ctx context.Context, root *cobra.Command) {
 		RunE:  runInstancer(ctx, &instancerArgs),
 	}
 	instancerCmd.Flags().Float32Var(&instancerArgs.pollInterval, "poll-interval", 1, "Poll interval in seconds")
+	instancerCmd.Flags().IntVar(&instancerArgs.concurrency, "concurrency", 1, "Number of tasks handled at the same time")
+	instancerCmd.Flags().Float32Var(&instancerArgs.drainTimeout, "drain-timeout", 60, "Time in seconds to wait for running tasks on shutdown")
 	root.AddCommand(instancerCmd)
 }
 
 type instancerArgs struct {
 	pollInterval float32
+	drainTimeout float32
+	concurrency  int
 }
 
 func runInstancer(ctx context.Context, instancerArgs *instancerArgs) func(*cobra.Command, []string) error {
 	return func(cmd *cobra.Command, args []string) error {
 		logrus.Println("Starting instancer")
-		client.InitFromConfig()
-		logrus.Infoln("Serial poller started")
-		for {
-			cont, err := instancer.Poll(ctx)
-			if err != nil {
-				logrus.Println("Error:", err)
-			}
-			waitDur := time.Duration(0)
-			if !cont {
-				waitDur = time.Duration(instancerArgs.pollInterval) * time.Second
-			}
-			timer := time.NewTimer(waitDur)
-			select {
-			case <-ctx.Done():
-				if !timer.Stop() {
-					<-timer.C
-				}
-				logrus.Infoln("Serial poller stopped")
-				return nil
-			case <-timer.C:
-			}
-		}
+		clients := loadClients()
+		taskCtx, cancel := withDrain(ctx, instancerArgs.drainTimeout)
+		defer cancel()
+		startMetrics(taskCtx)
+		startAdmin(taskCtx, clients)
+		startEvents(ctx, clients)
+		holdStorage(taskCtx)
+		startCacheJanitor(taskCtx)
+		runInstancerRole(ctx, taskCtx, clients, instancerArgs.pollInterval, instancerArgs.concurrency)
+		return nil
 	}
 }
@@ -3,11 +3,13 @@ package cli
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/fedstackjs/azukiiro/common"
 	"github.com/fedstackjs/azukiiro/judge"
+	"github.com/fedstackjs/azukiiro/storage"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -33,6 +35,7 @@ func (c *judgeCmd) Mount(ctx context.Context, root *cobra.Command) {
 	judgeCmd.Flags().StringVar(&judgeArgs.solutionData, "solution-data", "", "Solution data file")
 	judgeCmd.MarkFlagRequired("solution-data")
 	judgeCmd.Flags().StringVar(&judgeArgs.env, "env", "{}", "Environment variables")
+	judgeCmd.Flags().StringVar(&judgeArgs.outputDir, "output-dir", "", "Directory to write artifacts to")
 	root.AddCommand(judgeCmd)
 }
 
@@ -41,6 +44,7 @@ type judgeArgs struct {
 	problemData   string
 	solutionData  string
 	env           string
+	outputDir     string
 }
 
 type localJudgeTask struct {
@@ -48,6 +52,7 @@ type localJudgeTask struct {
 	problemData  string
 	solutionData string
 	env          map[string]string
+	outputDir    string
 }
 
 func (t *localJudgeTask) Config() common.ProblemConfig {
@@ -84,6 +89,24 @@ func (t *localJudgeTask) UploadDetails(ctx context.Context, details *common.Solu
 	return nil
 }
 
+func (t *localJudgeTask) UploadArtifact(ctx context.Context, name string, content io.Reader) (*common.SolutionDetailsArtifact, error) {
+	artifact, err := judge.SpoolArtifact(name, content)
+	if err != nil {
+		return nil, err
+	}
+	defer artifact.Remove()
+	if t.outputDir == "" {
+		logrus.Infof("Artifact %s (%d bytes) discarded, use --output-dir to keep it", name, artifact.Size)
+		return &artifact.SolutionDetailsArtifact, nil
+	}
+	path := filepath.Join(t.outputDir, name)
+	if err := storage.SaveFile(path, artifact.Path); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Artifact %s written to %s", name, path)
+	return &artifact.SolutionDetailsArtifact, nil
+}
+
 func runJudge(ctx context.Context, regArgs *judgeArgs) func(*cobra.Command, []string) error {
 	return func(cmd *cobra.Command, args []string) error {
 		content, err := os.ReadFile(regArgs.problemConfig)
@@ -94,11 +117,13 @@ func runJudge(ctx context.Context, regArgs *judgeArgs) func(*cobra.Command, []st
 		if err := json.Unmarshal(content, &problemConfig); err != nil {
 			return err
 		}
-		regArgs.problemData, err = filepath.Abs(regArgs.problemData)
+		ctx, unpin := storage.WithTask(ctx)
+		defer unpin()
+		problemData, err := storage.FetchLocal(ctx, regArgs.problemData)
 		if err != nil {
 			return err
 		}
-		regArgs.solutionData, err = filepath.Abs(regArgs.solutionData)
+		solutionData, err := storage.FetchLocal(ctx, regArgs.solutionData)
 		if err != nil {
 			return err
 		}
@@ -108,9 +133,10 @@ func runJudge(ctx context.Context, regArgs *judgeArgs) func(*cobra.Command, []st
 		}
 		task := &localJudgeTask{
 			config:       problemConfig,
-			problemData:  regArgs.problemData,
-			solutionData: regArgs.solutionData,
+			problemData:  problemData,
+			solutionData: solutionData,
 			env:          env,
+			outputDir:    regArgs.outputDir,
 		}
 		adapter, ok := judge.GetAdapter(problemConfig.Judge.Adapter)
 		if !ok {
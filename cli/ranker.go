@@ -2,11 +2,7 @@ package cli
 
 import (
 	"context"
-	"time"
 
-	"github.com/fedstackjs/azukiiro/client"
-	"github.com/fedstackjs/azukiiro/db"
-	"github.com/fedstackjs/azukiiro/ranker"
 	"github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
@@ -27,38 +23,27 @@ func (c *rankerCmd) Mount(ctx context.Context, root *cobra.Command) {
 		RunE:  runRanker(ctx, &rankerArgs),
 	}
 	rankerCmd.Flags().Float32Var(&rankerArgs.pollInterval, "poll-interval", 1, "Poll interval in seconds")
+	rankerCmd.Flags().IntVar(&rankerArgs.concurrency, "concurrency", 1, "Number of tasks handled at the same time")
+	rankerCmd.Flags().Float32Var(&rankerArgs.drainTimeout, "drain-timeout", 60, "Time in seconds to wait for running tasks on shutdown")
 	root.AddCommand(rankerCmd)
 }
 
 type rankerArgs struct {
 	pollInterval float32
+	drainTimeout float32
+	concurrency  int
 }
 
 func runRanker(ctx context.Context, rankerArgs *rankerArgs) func(*cobra.Command, []string) error {
 	return func(cmd *cobra.Command, args []string) error {
 		logrus.Println("Starting ranker")
-		ctx, cleanup := db.WithMongo(ctx)
-		defer cleanup()
-
-		client.InitFromConfig()
-		for {
-			cont, err := ranker.Poll(ctx)
-			if err != nil {
-				logrus.Println("Error:", err)
-			}
-			waitDur := time.Duration(0)
-			if !cont {
-				waitDur = time.Duration(rankerArgs.pollInterval) * time.Second
-			}
-			timer := time.NewTimer(waitDur)
-			select {
-			case <-ctx.Done():
-				if !timer.Stop() {
-					<-timer.C
-				}
-				return nil
-			case <-timer.C:
-			}
-		}
+		clients := loadClients()
+		taskCtx, cancel := withDrain(ctx, rankerArgs.drainTimeout)
+		defer cancel()
+		startMetrics(taskCtx)
+		startAdmin(taskCtx, clients)
+		startEvents(ctx, clients)
+		runRankerRole(ctx, taskCtx, clients, rankerArgs.pollInterval, rankerArgs.concurrency)
+		return nil
 	}
 }
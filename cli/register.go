@@ -11,6 +11,7 @@ import (
 
 	"github.com/fedstackjs/azukiiro/client"
 	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/secret"
 )
 
 func init() {
@@ -32,6 +33,7 @@ func (c *registerCmd) Mount(ctx context.Context, root *cobra.Command) {
 	registerCmd.Flags().StringVar(&regArgs.Token, "token", "", "Runner token")
 	registerCmd.Flags().StringVar(&regArgs.Name, "name", "", "Runner name")
 	registerCmd.Flags().StringVar(&regArgs.Labels, "labels", "", "Runner tags, comma separated")
+	registerCmd.Flags().StringVar(&regArgs.ServerName, "server-name", "", "Register to an additional server, stored under servers.<name>")
 	registerCmd.MarkFlagRequired("server")
 	registerCmd.MarkFlagRequired("token")
 	root.AddCommand(registerCmd)
@@ -43,6 +45,7 @@ type registerArgs struct {
 	Name       string
 	Labels     string
 	Token      string
+	ServerName string
 }
 
 func splitLabels(input string) []string {
@@ -54,16 +57,20 @@ func runRegister(ctx context.Context, regArgs *registerArgs) func(*cobra.Command
 		logrus.Println("Registering runner")
 		logrus.Println("ServerAddr:", regArgs.ServerAddr)
 
-		runnerKey := viper.GetString("runnerKey")
-		if runnerKey != "" && !regArgs.Force {
+		key := func(key string) string {
+			return client.ConfigKey(regArgs.ServerName, key)
+		}
+		if runnerKey, err := secret.Get(key("runnerKey")); err == nil && runnerKey != "" && !regArgs.Force {
 			logrus.Println("Runner already registered, exiting...")
 			return nil
 		}
+		if err := secret.CheckWritable(key("runnerKey")); err != nil {
+			return err
+		}
 
-		viper.Set("serverAddr", regArgs.ServerAddr)
+		viper.Set(key("serverAddr"), regArgs.ServerAddr)
 
-		http := client.GetDefaultHTTPClient()
-		http.SetBaseURL(regArgs.ServerAddr)
+		c := client.New(regArgs.ServerName, regArgs.ServerAddr)
 
 		if regArgs.Name == "" {
 			name, err := os.Hostname()
@@ -84,16 +91,20 @@ func runRegister(ctx context.Context, regArgs *registerArgs) func(*cobra.Command
 			RegistrationToken: regArgs.Token,
 		}
 
-		res, err := client.Register(ctx, req)
+		res, err := c.Register(ctx, req)
 
 		if err != nil {
 			logrus.Fatalln(err)
 		}
 
 		logrus.Println("RunnerId:", res.RunnerId)
-		viper.Set("runnerId", res.RunnerId)
-		viper.Set("runnerKey", res.RunnerKey)
-		viper.WriteConfig()
+		viper.Set(key("runnerId"), res.RunnerId)
+		if err := secret.Store(key("runnerKey"), res.RunnerKey); err != nil {
+			logrus.Fatalln("Failed to store runner key:", err)
+		}
+		if err := secret.WriteConfig(); err != nil {
+			logrus.Fatalln("Failed to write config:", err)
+		}
 
 		logrus.Println("Runner registered successfully")
 
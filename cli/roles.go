@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/admin"
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/db"
+	"github.com/fedstackjs/azukiiro/instancer"
+	"github.com/fedstackjs/azukiiro/judge"
+	"github.com/fedstackjs/azukiiro/metrics"
+	"github.com/fedstackjs/azukiiro/ranker"
+	"github.com/fedstackjs/azukiiro/registry"
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// startMetrics serves Prometheus metrics in the background if metrics.listen is configured
+func startMetrics(ctx context.Context) {
+	addr := viper.GetString("metrics.listen")
+	if addr == "" {
+		return
+	}
+	go func() {
+		if err := metrics.Serve(ctx, addr); err != nil {
+			logrus.Errorln("Metrics server failed:", err)
+		}
+	}()
+}
+
+// loadClients returns the clients of all configured servers, exiting if one cannot be loaded
+func loadClients() []*client.Client {
+	clients, err := client.LoadAll()
+	if err != nil {
+		logrus.Fatalln("Failed to load server config:", err)
+	}
+	for _, c := range clients {
+		if c.Name() != "" {
+			logrus.Infof("Serving server %s", c.Name())
+		}
+	}
+	return clients
+}
+
+// startAdmin serves the admin API in the background if admin.listen is configured
+func startAdmin(ctx context.Context, clients []*client.Client) {
+	addr := viper.GetString("admin.listen")
+	if addr == "" {
+		return
+	}
+	go func() {
+		if err := admin.Serve(ctx, addr, clients); err != nil {
+			logrus.Errorln("Admin server failed:", err)
+		}
+	}()
+}
+
+// holdStorage marks the storage path as used until ctx is done, so storage maintenance commands
+// refuse to remove files while the runner is running
+func holdStorage(ctx context.Context) {
+	if err := storage.Hold(ctx); err != nil && ctx.Err() == nil {
+		logrus.Warnln("Failed to lock the storage path:", err)
+	}
+}
+
+// startCacheJanitor evicts downloaded files in the background if a cache budget is configured
+func startCacheJanitor(ctx context.Context) {
+	interval := viper.GetFloat64("cache.janitorInterval")
+	if interval <= 0 {
+		interval = 300
+	}
+	go storage.RunCacheJanitor(ctx, time.Duration(interval*float64(time.Second)))
+}
+
+// startEvents subscribes to server events in the background if client.events.enabled is set,
+// so idle pollers are woken as soon as a task is available
+func startEvents(ctx context.Context, clients []*client.Client) {
+	if !viper.GetBool("client.events.enabled") {
+		return
+	}
+	for _, c := range clients {
+		go c.SubscribeEvents(ctx)
+	}
+}
+
+// pollLoop calls poll with each client until ctx is done, waiting up to pollInterval seconds whenever
+// there is no task and while the role is paused. The wait is skipped after long polls and cut short
+// by server events of kind. Each client is polled by concurrency goroutines, which share concurrency
+// slots so that at most that many calls to poll run at once over all clients.
+func pollLoop(ctx context.Context, name string, kind string, clients []*client.Client, tasks *registry.Registry, pollInterval float32, concurrency int, poll func(c *client.Client) (bool, error)) {
+	concurrency = max(concurrency, 1)
+	slots := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for _, c := range clients {
+		log := c.Logger()
+		log.Infof("%s poller started", name)
+		workers := sync.WaitGroup{}
+		for i := 0; i < concurrency; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for ctx.Err() == nil {
+					if err := tasks.WaitResumed(ctx); err != nil {
+						break
+					}
+					select {
+					case slots <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+					cont, err := poll(c)
+					<-slots
+					if err != nil && ctx.Err() == nil {
+						log.Println("Error:", err)
+					}
+					if !cont {
+						c.IdleWait(ctx, kind, time.Duration(pollInterval*float32(time.Second)))
+					}
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers.Wait()
+			log.Infof("%s poller stopped", name)
+		}()
+	}
+	wg.Wait()
+}
+
+type judgeRoleArgs struct {
+	concurrency         int
+	prefetchConcurrency int
+	queueDepth          int
+	pollInterval        float32
+}
+
+// runJudgeRole polls solutions until ctx is done, running tasks with contexts derived from taskCtx.
+// Several servers share the concurrency, so they are always polled by the parallel judge.
+func runJudgeRole(ctx context.Context, taskCtx context.Context, clients []*client.Client, args *judgeRoleArgs) {
+	if args.concurrency <= 1 && len(clients) == 1 {
+		pollCtx := client.WithPollStop(taskCtx, ctx)
+		pollLoop(ctx, "Judge", client.KindSolution, clients, judge.Tasks, args.pollInterval, 1, func(c *client.Client) (bool, error) {
+			return judge.Poll(pollCtx, c)
+		})
+		return
+	}
+	judge.RunParallel(ctx, taskCtx, clients, judge.ParallelOptions{
+		Concurrency:         max(args.concurrency, 1),
+		PrefetchConcurrency: args.prefetchConcurrency,
+		QueueDepth:          args.queueDepth,
+		PollInterval:        args.pollInterval,
+	})
+}
+
+func runInstancerRole(ctx context.Context, taskCtx context.Context, clients []*client.Client, pollInterval float32, concurrency int) {
+	pollCtx := client.WithPollStop(taskCtx, ctx)
+	pollLoop(ctx, "Instancer", client.KindInstance, clients, instancer.Tasks, pollInterval, concurrency, func(c *client.Client) (bool, error) {
+		return instancer.Poll(pollCtx, c)
+	})
+}
+
+func runRankerRole(ctx context.Context, taskCtx context.Context, clients []*client.Client, pollInterval float32, concurrency int) {
+	taskCtx, cleanup := db.WithMongo(taskCtx)
+	defer cleanup()
+	pollCtx := client.WithPollStop(taskCtx, ctx)
+	pollLoop(ctx, "Ranker", client.KindRanklist, clients, ranker.Tasks, pollInterval, concurrency, func(c *client.Client) (bool, error) {
+		return ranker.Poll(pollCtx, c)
+	})
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/fedstackjs/azukiiro/admin"
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/secret"
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	commands = append(commands, &rotateKeyCmd{})
+}
+
+type rotateKeyCmd struct{}
+
+func (c *rotateKeyCmd) Mount(ctx context.Context, root *cobra.Command) {
+	var serverName string
+	cmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Replace the runner key with a new one from the server",
+		Long: "Replace the runner key with a new one from the server and store it where it was loaded from.\n" +
+			"If admin.listen is configured and the runner is running, it rotates the key itself without restarting. " +
+			"Otherwise running runners reload the key once the server rejects the old one.\n" +
+			"Use --server-name to rotate the key of a server configured under servers.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if addr := viper.GetString("admin.listen"); addr != "" {
+				if rotated, err := rotateKeyThroughAdmin(ctx, addr, serverName); rotated || err != nil {
+					return err
+				}
+			}
+			keyName := client.ConfigKey(serverName, "runnerKey")
+			if err := secret.CheckWritable(keyName); err != nil {
+				return err
+			}
+			c, err := client.Load(serverName)
+			if err != nil {
+				return err
+			}
+			key, err := c.RotateKey(ctx)
+			if err != nil {
+				return err
+			}
+			if err := secret.Store(keyName, key); err != nil {
+				// The old key no longer works, keep the new one so it is not lost
+				path, saveErr := secret.SaveRecovery(storage.GetRootPath(), keyName, key)
+				if saveErr != nil {
+					// Nothing else holds the key, print it as a last resort
+					fmt.Println(key)
+					return fmt.Errorf("failed to store the new key printed above: %w, failed to save it: %v", err, saveErr)
+				}
+				return fmt.Errorf("failed to store the new key: %w; it was saved to %s, move it to where runnerKey is loaded from", err, path)
+			}
+			logrus.Infoln("Runner key rotated")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&serverName, "server-name", "", "Name of the server under servers, empty for the default server")
+	root.AddCommand(cmd)
+}
+
+// rotateKeyThroughAdmin asks a running runner to rotate its key, it reports false if none is listening
+func rotateKeyThroughAdmin(ctx context.Context, addr string, serverName string) (bool, error) {
+	path := "/rotate-key"
+	if serverName != "" {
+		path += "?server=" + url.QueryEscape(serverName)
+	}
+	res, err := admin.Post(ctx, addr, path)
+	if err != nil {
+		logrus.Infoln("Runner not reachable through the admin API, rotating the key locally:", err)
+		return false, nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNoContent {
+		logrus.Infoln("Runner key rotated by the running runner")
+		return true, nil
+	}
+	body := struct {
+		Message string `json:"message"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.Message == "" {
+		return true, fmt.Errorf("admin API returned %s", res.Status)
+	}
+	return true, errors.New(body.Message)
+}
@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	commands = append(commands, &runCommand{})
+}
+
+var allRoles = []string{"judge", "instancer", "ranker"}
+
+type runCommand struct{}
+
+func (c *runCommand) Mount(ctx context.Context, root *cobra.Command) {
+	var runArgs runArgs
+	runCmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run multiple roles in one process",
+		Long: `Run multiple roles in one process.
+
+Roles are configured in the roles section of the config file:
+
+  roles:
+    judge:
+      concurrency: 4
+      pollInterval: 1
+    instancer:
+      concurrency: 1
+      pollInterval: 1
+    ranker:
+      concurrency: 1
+      pollInterval: 5
+
+If --roles is not given, all configured roles are run.`,
+		Args: cobra.MaximumNArgs(0),
+		RunE: runRun(ctx, &runArgs),
+	}
+	runCmd.Flags().StringVar(&runArgs.roles, "roles", "", "Roles to run, comma separated ("+strings.Join(allRoles, ",")+")")
+	runCmd.Flags().Float32Var(&runArgs.drainTimeout, "drain-timeout", 60, "Time in seconds to wait for running tasks on shutdown")
+	root.AddCommand(runCmd)
+}
+
+type runArgs struct {
+	roles        string
+	drainTimeout float32
+}
+
+func getRoles(input string) ([]string, error) {
+	if input == "" {
+		roles := []string{}
+		for _, role := range allRoles {
+			if viper.IsSet("roles." + role) {
+				roles = append(roles, role)
+			}
+		}
+		if len(roles) == 0 {
+			return nil, fmt.Errorf("no roles configured")
+		}
+		return roles, nil
+	}
+	roles := strings.Split(input, ",")
+	for i, role := range roles {
+		switch role {
+		case "judge", "instancer", "ranker":
+		default:
+			return nil, fmt.Errorf("unknown role: %s", role)
+		}
+		// Two pollers of a role would share its registry and budgets without knowing of each other
+		if slices.Contains(roles[:i], role) {
+			return nil, fmt.Errorf("duplicate role: %s", role)
+		}
+	}
+	return roles, nil
+}
+
+func getRoleFloat(role string, key string, defaultValue float32) float32 {
+	key = "roles." + role + "." + key
+	if !viper.IsSet(key) {
+		return defaultValue
+	}
+	return float32(viper.GetFloat64(key))
+}
+
+func getRoleInt(role string, key string, defaultValue int) int {
+	key = "roles." + role + "." + key
+	if !viper.IsSet(key) {
+		return defaultValue
+	}
+	return viper.GetInt(key)
+}
+
+func runRun(ctx context.Context, runArgs *runArgs) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		roles, err := getRoles(runArgs.roles)
+		if err != nil {
+			return err
+		}
+		logrus.Println("Starting roles:", roles)
+		clients := loadClients()
+		taskCtx, cancel := withDrain(ctx, runArgs.drainTimeout)
+		defer cancel()
+		startMetrics(taskCtx)
+		startAdmin(taskCtx, clients)
+		startEvents(ctx, clients)
+		holdStorage(taskCtx)
+		startCacheJanitor(taskCtx)
+		startCacheWarm(taskCtx, "")
+
+		wg := sync.WaitGroup{}
+		for _, role := range roles {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				switch role {
+				case "judge":
+					runJudgeRole(ctx, taskCtx, clients, &judgeRoleArgs{
+						concurrency:         getRoleInt(role, "concurrency", 1),
+						prefetchConcurrency: getRoleInt(role, "prefetchConcurrency", 2),
+						queueDepth:          getRoleInt(role, "queueDepth", 1),
+						pollInterval:        getRoleFloat(role, "pollInterval", 1),
+					})
+				case "instancer":
+					runInstancerRole(ctx, taskCtx, clients, getRoleFloat(role, "pollInterval", 1), getRoleInt(role, "concurrency", 1))
+				case "ranker":
+					runRankerRole(ctx, taskCtx, clients, getRoleFloat(role, "pollInterval", 1), getRoleInt(role, "concurrency", 1))
+				}
+			}()
+		}
+		wg.Wait()
+		return nil
+	}
+}
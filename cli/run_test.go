@@ -0,0 +1,18 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGetRoles(t *testing.T) {
+	roles, err := getRoles("judge,ranker")
+	if err != nil || !slices.Equal(roles, []string{"judge", "ranker"}) {
+		t.Errorf("getRoles = %v, %v", roles, err)
+	}
+	for _, input := range []string{"judge,judge", "judge,ranker,judge", "judge,worker"} {
+		if roles, err := getRoles(input); err == nil {
+			t.Errorf("getRoles(%q) = %v, want an error", input, roles)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	commands = append(commands, &storageCmd{})
+}
+
+type storageCmd struct{}
+
+func (c *storageCmd) Mount(ctx context.Context, root *cobra.Command) {
+	storageCmd := &cobra.Command{
+		Use:   "storage",
+		Short: "Maintain the storage path",
+		Long: "Maintain the storage path.\n\n" +
+			"Commands that remove files do not know which files a running runner is using, " +
+			"they refuse to run while one uses the same storage path. Stop it first or use --dry-run.",
+	}
+	storageCmd.AddCommand(
+		storageLsCmd(),
+		storageVerifyCmd(ctx),
+		storageGcCmd(ctx),
+		storageCleanTmpCmd(),
+		storageDuCmd(),
+	)
+	root.AddCommand(storageCmd)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+// formatAge formats the time since t, rounded to a readable precision
+func formatAge(t time.Time) string {
+	age := time.Since(t)
+	switch {
+	case age < time.Minute:
+		return age.Round(time.Second).String()
+	case age < 48*time.Hour:
+		return age.Round(time.Minute).String()
+	default:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	}
+}
+
+func storageLsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ls",
+		Short: "List cached files, most recently used first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := storage.ListCache()
+			if err != nil {
+				return err
+			}
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i].LastAccess.After(entries[j].LastAccess)
+			})
+			table := newTable()
+			fmt.Fprintln(table, "HASH\tSIZE\tAGE\tLAST ACCESS")
+			var total int64
+			for _, entry := range entries {
+				total += entry.Size
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s ago\n", entry.Hash, storage.FormatSize(entry.Size), formatAge(entry.DownloadedAt), formatAge(entry.LastAccess))
+			}
+			table.Flush()
+			fmt.Printf("%d files, %s\n", len(entries), storage.FormatSize(total))
+			return nil
+		},
+	}
+}
+
+func storageVerifyCmd(ctx context.Context) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify [hash...]",
+		Short: "Rehash cached files and remove corrupted ones",
+		// Corrupted files are reported through the exit code, not as a usage error
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, hash := range args {
+				if err := storage.CheckHash(hash); err != nil {
+					return err
+				}
+			}
+			unlock, err := storage.LockMaintenance()
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			hashes := args
+			if len(hashes) == 0 {
+				entries, err := storage.ListCache()
+				if err != nil {
+					return err
+				}
+				for _, entry := range entries {
+					hashes = append(hashes, entry.Hash)
+				}
+			}
+			corrupted := 0
+			for _, hash := range hashes {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				ok, err := storage.VerifyCached(ctx, hash)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					corrupted++
+				}
+			}
+			logrus.Infof("Verified %d files, %d missing or corrupted", len(hashes), corrupted)
+			if corrupted > 0 {
+				return fmt.Errorf("%d files missing or corrupted", corrupted)
+			}
+			return nil
+		},
+	}
+}
+
+func storageGcCmd(ctx context.Context) *cobra.Command {
+	var maxSize, minFree string
+	var maxAge time.Duration
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Evict cached files by size or age",
+		Long: "Evict least recently used cached files until the cache is within the given policy.\n" +
+			"Without flags, the cache.maxSize, cache.minFree and cache.maxAge config is used.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			budget, err := storage.GetCacheBudget()
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("max-size") {
+				if budget.MaxSize, err = storage.ParseSize(maxSize); err != nil {
+					return err
+				}
+			}
+			if cmd.Flags().Changed("min-free") {
+				if budget.MinFree, err = storage.ParseSize(minFree); err != nil {
+					return err
+				}
+			}
+			if cmd.Flags().Changed("max-age") {
+				budget.MaxAge = maxAge
+			}
+			if budget.MaxSize <= 0 && budget.MinFree <= 0 && budget.MaxAge <= 0 {
+				return fmt.Errorf("no policy given, use --max-size, --min-free or --max-age")
+			}
+			if !dryRun {
+				unlock, err := storage.LockMaintenance()
+				if err != nil {
+					cmd.SilenceUsage = true
+					return err
+				}
+				defer unlock()
+			}
+			if dryRun {
+				planned, err := storage.PlanEviction(ctx, budget)
+				if err != nil {
+					return err
+				}
+				var total int64
+				for _, entry := range planned {
+					total += entry.Size
+					fmt.Printf("%s\t%s\t%s ago\n", entry.Hash, storage.FormatSize(entry.Size), formatAge(entry.LastAccess))
+				}
+				logrus.Infof("Would free %s", storage.FormatSize(total))
+				return nil
+			}
+			freed, err := storage.EvictCache(ctx, budget)
+			if err != nil {
+				return err
+			}
+			logrus.Infof("Freed %s", storage.FormatSize(freed))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "Maximum cache size, such as 10G")
+	cmd.Flags().StringVar(&minFree, "min-free", "", "Minimum free space on the cache filesystem, such as 5G")
+	cmd.Flags().DurationVar(&maxAge, "max-age", 0, "Evict files not accessed for this long, such as 720h")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only list the files that would be evicted")
+	return cmd
+}
+
+func storageCleanTmpCmd() *cobra.Command {
+	var olderThan time.Duration
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "clean-tmp",
+		Short: "Remove stale files left in tmp by killed tasks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !dryRun {
+				unlock, err := storage.LockMaintenance()
+				if err != nil {
+					cmd.SilenceUsage = true
+					return err
+				}
+				defer unlock()
+			}
+			stale, err := storage.StaleTmp(olderThan)
+			if err != nil {
+				return err
+			}
+			removed, freed := 0, int64(0)
+			for _, entry := range stale {
+				fmt.Printf("%s\t%s\t%s\n", entry.Name, storage.FormatSize(entry.Bytes), formatAge(entry.ModTime))
+				if dryRun {
+					continue
+				}
+				if err := storage.RemoveTmp(entry.Name); err != nil {
+					logrus.Warnf("Failed to remove %s: %v", entry.Name, err)
+					continue
+				}
+				removed++
+				freed += entry.Bytes
+			}
+			if !dryRun {
+				logrus.Infof("Removed %d entries, freed %s", removed, storage.FormatSize(freed))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&olderThan, "older-than", 24*time.Hour, "Only remove entries not modified for this long")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only list stale entries")
+	return cmd
+}
+
+func storageDuCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "du",
+		Short: "Summarize disk usage of the storage path",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			areas, err := storage.DiskUsage()
+			if err != nil {
+				return err
+			}
+			table := newTable()
+			fmt.Fprintln(table, "AREA\tFILES\tSIZE")
+			files, bytes := 0, int64(0)
+			for _, area := range areas {
+				files += area.Files
+				bytes += area.Bytes
+				fmt.Fprintf(table, "%s\t%d\t%s\n", area.Name, area.Files, storage.FormatSize(area.Bytes))
+			}
+			fmt.Fprintf(table, "total\t%d\t%s\n", files, storage.FormatSize(bytes))
+			table.Flush()
+			fmt.Println("Storage path:", storage.GetRootPath())
+			return nil
+		},
+	}
+}
@@ -1,35 +1,146 @@
 package client
 
 import (
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/transport"
 	"github.com/go-resty/resty/v2"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-var http = resty.New()
+// Client talks to one AOI server with the credentials of this runner on it. A runner registered to
+// several servers has one Client for each.
+type Client struct {
+	name string
+	http *resty.Client
+	// Client for long poll requests, which have a deadline of their own instead of the client timeout
+	poll *resty.Client
+	// Timeout of API requests, 0 if disabled
+	timeout time.Duration
+	// Credentials sent with every request, swapped atomically when the key is rotated or reloaded
+	creds  atomic.Pointer[credentials]
+	reload struct {
+		mu   sync.Mutex
+		last time.Time
+	}
+	retryPolicy RetryPolicy
+	// Time the server may hold a poll request, 0 disables long polling
+	longPollWait time.Duration
+	// Set once the server answered a renew request as unknown, so heartbeats are no longer sent
+	renewUnsupported atomic.Bool
+	pollers          map[string]*poller
+}
 
-func GetDefaultHTTPClient() *resty.Client {
-	return http
+// New returns a client for the server at serverAddr without credentials, such as for registering.
+// The name identifies the server in logs and config, the default server has the empty name.
+func New(name string, serverAddr string) *Client {
+	use := "server"
+	if name != "" {
+		use = "servers." + name
+	}
+	httpClient := transport.Client(use)
+	c := &Client{
+		name:         name,
+		http:         resty.NewWithClient(httpClient),
+		poll:         resty.NewWithClient(&nethttp.Client{Transport: httpClient.Transport}),
+		timeout:      httpClient.Timeout,
+		retryPolicy:  loadRetryPolicy(),
+		longPollWait: time.Duration(viper.GetFloat64("client.longPoll.wait") * float64(time.Second)),
+		pollers: map[string]*poller{
+			KindSolution: {wake: make(chan struct{}, 1)},
+			KindInstance: {wake: make(chan struct{}, 1)},
+			KindRanklist: {wake: make(chan struct{}, 1)},
+		},
+	}
+	for _, r := range []*resty.Client{c.http, c.poll} {
+		r.SetBaseURL(serverAddr)
+		r.SetHeader("User-Agent", common.GetVersion())
+		r.OnBeforeRequest(c.authenticate)
+		instrument(r)
+	}
+	return c
 }
 
-func InitFromConfig() {
-	serverAddr := viper.GetString("serverAddr")
+// Name returns the name of the server, empty for the default server
+func (c *Client) Name() string {
+	return c.name
+}
+
+// ConfigKey returns the config key of a setting of the server, such as runnerKey for the default
+// server and servers.<name>.runnerKey for the others
+func ConfigKey(name string, key string) string {
+	if name == "" {
+		return key
+	}
+	return "servers." + name + "." + key
+}
+
+// Load returns the client of a configured server with its credentials
+func Load(name string) (*Client, error) {
+	serverAddr := viper.GetString(ConfigKey(name, "serverAddr"))
 	if serverAddr == "" {
-		logrus.Fatalln("Server address not set")
-	}
-	http.SetBaseURL(serverAddr)
-	runnerId := viper.GetString("runnerId")
-	if runnerId == "" {
-		logrus.Fatalln("Runner ID not set")
-	}
-	runnerKey := viper.GetString("runnerKey")
-	if runnerKey == "" {
-		logrus.Fatalln("Runner key not set")
-	}
-	http.SetHeaders(map[string]string{
-		"X-AOI-Runner-Id":  runnerId,
-		"X-AOI-Runner-Key": runnerKey,
-		"User-Agent":       common.GetVersion(),
-	})
+		if name == "" {
+			return nil, errors.New("server address not set")
+		}
+		return nil, fmt.Errorf("server %s: server address not set", name)
+	}
+	c := New(name, serverAddr)
+	if err := c.LoadCredentials(); err != nil {
+		if name == "" {
+			return nil, err
+		}
+		return nil, fmt.Errorf("server %s: %w", name, err)
+	}
+	return c, nil
+}
+
+// LoadAll returns the clients of the default server if serverAddr is set, and of every server under servers
+func LoadAll() ([]*Client, error) {
+	names := []string{}
+	if viper.GetString("serverAddr") != "" {
+		names = append(names, "")
+	}
+	named := []string{}
+	for name := range viper.GetStringMap("servers") {
+		named = append(named, name)
+	}
+	sort.Strings(named)
+	names = append(names, named...)
+	if len(names) == 0 {
+		return nil, errors.New("server address not set")
+	}
+	clients := []*Client{}
+	for _, name := range names {
+		c, err := Load(name)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+// TaskFields adds the name of the server to the log fields of a task from it, unless it is the default server
+func (c *Client) TaskFields(fields logrus.Fields) logrus.Fields {
+	if c.name != "" {
+		fields["server"] = c.name
+	}
+	return fields
+}
+
+// Logger returns the logger for messages about the server, naming it unless it is the default one
+func (c *Client) Logger() *logrus.Entry {
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	if c.name != "" {
+		entry = entry.WithField("server", c.name)
+	}
+	return entry
 }
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"errors"
+	nethttp "net/http"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/fedstackjs/azukiiro/secret"
+	"github.com/go-resty/resty/v2"
+)
+
+type credentials struct {
+	runnerId  string
+	runnerKey string
+}
+
+// authenticate is the request middleware adding the credentials in use
+func (c *Client) authenticate(_ *resty.Client, r *resty.Request) error {
+	c.setCredentialHeaders(r.Header)
+	return nil
+}
+
+// setCredentialHeaders authenticates a request with the credentials in use
+func (c *Client) setCredentialHeaders(header nethttp.Header) {
+	if creds := c.creds.Load(); creds != nil {
+		header.Set("X-AOI-Runner-Id", creds.runnerId)
+		header.Set("X-AOI-Runner-Key", creds.runnerKey)
+	}
+}
+
+// LoadCredentials resolves the runner ID and key of the server from the config, see package secret
+func (c *Client) LoadCredentials() error {
+	creds, err := c.loadCredentials(false)
+	if err != nil {
+		return err
+	}
+	c.setCredentials(creds)
+	return nil
+}
+
+func (c *Client) loadCredentials(reloading bool) (*credentials, error) {
+	get := secret.Get
+	if reloading {
+		get = secret.Reload
+	}
+	runnerId, err := get(ConfigKey(c.name, "runnerId"))
+	if err != nil {
+		return nil, err
+	}
+	if runnerId == "" {
+		return nil, errors.New("runner ID not set")
+	}
+	runnerKey, err := get(ConfigKey(c.name, "runnerKey"))
+	if err != nil {
+		return nil, err
+	}
+	if runnerKey == "" {
+		return nil, errors.New("runner key not set")
+	}
+	return &credentials{runnerId: runnerId, runnerKey: runnerKey}, nil
+}
+
+func (c *Client) setCredentials(creds *credentials) {
+	logging.AddSecret(creds.runnerKey)
+	c.creds.Store(creds)
+}
+
+// reloadCredentials reads the credentials again after the server rejected them, such as after
+// azukiiro rotate-key ran in another process. It reports whether they changed.
+func (c *Client) reloadCredentials() bool {
+	c.reload.mu.Lock()
+	defer c.reload.mu.Unlock()
+	old := c.creds.Load()
+	if old == nil || time.Since(c.reload.last) < 5*time.Second {
+		return false
+	}
+	c.reload.last = time.Now()
+	creds, err := c.loadCredentials(true)
+	if err != nil {
+		c.Logger().Warnln("Failed to reload credentials:", err)
+		return false
+	}
+	if *creds == *old {
+		return false
+	}
+	c.setCredentials(creds)
+	c.Logger().Infoln("Runner credentials changed, using the new ones")
+	return true
+}
+
+func isUnauthorized(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == nethttp.StatusUnauthorized
+}
+
+type RotateKeyResponse struct {
+	RunnerKey string `json:"runnerKey"`
+}
+
+// RotateKey asks the server for a new runner key and uses it for later requests. The caller is
+// responsible for storing it, the old key may stop working once the server answers.
+func (c *Client) RotateKey(ctx context.Context) (string, error) {
+	res := &RotateKeyResponse{}
+	// Not retried, a lost response would leave the runner with a key the server no longer accepts
+	raw, err := c.http.R().
+		SetContext(ctx).
+		SetResult(res).
+		Post("/api/runner/rotateKey")
+	err = loadError(raw, err)
+	if err != nil {
+		return "", err
+	}
+	if res.RunnerKey == "" {
+		return "", errors.New("server returned an empty runner key")
+	}
+	c.setCredentials(&credentials{runnerId: c.creds.Load().runnerId, runnerKey: res.RunnerKey})
+	return res.RunnerKey, nil
+}
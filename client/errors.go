@@ -1,7 +1,13 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	nethttp "net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 )
@@ -10,23 +16,66 @@ type APIError struct {
 	Message    string `json:"message"`
 	ErrorName  string `json:"error"`
 	StatusCode int    `json:"statusCode"`
+	// Delay requested by the server through the Retry-After header
+	RetryAfter time.Duration `json:"-"`
 }
 
 func (e *APIError) Error() string {
 	return e.Message
 }
 
+// maxErrorBody limits the length of non-JSON error bodies kept in APIError.Message
+const maxErrorBody = 256
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := nethttp.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
 func loadError(res *resty.Response, err error) error {
 	if err != nil {
 		return err
 	}
 	if res.IsError() {
 		apiError := &APIError{}
-		err := json.Unmarshal(res.Body(), apiError)
-		if err != nil {
-			return err
+		if err := json.Unmarshal(res.Body(), apiError); err != nil || apiError.Message == "" {
+			// Errors from proxies and load balancers are usually not JSON
+			body := strings.TrimSpace(string(res.Body()))
+			if len(body) > maxErrorBody {
+				body = body[:maxErrorBody] + "..."
+			}
+			if body == "" {
+				body = res.Status()
+			}
+			apiError.Message = body
 		}
+		apiError.StatusCode = res.StatusCode()
+		apiError.RetryAfter = parseRetryAfter(res.Header().Get("Retry-After"))
 		return apiError
 	}
 	return nil
 }
+
+// IsRetryable reports whether err is a network error or a server side error that may succeed on retry
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case nethttp.StatusRequestTimeout, nethttp.StatusTooManyRequests:
+			return true
+		}
+		return apiErr.StatusCode >= 500
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"strings"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/common"
+)
+
+// errEventsUnsupported is returned when the server has no event stream endpoint
+var errEventsUnsupported = errors.New("server does not support event subscription")
+
+// SubscribeEvents listens on the server event stream until ctx is done, waking idle pollers when a
+// task of their kind becomes available. The stream is reconnected with backoff when it breaks.
+// If the server lacks the endpoint, it returns and pollers keep polling every interval.
+func (c *Client) SubscribeEvents(ctx context.Context) {
+	for attempt := 0; ctx.Err() == nil; {
+		connected, err := c.readEvents(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errEventsUnsupported) {
+			c.Logger().Infoln("Server does not support event subscription, falling back to polling")
+			return
+		}
+		if connected {
+			attempt = 0
+		}
+		attempt++
+		delay := c.retryPolicy.backoff(attempt)
+		c.Logger().Warnf("Event stream disconnected, reconnecting in %v: %v", delay.Round(time.Millisecond), err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// readEvents reads the event stream until it breaks, reporting whether it was connected at all
+func (c *Client) readEvents(ctx context.Context) (bool, error) {
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, c.http.BaseURL+"/api/runner/events", nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("User-Agent", common.GetVersion())
+	c.setCredentialHeaders(req.Header)
+	// The stream is long-lived, so the request timeout of the API client does not apply
+	client := &nethttp.Client{Transport: c.http.GetClient().Transport}
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case nethttp.StatusOK:
+	case nethttp.StatusNotFound, nethttp.StatusMethodNotAllowed, nethttp.StatusNotImplemented:
+		return false, errEventsUnsupported
+	default:
+		if res.StatusCode == nethttp.StatusUnauthorized {
+			c.reloadCredentials()
+		}
+		return false, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	c.Logger().Infoln("Subscribed to server events")
+	// Wake all pollers since tasks may have been queued while disconnected
+	for kind := range c.pollers {
+		c.wakePoller(kind)
+	}
+
+	event := ""
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line dispatches the event, events without a known kind are ignored
+			c.wakePoller(event)
+			event = ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, errors.New("event stream closed by server")
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"errors"
+	nethttp "net/http"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/logging"
+)
+
+var ErrTaskRevoked = errors.New("task lease revoked by server")
+
+// isRevoked reports whether the server gave the lease of the task to another runner or dropped the task
+func isRevoked(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case nethttp.StatusConflict, nethttp.StatusGone:
+		return true
+	}
+	return false
+}
+
+// isRenewUnsupported reports whether the server has no renew endpoint, as servers without task leases
+func isRenewUnsupported(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case nethttp.StatusNotFound, nethttp.StatusMethodNotAllowed:
+		return true
+	}
+	return false
+}
+
+func (t *SolutionTask) runHeartbeat(ctx context.Context, interval time.Duration, revoke context.CancelCauseFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if t.client.renewUnsupported.Load() {
+			return
+		}
+		err := t.Renew(ctx)
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		if isRevoked(err) {
+			logging.FromContext(ctx).Warnln("Task lease revoked:", err)
+			revoke(ErrTaskRevoked)
+			return
+		}
+		if isRenewUnsupported(err) {
+			if !t.client.renewUnsupported.Swap(true) {
+				t.client.Logger().Infoln("Server does not support renewing task leases, heartbeats disabled:", err)
+			}
+			return
+		}
+		logging.FromContext(ctx).Warnln("Failed to renew task lease:", err)
+	}
+}
+
+// WithHeartbeat renews the lease of the task every interval until Complete or Release is called
+// or the returned function is invoked. If the server revokes the lease, the returned context is
+// canceled with ErrTaskRevoked. It must be called at most once, before the task is shared.
+func (t *SolutionTask) WithHeartbeat(ctx context.Context, interval time.Duration) (context.Context, context.CancelFunc) {
+	ctx, revoke := context.WithCancelCause(ctx)
+	hbCtx, stop := context.WithCancel(ctx)
+	if interval > 0 {
+		go t.runHeartbeat(hbCtx, interval, revoke)
+	}
+	t.heartbeat = stop
+	return ctx, func() {
+		stop()
+		revoke(context.Canceled)
+	}
+}
+
+func (t *SolutionTask) stopHeartbeat() {
+	if t.heartbeat != nil {
+		t.heartbeat()
+	}
+}
+
+// IsTaskRevoked reports whether ctx was canceled because the task lease was revoked
+func IsTaskRevoked(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrTaskRevoked)
+}
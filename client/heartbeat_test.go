@@ -0,0 +1,85 @@
+package client_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/aoitest"
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/common"
+)
+
+func loadClient(t *testing.T, srv *aoitest.Server) *client.Client {
+	t.Helper()
+	srv.Configure()
+	c, err := client.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// pollTask queues a judge task on srv and polls it with c
+func pollTask(t *testing.T, srv *aoitest.Server, c *client.Client) *client.SolutionTask {
+	t.Helper()
+	queued := srv.EnqueueSolution(common.ProblemConfig{}, []byte("problem"), []byte("solution"))
+	res, err := c.PollSolution(context.Background(), &client.PollSolutionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TaskId != queued.TaskId {
+		t.Fatalf("polled task %q, want %q", res.TaskId, queued.TaskId)
+	}
+	return c.SolutionTask(res.SolutionId, res.TaskId)
+}
+
+func countRenewals(srv *aoitest.Server) int {
+	n := 0
+	for _, req := range srv.Requests() {
+		if strings.HasSuffix(req.Path, "/renew") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHeartbeatRenewUnsupported(t *testing.T) {
+	srv := aoitest.NewServer()
+	defer srv.Close()
+	srv.NoRenew = true
+	c := loadClient(t, srv)
+
+	for i := 0; i < 2; i++ {
+		ctx, stop := pollTask(t, srv, c).WithHeartbeat(context.Background(), 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		if ctx.Err() != nil {
+			t.Fatalf("task %d was canceled by a server without renew: %v", i, context.Cause(ctx))
+		}
+		stop()
+	}
+	// The first 404 disables heartbeats for the server, including later tasks
+	if n := countRenewals(srv); n != 1 {
+		t.Errorf("sent %d renew requests, want 1", n)
+	}
+}
+
+func TestHeartbeatRevoked(t *testing.T) {
+	srv := aoitest.NewServer()
+	defer srv.Close()
+	c := loadClient(t, srv)
+
+	task := pollTask(t, srv, c)
+	ctx, stop := task.WithHeartbeat(context.Background(), 10*time.Millisecond)
+	defer stop()
+	srv.Revoke(task.TaskId)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("revoked task was not canceled")
+	}
+	if !client.IsTaskRevoked(ctx) {
+		t.Errorf("task canceled with %v, want revoked", context.Cause(ctx))
+	}
+}
@@ -4,31 +4,11 @@ import (
 	"context"
 
 	"github.com/fedstackjs/azukiiro/common"
+	"github.com/go-resty/resty/v2"
 )
 
-type instanceContextKey int
-
-const instanceInjectionKey instanceContextKey = iota
-
-type instanceContext struct {
-	InstanceId string
-	TaskId     string
-}
-
-func WithInstanceTask(ctx context.Context, instanceId string, taskId string) context.Context {
-	ctx = context.WithValue(ctx, instanceInjectionKey, instanceContext{
-		InstanceId: instanceId,
-		TaskId:     taskId,
-	})
-	return ctx
-}
-
-func LoadInstanceTask(ctx context.Context) (string, string) {
-	inj := ctx.Value(instanceInjectionKey).(instanceContext)
-	return inj.InstanceId, inj.TaskId
-}
-
 type PollInstanceRequest struct {
+	RunnerStatus
 }
 
 type PollInstanceResponse struct {
@@ -45,20 +25,26 @@ type PollInstanceResponse struct {
 	ErrMsg          string               `json:"errMsg"`
 }
 
-func PollInstance(ctx context.Context, req *PollInstanceRequest) (*PollInstanceResponse, error) {
+func (c *Client) PollInstance(ctx context.Context, req *PollInstanceRequest) (*PollInstanceResponse, error) {
 	res := &PollInstanceResponse{}
-	raw, err := http.R().
-		SetContext(ctx).
-		SetBody(req).
-		SetResult(res).
-		Post("/api/runner/instance/poll")
-	err = loadError(raw, err)
-	if err != nil {
+	if err := c.sendPoll(ctx, KindInstance, "/api/runner/instance/poll", req, res); err != nil {
 		return nil, err
 	}
 	return res, nil
 }
 
+// InstanceTask is an instance task accepted from the server of its client
+type InstanceTask struct {
+	client     *Client
+	InstanceId string
+	TaskId     string
+}
+
+// InstanceTask returns the handle of an instance task polled from the server
+func (c *Client) InstanceTask(instanceId string, taskId string) *InstanceTask {
+	return &InstanceTask{client: c, InstanceId: instanceId, TaskId: taskId}
+}
+
 type PatchInstanceTaskRequest struct {
 	Message *string `json:"message,omitempty"`
 }
@@ -77,13 +63,14 @@ const (
 	InstanceTaskStateInProgress = 2
 )
 
-func PatchInstanceTask(ctx context.Context, req *PatchInstanceTaskRequest) error {
-	instanceId, taskId := LoadInstanceTask(ctx)
-	raw, err := http.R().
-		SetContext(ctx).
-		SetBody(req).
-		Patch("/api/runner/instance/task/" + instanceId + "/" + taskId)
-	return loadError(raw, err)
+func (t *InstanceTask) Patch(ctx context.Context, req *PatchInstanceTaskRequest) error {
+	return t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetBody(req).
+			SetPathParams(map[string]string{"instanceId": t.InstanceId, "taskId": t.TaskId}).
+			Patch("/api/runner/instance/task/{instanceId}/{taskId}")
+	})
 }
 
 type CompleteTaskRequest struct {
@@ -91,11 +78,12 @@ type CompleteTaskRequest struct {
 	Message   *string `json:"message,omitempty"`
 }
 
-func CompleteTask(ctx context.Context, req *CompleteTaskRequest) error {
-	instanceId, taskId := LoadInstanceTask(ctx)
-	raw, err := http.R().
-		SetContext(ctx).
-		SetBody(req).
-		Post("/api/runner/instance/task/" + instanceId + "/" + taskId + "/complete")
-	return loadError(raw, err)
+func (t *InstanceTask) Complete(ctx context.Context, req *CompleteTaskRequest) error {
+	return t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetBody(req).
+			SetPathParams(map[string]string{"instanceId": t.InstanceId, "taskId": t.TaskId}).
+			Post("/api/runner/instance/task/{instanceId}/{taskId}/complete")
+	})
 }
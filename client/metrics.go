@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/fedstackjs/azukiiro/metrics"
+	"github.com/go-resty/resty/v2"
+)
+
+type endpointContextKey int
+
+const endpointKey endpointContextKey = iota
+
+func endpointOf(req *resty.Request) string {
+	if endpoint, ok := req.Context().Value(endpointKey).(string); ok {
+		return endpoint
+	}
+	return "unknown"
+}
+
+// instrument records the duration and errors of the requests of the client
+func instrument(c *resty.Client) {
+	// Path params are not substituted yet, so the URL identifies the endpoint
+	c.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+		req.SetContext(context.WithValue(req.Context(), endpointKey, req.Method+" "+req.URL))
+		return nil
+	})
+	c.OnAfterResponse(func(_ *resty.Client, res *resty.Response) error {
+		endpoint := endpointOf(res.Request)
+		metrics.APIRequestDuration.WithLabelValues(endpoint).Observe(res.Time().Seconds())
+		if res.IsError() {
+			metrics.APIErrors.WithLabelValues(endpoint, strconv.Itoa(res.StatusCode())).Inc()
+		}
+		return nil
+	})
+	c.OnError(func(req *resty.Request, err error) {
+		if _, ok := err.(*resty.ResponseError); ok {
+			return
+		}
+		metrics.APIErrors.WithLabelValues(endpointOf(req), "network").Inc()
+	})
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of tasks, used to match poll requests with wake-up events
+const (
+	KindSolution = "solution"
+	KindInstance = "instance"
+	KindRanklist = "ranklist"
+)
+
+// longPollHeader is set by servers that held the poll request until a task arrived or the wait passed
+const longPollHeader = "X-AOI-Long-Poll"
+
+type poller struct {
+	// Whether the server held the last poll request
+	held atomic.Bool
+	// Whether the server was ever seen to hold a poll request, to log the capability once
+	supported atomic.Bool
+	wake      chan struct{}
+}
+
+type pollStopContextKey int
+
+const pollStopKey pollStopContextKey = iota
+
+// WithPollStop returns a context whose poll requests are abandoned once stop is done, while tasks
+// returned by them keep running with ctx. It keeps long poll requests from delaying a graceful shutdown.
+func WithPollStop(ctx context.Context, stop context.Context) context.Context {
+	return context.WithValue(ctx, pollStopKey, stop)
+}
+
+// sendPoll posts a poll request for kind, asking the server to hold it if long polling is enabled
+func (c *Client) sendPoll(ctx context.Context, kind string, path string, req any, res any) error {
+	if stop, ok := ctx.Value(pollStopKey).(context.Context); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(stop, cancel)()
+	}
+	api := c.http
+	if c.longPollWait > 0 && c.timeout > 0 {
+		// The server may hold the request for the wait, the timeout covers the rest
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.longPollWait+c.timeout)
+		defer cancel()
+		api = c.poll
+	}
+	r := api.R().
+		SetContext(ctx).
+		SetBody(req).
+		SetResult(res)
+	if c.longPollWait > 0 {
+		r.SetQueryParam("wait", strconv.FormatFloat(c.longPollWait.Seconds(), 'f', -1, 64))
+	}
+	raw, err := r.Post(path)
+	err = loadError(raw, err)
+	if isUnauthorized(err) {
+		// The next poll uses the new key if it was rotated by another process
+		c.reloadCredentials()
+	}
+	p := c.pollers[kind]
+	held := err == nil && c.longPollWait > 0 && raw.Header().Get(longPollHeader) != ""
+	p.held.Store(held)
+	if held && !p.supported.Swap(true) {
+		c.Logger().Infof("Server supports long polling for %s tasks", kind)
+	}
+	return err
+}
+
+// wakePoller interrupts IdleWait of kind, or the next one if no poller is waiting
+func (c *Client) wakePoller(kind string) {
+	p, ok := c.pollers[kind]
+	if !ok {
+		return
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// IdleWait waits before polling kind again after a poll returned no task. It returns at once if the
+// server held the last poll request, otherwise it waits for interval or until a wake-up event of kind
+// arrives. It returns an error only if ctx is done.
+func (c *Client) IdleWait(ctx context.Context, kind string, interval time.Duration) error {
+	p := c.pollers[kind]
+	if p.held.Load() {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.wake:
+		return nil
+	case <-timer.C:
+		return nil
+	}
+}
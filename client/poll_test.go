@@ -0,0 +1,163 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/aoitest"
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/transport"
+	"github.com/spf13/viper"
+)
+
+// setLongPoll enables long polling with wait seconds and rebuilds the transports with the API timeout
+func setLongPoll(t *testing.T, wait float64, timeout float64) {
+	t.Helper()
+	viper.Set("client.longPoll.wait", wait)
+	viper.Set("http.server.timeout", timeout)
+	transport.Init()
+	t.Cleanup(func() {
+		viper.Set("client.longPoll.wait", nil)
+		viper.Set("http.server.timeout", nil)
+		transport.Init()
+	})
+}
+
+func TestLongPollOutlastsTimeout(t *testing.T) {
+	srv := aoitest.NewServer()
+	defer srv.Close()
+	srv.LongPoll = true
+	setLongPoll(t, 2, 0.2)
+	c := loadClient(t, srv)
+
+	// The task is queued after the API timeout but within the wait
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		srv.EnqueueSolution(common.ProblemConfig{}, []byte("problem"), []byte("solution"))
+	}()
+	res, err := c.PollSolution(context.Background(), &client.PollSolutionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TaskId == "" {
+		t.Error("held poll returned no task")
+	}
+}
+
+func TestLongPollSkipsIdleWait(t *testing.T) {
+	srv := aoitest.NewServer()
+	defer srv.Close()
+	srv.LongPoll = true
+	setLongPoll(t, 0.2, 0)
+	c := loadClient(t, srv)
+	ctx := context.Background()
+
+	res, err := c.PollSolution(ctx, &client.PollSolutionRequest{})
+	if err != nil || res.TaskId != "" {
+		t.Fatalf("PollSolution = %+v, %v, want no task", res, err)
+	}
+	if req := srv.Requests()[0]; req.Query != "wait=0.2" {
+		t.Errorf("poll query = %q, want the wait", req.Query)
+	}
+	// The server already waited for tasks, so the runner polls again at once
+	start := time.Now()
+	if err := c.IdleWait(ctx, client.KindSolution, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("IdleWait after a held poll took %v", elapsed)
+	}
+}
+
+func TestIdleWaitWithoutLongPoll(t *testing.T) {
+	srv := aoitest.NewServer()
+	defer srv.Close()
+	setLongPoll(t, 0.2, 0)
+	c := loadClient(t, srv)
+	// The server does not hold the request, so the runner waits for the interval
+	if _, err := c.PollSolution(context.Background(), &client.PollSolutionRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := c.IdleWait(context.Background(), client.KindSolution, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("IdleWait returned after %v, want the interval", elapsed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.IdleWait(ctx, client.KindSolution, 10*time.Second); !errors.Is(err, context.Canceled) {
+		t.Errorf("IdleWait after shutdown = %v, want canceled", err)
+	}
+}
+
+// subscribe starts the event stream of c and waits until the wake-up sent on connecting is consumed
+func subscribe(t *testing.T, srv *aoitest.Server, c *client.Client) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.SubscribeEvents(ctx)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("runner did not subscribe to events")
+		}
+		requests := srv.Requests()
+		if len(requests) > 0 && requests[len(requests)-1].Path == "/api/runner/events" {
+			break
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	c.IdleWait(ctx, client.KindSolution, 10*time.Millisecond)
+}
+
+func TestEventsWakeIdleWait(t *testing.T) {
+	srv := aoitest.NewServer()
+	defer srv.Close()
+	srv.Events = true
+	c := loadClient(t, srv)
+	subscribe(t, srv, c)
+
+	// Events of other kinds do not wake the judge poller
+	srv.EnqueueInstance(common.ProblemConfig{}, []byte("problem"), client.InstanceStateAllocating)
+	start := time.Now()
+	if err := c.IdleWait(context.Background(), client.KindSolution, 300*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("instance event woke the judge poller after %v", elapsed)
+	}
+
+	woken := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		c.IdleWait(context.Background(), client.KindSolution, 10*time.Second)
+		woken <- time.Since(start)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	srv.EnqueueSolution(common.ProblemConfig{}, []byte("problem"), []byte("solution"))
+	select {
+	case <-woken:
+	case <-time.After(5 * time.Second):
+		t.Fatal("solution event did not wake the judge poller")
+	}
+}
+
+func TestEventsUnsupported(t *testing.T) {
+	srv := aoitest.NewServer()
+	defer srv.Close()
+	c := loadClient(t, srv)
+	done := make(chan struct{})
+	go func() {
+		c.SubscribeEvents(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SubscribeEvents kept running without server support")
+	}
+}
@@ -6,30 +6,9 @@ import (
 	"fmt"
 
 	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/go-resty/resty/v2"
 )
 
-type ranklistContextKey int
-
-const injectionKey ranklistContextKey = iota
-
-type ranklistContext struct {
-	TaskId    string
-	ContestId string
-}
-
-func WithRanklistTask(ctx context.Context, taskId, contestId string) context.Context {
-	ctx = context.WithValue(ctx, injectionKey, ranklistContext{
-		TaskId:    taskId,
-		ContestId: contestId,
-	})
-	return ctx
-}
-
-func LoadRanklistCtx(ctx context.Context) (string, string) {
-	inj := ctx.Value(injectionKey).(ranklistContext)
-	return inj.TaskId, inj.ContestId
-}
-
 type RanklistSettings struct {
 	ShowAfter  int    `json:"showAfter"`
 	ShowBefore int    `json:"showBefore"`
@@ -43,6 +22,7 @@ type RanklistDTO struct {
 }
 
 type PollRanklistRequest struct {
+	RunnerStatus
 }
 
 type PollRanklistResponse struct {
@@ -52,20 +32,30 @@ type PollRanklistResponse struct {
 	RanklistUpdatedAt int           `json:"ranklistUpdatedAt"`
 }
 
-func PollRanklist(ctx context.Context, req *PollRanklistRequest) (*PollRanklistResponse, error) {
+func (c *Client) PollRanklist(ctx context.Context, req *PollRanklistRequest) (*PollRanklistResponse, error) {
 	res := &PollRanklistResponse{}
-	raw, err := http.R().
-		SetContext(ctx).
-		SetBody(req).
-		SetResult(res).
-		Post("/api/runner/ranklist/poll")
-	err = loadError(raw, err)
-	if err != nil {
+	if err := c.sendPoll(ctx, KindRanklist, "/api/runner/ranklist/poll", req, res); err != nil {
 		return nil, err
 	}
 	return res, nil
 }
 
+// RanklistTask is a ranklist task accepted from the server of its client
+type RanklistTask struct {
+	client    *Client
+	TaskId    string
+	ContestId string
+}
+
+// RanklistTask returns the handle of a ranklist task polled from the server
+func (c *Client) RanklistTask(taskId string, contestId string) *RanklistTask {
+	return &RanklistTask{client: c, TaskId: taskId, ContestId: contestId}
+}
+
+func (t *RanklistTask) pathParams() map[string]string {
+	return map[string]string{"contestId": t.ContestId, "taskId": t.TaskId}
+}
+
 type RanklistTopstarItemMutation struct {
 	Score float64 `json:"score"`
 	Ts    int     `json:"ts"`
@@ -117,22 +107,23 @@ type GetRanklistUploadUrlsResponse = []struct {
 	Url string `json:"url"`
 }
 
-func GetRanklistUploadUrls(ctx context.Context) (*GetRanklistUploadUrlsResponse, error) {
-	taskId, contestId := LoadRanklistCtx(ctx)
+func (t *RanklistTask) UploadUrls(ctx context.Context) (*GetRanklistUploadUrlsResponse, error) {
 	res := &GetRanklistUploadUrlsResponse{}
-	raw, err := http.R().
-		SetContext(ctx).
-		SetResult(res).
-		Get("/api/runner/ranklist/task/" + contestId + "/" + taskId + "/uploadUrls")
-	err = loadError(raw, err)
+	err := t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetResult(res).
+			SetPathParams(t.pathParams()).
+			Get("/api/runner/ranklist/task/{contestId}/{taskId}/uploadUrls")
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res, nil
 }
 
-func SaveRanklist(ctx context.Context, ranklist map[string]*Ranklist) error {
-	res, err := GetRanklistUploadUrls(ctx)
+func (t *RanklistTask) SaveRanklist(ctx context.Context, ranklist map[string]*Ranklist) error {
+	res, err := t.UploadUrls(ctx)
 	if err != nil {
 		return err
 	}
@@ -157,13 +148,14 @@ type CompleteRanklistTaskRequest struct {
 	RanklistUpdatedAt int `json:"ranklistUpdatedAt"`
 }
 
-func CompleteRanklistTask(ctx context.Context, req *CompleteRanklistTaskRequest) error {
-	taskId, contestId := LoadRanklistCtx(ctx)
-	raw, err := http.R().
-		SetContext(ctx).
-		SetBody(req).
-		Post("/api/runner/ranklist/task/" + contestId + "/" + taskId + "/complete")
-	return loadError(raw, err)
+func (t *RanklistTask) Complete(ctx context.Context, req *CompleteRanklistTaskRequest) error {
+	return t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetBody(req).
+			SetPathParams(t.pathParams()).
+			Post("/api/runner/ranklist/task/{contestId}/{taskId}/complete")
+	})
 }
 
 type GetRanklistSolutionsResponse []struct {
@@ -185,16 +177,17 @@ type GetRanklistSolutionsResponse []struct {
 	CompletedAt      int                `json:"completedAt"`
 }
 
-func GetRanklistSolutions(ctx context.Context, since int, lastId string) (*GetRanklistSolutionsResponse, error) {
-	taskId, contestId := LoadRanklistCtx(ctx)
+func (t *RanklistTask) Solutions(ctx context.Context, since int, lastId string) (*GetRanklistSolutionsResponse, error) {
 	res := &GetRanklistSolutionsResponse{}
-	raw, err := http.R().
-		SetContext(ctx).
-		SetQueryParam("since", fmt.Sprint(since)).
-		SetQueryParam("lastId", lastId).
-		SetResult(res).
-		Get("/api/runner/ranklist/task/" + contestId + "/" + taskId + "/solutions")
-	err = loadError(raw, err)
+	err := t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetQueryParam("since", fmt.Sprint(since)).
+			SetQueryParam("lastId", lastId).
+			SetResult(res).
+			SetPathParams(t.pathParams()).
+			Get("/api/runner/ranklist/task/{contestId}/{taskId}/solutions")
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -218,16 +211,17 @@ type GetRanklistParticipantsResponse []struct {
 	UpdatedAt int `json:"updatedAt" bson:"updatedAt"`
 }
 
-func GetRanklistParticipants(ctx context.Context, since int, lastId string) (*GetRanklistParticipantsResponse, error) {
-	taskId, contestId := LoadRanklistCtx(ctx)
+func (t *RanklistTask) Participants(ctx context.Context, since int, lastId string) (*GetRanklistParticipantsResponse, error) {
 	res := &GetRanklistParticipantsResponse{}
-	raw, err := http.R().
-		SetContext(ctx).
-		SetQueryParam("since", fmt.Sprint(since)).
-		SetQueryParam("lastId", lastId).
-		SetResult(res).
-		Get("/api/runner/ranklist/task/" + contestId + "/" + taskId + "/participants")
-	err = loadError(raw, err)
+	err := t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetQueryParam("since", fmt.Sprint(since)).
+			SetQueryParam("lastId", lastId).
+			SetResult(res).
+			SetPathParams(t.pathParams()).
+			Get("/api/runner/ranklist/task/{contestId}/{taskId}/participants")
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -246,14 +240,15 @@ type GetRanklistProblemsResponse []struct {
 	} `json:"settings"`
 }
 
-func GetRanklistProblems(ctx context.Context) (*GetRanklistProblemsResponse, error) {
-	taskId, contestId := LoadRanklistCtx(ctx)
+func (t *RanklistTask) Problems(ctx context.Context) (*GetRanklistProblemsResponse, error) {
 	res := &GetRanklistProblemsResponse{}
-	raw, err := http.R().
-		SetContext(ctx).
-		SetResult(res).
-		Get("/api/runner/ranklist/task/" + contestId + "/" + taskId + "/problems")
-	err = loadError(raw, err)
+	err := t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetResult(res).
+			SetPathParams(t.pathParams()).
+			Get("/api/runner/ranklist/task/{contestId}/{taskId}/problems")
+	})
 	if err != nil {
 		return nil, err
 	}
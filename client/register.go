@@ -14,9 +14,10 @@ type RegisterResponse struct {
 	RunnerKey string `json:"runnerKey"`
 }
 
-func Register(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
+// Register registers the runner to the server, the client needs no credentials
+func (c *Client) Register(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
 	res := &RegisterResponse{}
-	raw, err := http.R().
+	raw, err := c.http.R().
 		SetContext(ctx).
 		SetBody(req).
 		SetResult(res).
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+)
+
+type RetryPolicy struct {
+	// Number of attempts including the first one
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func loadRetryPolicy() RetryPolicy {
+	retryPolicy := RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    10 * time.Second,
+	}
+	if viper.IsSet("client.retry.maxAttempts") {
+		retryPolicy.MaxAttempts = max(viper.GetInt("client.retry.maxAttempts"), 1)
+	}
+	if viper.IsSet("client.retry.baseDelay") {
+		retryPolicy.BaseDelay = time.Duration(viper.GetFloat64("client.retry.baseDelay") * float64(time.Second))
+	}
+	if viper.IsSet("client.retry.maxDelay") {
+		retryPolicy.MaxDelay = time.Duration(viper.GetFloat64("client.retry.maxDelay") * float64(time.Second))
+	}
+	return retryPolicy
+}
+
+// backoff returns the jittered delay before the given retry, starting from 1
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.MaxDelay
+	if shift := retry - 1; shift < 32 {
+		delay = min(p.BaseDelay<<shift, p.MaxDelay)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Keep at least half of the delay so retries from many runners spread out without bunching at zero
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retry sends an idempotent request until it succeeds, fails permanently or the attempts run out.
+// Do not use it for requests that change server state on every call, such as polling.
+func (c *Client) retry(ctx context.Context, send func() (*resty.Response, error)) error {
+	for attempt := 1; ; attempt++ {
+		err := loadError(send())
+		if isUnauthorized(err) && attempt < c.retryPolicy.MaxAttempts && c.reloadCredentials() {
+			continue
+		}
+		if err == nil || attempt >= c.retryPolicy.MaxAttempts || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		delay := c.retryPolicy.backoff(attempt)
+		// Honor the server asking for a longer delay, but not beyond the configured maximum
+		if apiErr, ok := err.(*APIError); ok && apiErr.RetryAfter > delay {
+			delay = min(apiErr.RetryAfter, max(c.retryPolicy.MaxDelay, delay))
+		}
+		logging.FromContext(ctx).Warnf("Request failed, retrying in %v: %v", delay.Round(time.Millisecond), err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
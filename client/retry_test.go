@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"errors"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for retry, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		40: time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if delay := p.backoff(retry); delay < want/2 || delay > want {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", retry, delay, want/2, want)
+			}
+		}
+	}
+	if delay := (&RetryPolicy{}).backoff(1); delay != 0 {
+		t.Errorf("backoff without delays = %v, want 0", delay)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	c := &Client{retryPolicy: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}}
+	for _, tc := range []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"success", nil, 1},
+		{"network error", errors.New("connection refused"), 3},
+		{"server error", &APIError{StatusCode: nethttp.StatusBadGateway}, 3},
+		{"too many requests", &APIError{StatusCode: nethttp.StatusTooManyRequests}, 3},
+		{"client error", &APIError{StatusCode: nethttp.StatusBadRequest}, 1},
+		{"canceled", context.Canceled, 1},
+	} {
+		attempts := 0
+		err := c.retry(context.Background(), func() (*resty.Response, error) {
+			attempts++
+			if tc.err == nil {
+				return &resty.Response{RawResponse: &nethttp.Response{StatusCode: nethttp.StatusOK}}, nil
+			}
+			return nil, tc.err
+		})
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.err)
+		}
+		if attempts != tc.attempts {
+			t.Errorf("%s: %d attempts, want %d", tc.name, attempts, tc.attempts)
+		}
+	}
+}
+
+func TestRetryAfterClamped(t *testing.T) {
+	c := &Client{retryPolicy: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}}
+	start := time.Now()
+	c.retry(context.Background(), func() (*resty.Response, error) {
+		return nil, &APIError{StatusCode: nethttp.StatusServiceUnavailable, RetryAfter: time.Hour}
+	})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("retried after %v, want at most the maximum delay", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("3"); d != 3*time.Second {
+		t.Errorf("parseRetryAfter(3) = %v", d)
+	}
+	for _, value := range []string{"", "-1", "soon"} {
+		if d := parseRetryAfter(value); d != 0 {
+			t.Errorf("parseRetryAfter(%q) = %v, want 0", value, d)
+		}
+	}
+	at := time.Now().Add(time.Minute).UTC().Format(nethttp.TimeFormat)
+	if d := parseRetryAfter(at); d <= 0 || d > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %v", at, d)
+	}
+}
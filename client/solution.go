@@ -6,29 +6,11 @@ import (
 
 	"github.com/fedstackjs/azukiiro/common"
 	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/go-resty/resty/v2"
 )
 
-// Context helpers
-type taskContextKey int
-
-const (
-	solutionIdKey taskContextKey = iota
-	taskIdKey     taskContextKey = iota
-)
-
-func WithSolutionTask(ctx context.Context, solutionId string, taskId string) context.Context {
-	ctx = context.WithValue(ctx, solutionIdKey, solutionId)
-	ctx = context.WithValue(ctx, taskIdKey, taskId)
-	return ctx
-}
-
-func LoadSolutionTask(ctx context.Context) (solutionId string, taskId string) {
-	solutionId = ctx.Value(solutionIdKey).(string)
-	taskId = ctx.Value(taskIdKey).(string)
-	return
-}
-
 type PollSolutionRequest struct {
+	RunnerStatus
 }
 
 type PollSolutionResponse struct {
@@ -44,22 +26,39 @@ type PollSolutionResponse struct {
 	ErrMsg           string               `json:"errMsg"`
 }
 
-func PollSolution(ctx context.Context, req *PollSolutionRequest) (*PollSolutionResponse, error) {
+func (c *Client) PollSolution(ctx context.Context, req *PollSolutionRequest) (*PollSolutionResponse, error) {
 	res := &PollSolutionResponse{}
-	raw, err := http.R().
-		SetContext(ctx).
-		SetBody(req).
-		SetResult(res).
-		Post("/api/runner/solution/poll")
-	err = loadError(raw, err)
-	if err != nil {
+	if err := c.sendPoll(ctx, KindSolution, "/api/runner/solution/poll", req, res); err != nil {
 		return nil, err
 	}
 	return res, nil
 }
 
-func SaveSolutionDetails(ctx context.Context, details *common.SolutionDetails) error {
-	url, err := GetSolutionTaskDetailsUrl(ctx, "upload")
+// SolutionTask is a solution task accepted from the server of its client
+type SolutionTask struct {
+	client     *Client
+	SolutionId string
+	TaskId     string
+	// Stops the heartbeat started by WithHeartbeat, if any
+	heartbeat context.CancelFunc
+}
+
+// SolutionTask returns the handle of a solution task polled from the server
+func (c *Client) SolutionTask(solutionId string, taskId string) *SolutionTask {
+	return &SolutionTask{client: c, SolutionId: solutionId, TaskId: taskId}
+}
+
+// Server returns the name of the server the task was polled from, empty for the default server
+func (t *SolutionTask) Server() string {
+	return t.client.name
+}
+
+func (t *SolutionTask) pathParams() map[string]string {
+	return map[string]string{"solutionId": t.SolutionId, "taskId": t.TaskId}
+}
+
+func (t *SolutionTask) SaveDetails(ctx context.Context, details *common.SolutionDetails) error {
+	url, err := t.DetailsUrl(ctx, "upload")
 	if err != nil {
 		return err
 	}
@@ -70,37 +69,84 @@ func SaveSolutionDetails(ctx context.Context, details *common.SolutionDetails) e
 	return storage.Upload(ctx, url, str)
 }
 
-func PatchSolutionTask(ctx context.Context, req *common.SolutionInfo) error {
-	solutionId, taskId := LoadSolutionTask(ctx)
-	raw, err := http.R().
-		SetContext(ctx).
-		SetBody(req).
-		Patch("/api/runner/solution/task/" + solutionId + "/" + taskId)
-	return loadError(raw, err)
+func (t *SolutionTask) Patch(ctx context.Context, req *common.SolutionInfo) error {
+	return t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetBody(req).
+			SetPathParams(t.pathParams()).
+			Patch("/api/runner/solution/task/{solutionId}/{taskId}")
+	})
+}
+
+func (t *SolutionTask) Complete(ctx context.Context) error {
+	t.stopHeartbeat()
+	return t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetPathParams(t.pathParams()).
+			Post("/api/runner/solution/task/{solutionId}/{taskId}/complete")
+	})
 }
 
-func CompleteSolutionTask(ctx context.Context) error {
-	solutionId, taskId := LoadSolutionTask(ctx)
-	raw, err := http.R().
-		SetContext(ctx).
-		Post("/api/runner/solution/task/" + solutionId + "/" + taskId + "/complete")
-	return loadError(raw, err)
+func (t *SolutionTask) Renew(ctx context.Context) error {
+	return t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetPathParams(t.pathParams()).
+			Post("/api/runner/solution/task/{solutionId}/{taskId}/renew")
+	})
 }
 
 type UrlResponse struct {
 	Url string `json:"url"`
 }
 
-func GetSolutionTaskDetailsUrl(ctx context.Context, urlType string) (string, error) {
-	solutionId, taskId := LoadSolutionTask(ctx)
+func (t *SolutionTask) DetailsUrl(ctx context.Context, urlType string) (string, error) {
+	res := &UrlResponse{}
+	err := t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetResult(res).
+			SetPathParams(t.pathParams()).
+			SetPathParam("urlType", urlType).
+			Get("/api/runner/solution/task/{solutionId}/{taskId}/details/{urlType}")
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.Url, nil
+}
+
+// ArtifactUrl returns the URL to upload the named artifact of the task to
+func (t *SolutionTask) ArtifactUrl(ctx context.Context, name string) (string, error) {
 	res := &UrlResponse{}
-	raw, err := http.R().
-		SetContext(ctx).
-		SetResult(res).
-		Get("/api/runner/solution/task/" + solutionId + "/" + taskId + "/details/" + urlType)
-	err = loadError(raw, err)
+	err := t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetResult(res).
+			SetPathParams(t.pathParams()).
+			SetPathParam("name", name).
+			Get("/api/runner/solution/task/{solutionId}/{taskId}/artifact/{name}/upload")
+	})
 	if err != nil {
 		return "", err
 	}
 	return res.Url, nil
 }
+
+type ReleaseSolutionTaskRequest struct {
+	Message string `json:"message"`
+}
+
+// Release hands an unfinished task back to the server so it can be reassigned
+func (t *SolutionTask) Release(ctx context.Context, req *ReleaseSolutionTaskRequest) error {
+	t.stopHeartbeat()
+	return t.client.retry(ctx, func() (*resty.Response, error) {
+		return t.client.http.R().
+			SetContext(ctx).
+			SetBody(req).
+			SetPathParams(t.pathParams()).
+			Post("/api/runner/solution/task/{solutionId}/{taskId}/release")
+	})
+}
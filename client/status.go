@@ -0,0 +1,25 @@
+package client
+
+// RunnerStatus is sent with every poll request so the server can route tasks to suitable runners
+type RunnerStatus struct {
+	Version string `json:"version"`
+	// Names of the adapters built into the runner
+	Adapters []string `json:"adapters"`
+	// Number of tasks the runner can start right now
+	FreeSlots int `json:"freeSlots"`
+	// Adapters and problem labels at their concurrency limit, the runner hands back their tasks if it can
+	SaturatedAdapters []string `json:"saturatedAdapters,omitempty"`
+	SaturatedLabels   []string `json:"saturatedLabels,omitempty"`
+	// Hashes of cached files, most recently used first
+	CachedHashes []string  `json:"cachedHashes,omitempty"`
+	Load         *HostLoad `json:"load,omitempty"`
+}
+
+// HostLoad describes the load of the host, values that cannot be read on the platform are omitted
+type HostLoad struct {
+	CPUs int `json:"cpus"`
+	// Load average over the last minute
+	Load1        float64 `json:"load1,omitempty"`
+	MemAvailable int64   `json:"memAvailable,omitempty"`
+	DiskFree     int64   `json:"diskFree,omitempty"`
+}
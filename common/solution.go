@@ -6,6 +6,8 @@ type SolutionDetailsTest struct {
 	ScoreScale float64 `json:"scoreScale"`
 	Status     string  `json:"status"`
 	Summary    string  `json:"summary"`
+	// Names of the artifacts of the test
+	Artifacts []string `json:"artifacts,omitempty"`
 }
 
 type SolutionDetailsJob struct {
@@ -15,12 +17,23 @@ type SolutionDetailsJob struct {
 	Status     string                 `json:"status"`
 	Tests      []*SolutionDetailsTest `json:"tests"`
 	Summary    string                 `json:"summary"`
+	// Names of the artifacts of the job
+	Artifacts []string `json:"artifacts,omitempty"`
+}
+
+// SolutionDetailsArtifact describes a file uploaded with the details, such as a compiler log
+type SolutionDetailsArtifact struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Hex encoded sha256 hash of the content
+	Hash string `json:"hash"`
 }
 
 type SolutionDetails struct {
-	Version int                   `json:"version"`
-	Jobs    []*SolutionDetailsJob `json:"jobs"`
-	Summary string                `json:"summary"`
+	Version   int                        `json:"version"`
+	Jobs      []*SolutionDetailsJob      `json:"jobs"`
+	Summary   string                     `json:"summary"`
+	Artifacts []*SolutionDetailsArtifact `json:"artifacts,omitempty"`
 }
 
 type SolutionInfo struct {
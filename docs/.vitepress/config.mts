@@ -28,7 +28,8 @@ export default defineConfig({
           { text: 'Dummy', link: '/adapters/dummy' },
           { text: 'UOJ', link: '/adapters/uoj' },
           { text: 'Glue', link: '/adapters/glue' },
-          { text: 'VJudge', link: '/adapters/vjudge' }
+          { text: 'VJudge', link: '/adapters/vjudge' },
+          { text: 'Native', link: '/adapters/native' }
         ]
       }
     ],
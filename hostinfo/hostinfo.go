@@ -0,0 +1,51 @@
+// Package hostinfo collects the runner status reported to the server when polling for tasks
+package hostinfo
+
+import (
+	"runtime"
+	"sort"
+
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/spf13/viper"
+)
+
+// cachedHashLimit returns the maximum number of cached hashes reported, 0 disables reporting
+func cachedHashLimit() int {
+	if viper.IsSet("client.report.cachedHashes") {
+		return max(viper.GetInt("client.report.cachedHashes"), 0)
+	}
+	return 256
+}
+
+// Load returns the load of the host, leaving out values that are unavailable
+func Load() *client.HostLoad {
+	load := &client.HostLoad{CPUs: runtime.NumCPU()}
+	if avg, err := loadAverage(); err == nil {
+		load.Load1 = avg
+	}
+	if mem, err := memAvailable(); err == nil {
+		load.MemAvailable = mem
+	}
+	if free, err := storage.GetFreeSpace(); err == nil {
+		load.DiskFree = free
+	}
+	return load
+}
+
+// Status returns the status sent with poll requests of a role with the given adapters and free slots
+func Status(adapters []string, freeSlots int) client.RunnerStatus {
+	adapters = append([]string{}, adapters...)
+	sort.Strings(adapters)
+	status := client.RunnerStatus{
+		Version:   common.GetVersion(),
+		Adapters:  adapters,
+		FreeSlots: freeSlots,
+		Load:      Load(),
+	}
+	if limit := cachedHashLimit(); limit > 0 {
+		status.CachedHashes = storage.RecentHashes(limit)
+	}
+	return status
+}
@@ -0,0 +1,47 @@
+package hostinfo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/spf13/viper"
+)
+
+func TestStatus(t *testing.T) {
+	viper.Set("storagePath", t.TempDir())
+	storage.Initialize()
+	source := filepath.Join(t.TempDir(), "problem.zip")
+	if err := os.WriteFile(source, []byte("problem"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, err := storage.FetchLocal(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adapters := []string{"uoj", "native"}
+	status := Status(adapters, 3)
+	if !slices.Equal(status.Adapters, []string{"native", "uoj"}) || adapters[0] != "uoj" {
+		t.Errorf("adapters = %v, want a sorted copy", status.Adapters)
+	}
+	if status.FreeSlots != 3 || status.Version == "" {
+		t.Errorf("status = %+v", status)
+	}
+	if status.Load == nil || status.Load.CPUs != runtime.NumCPU() {
+		t.Errorf("load = %+v, want the CPUs of the host", status.Load)
+	}
+	if !slices.Contains(status.CachedHashes, filepath.Base(path)) {
+		t.Errorf("cached hashes %v do not contain the cached file", status.CachedHashes)
+	}
+
+	viper.Set("client.report.cachedHashes", 0)
+	defer viper.Set("client.report.cachedHashes", nil)
+	if status := Status(nil, 0); status.CachedHashes != nil {
+		t.Errorf("cached hashes reported although disabled: %v", status.CachedHashes)
+	}
+}
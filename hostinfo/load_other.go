@@ -0,0 +1,13 @@
+//go:build !linux
+
+package hostinfo
+
+import "errors"
+
+func loadAverage() (float64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func memAvailable() (int64, error) {
+	return 0, errors.ErrUnsupported
+}
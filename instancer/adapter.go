@@ -55,7 +55,7 @@ type RemoteInstanceTask struct {
 	taskType    TaskType
 	config      common.ProblemConfig
 	problemData string
-	instanceId  string
+	remote      *client.InstanceTask
 }
 
 func (t *RemoteInstanceTask) Type() TaskType {
@@ -71,13 +71,13 @@ func (t *RemoteInstanceTask) ProblemData() string {
 }
 
 func (t *RemoteInstanceTask) InstanceId() string {
-	return t.instanceId
+	return t.remote.InstanceId
 }
 
 func (t *RemoteInstanceTask) Patch(ctx context.Context, patch *client.PatchInstanceTaskRequest) error {
-	return client.PatchInstanceTask(ctx, patch)
+	return t.remote.Patch(ctx, patch)
 }
 
 func (t *RemoteInstanceTask) Complete(ctx context.Context, req *client.CompleteTaskRequest) error {
-	return client.CompleteTask(ctx, req)
+	return t.remote.Complete(ctx, req)
 }
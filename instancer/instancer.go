@@ -5,27 +5,57 @@ import (
 	"fmt"
 
 	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/hostinfo"
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/fedstackjs/azukiiro/metrics"
+	"github.com/fedstackjs/azukiiro/registry"
 	"github.com/fedstackjs/azukiiro/storage"
 	"github.com/fedstackjs/azukiiro/utils"
 	"github.com/sirupsen/logrus"
 )
 
-func startInstance(ctx context.Context, res *client.PollInstanceResponse) error {
+// Tasks tracks the instance tasks accepted by this runner
+var Tasks = registry.New("instancer")
+
+func instanceLabel(res *client.PollInstanceResponse) string {
+	if res.ProblemConfig.InstanceLabel == nil {
+		return ""
+	}
+	return *res.ProblemConfig.InstanceLabel
+}
+
+func recordOutcome(action string, res *client.PollInstanceResponse, succeeded bool) {
+	label := instanceLabel(res)
+	result := "success"
+	if !succeeded {
+		result = "failure"
+	}
+	metrics.InstanceOutcomes.WithLabelValues(action, label, result).Inc()
+	if succeeded {
+		metrics.TasksCompleted.WithLabelValues("instancer", label).Inc()
+	} else {
+		metrics.TasksErrored.WithLabelValues("instancer", label).Inc()
+	}
+}
+
+func startInstance(ctx context.Context, remote *client.InstanceTask, res *client.PollInstanceResponse) error {
 	message := "Starting instance\n"
-	client.PatchInstanceTask(ctx, &client.PatchInstanceTaskRequest{
+	remote.Patch(ctx, &client.PatchInstanceTaskRequest{
 		Message: &message,
 	})
 
 	updateMessage := func() {
 		message += " ✅\n"
-		client.PatchInstanceTask(ctx, &client.PatchInstanceTaskRequest{
+		remote.Patch(ctx, &client.PatchInstanceTaskRequest{
 			Message: &message,
 		})
 	}
 
 	updateError := func(err error) error {
+		recordOutcome("start", res, false)
 		message += fmt.Sprintf(" ❌\n\nError:\n\n```%s```\n", err)
-		client.CompleteTask(ctx, &client.CompleteTaskRequest{
+		// Report the failure even if the task was cancelled
+		remote.Complete(context.WithoutCancel(ctx), &client.CompleteTaskRequest{
 			Succeeded: false,
 			Message:   &message,
 		})
@@ -33,9 +63,9 @@ func startInstance(ctx context.Context, res *client.PollInstanceResponse) error
 	}
 
 	message += "- Prepare problem data"
-	problemData, err := storage.PrepareFile(ctx, res.ProblemDataUrl, res.ProblemDataHash)
+	problemData, err := storage.Fetch(ctx, res.ProblemDataUrl, res.ProblemDataHash)
 	if err != nil {
-		logrus.Infof("Failed to prepare problem data: %v", err)
+		logging.FromContext(ctx).Infof("Failed to prepare problem data: %v", err)
 		return updateError(err)
 	}
 	updateMessage()
@@ -54,37 +84,40 @@ func startInstance(ctx context.Context, res *client.PollInstanceResponse) error
 		taskType:    TaskTypeStart,
 		config:      res.ProblemConfig,
 		problemData: problemData,
-		instanceId:  res.InstanceId,
+		remote:      remote,
 	}
 
 	err = adapter.StartInstance(ctx, task)
 	if err != nil {
 		return updateError(err)
 	}
+	recordOutcome("start", res, true)
 
-	client.CompleteTask(ctx, &client.CompleteTaskRequest{
+	remote.Complete(ctx, &client.CompleteTaskRequest{
 		Succeeded: true,
 		Message:   utils.ToPtr(fmt.Sprintf("%s✅\nInstance started successfully\n", message)),
 	})
 	return nil
 }
 
-func destroyInstance(ctx context.Context, res *client.PollInstanceResponse) error {
+func destroyInstance(ctx context.Context, remote *client.InstanceTask, res *client.PollInstanceResponse) error {
 	message := "Destroying instance\n"
-	client.PatchInstanceTask(ctx, &client.PatchInstanceTaskRequest{
+	remote.Patch(ctx, &client.PatchInstanceTaskRequest{
 		Message: &message,
 	})
 
 	updateMessage := func() {
 		message += " ✅\n"
-		client.PatchInstanceTask(ctx, &client.PatchInstanceTaskRequest{
+		remote.Patch(ctx, &client.PatchInstanceTaskRequest{
 			Message: &message,
 		})
 	}
 
 	updateError := func(err error) error {
+		recordOutcome("destroy", res, false)
 		message += fmt.Sprintf(" ❌\n\nError:\n\n```%s```\n", err)
-		client.CompleteTask(ctx, &client.CompleteTaskRequest{
+		// Report the failure even if the task was cancelled
+		remote.Complete(context.WithoutCancel(ctx), &client.CompleteTaskRequest{
 			Succeeded: false,
 			Message:   &message,
 		})
@@ -105,31 +138,34 @@ func destroyInstance(ctx context.Context, res *client.PollInstanceResponse) erro
 		taskType:    TaskTypeDestroy,
 		config:      res.ProblemConfig,
 		problemData: "",
-		instanceId:  res.InstanceId,
+		remote:      remote,
 	}
 
 	err := adapter.DestroyInstance(ctx, task)
 	if err != nil {
 		return updateError(err)
 	}
+	recordOutcome("destroy", res, true)
 
-	client.CompleteTask(ctx, &client.CompleteTaskRequest{
+	remote.Complete(ctx, &client.CompleteTaskRequest{
 		Succeeded: true,
 		Message:   utils.ToPtr(fmt.Sprintf("%s✅\nInstance destroyed successfully\n", message)),
 	})
 	return nil
 }
 
-func handlePollError(ctx context.Context, err string) error {
-	client.CompleteTask(ctx, &client.CompleteTaskRequest{
+func handlePollError(ctx context.Context, remote *client.InstanceTask, err string) error {
+	remote.Complete(ctx, &client.CompleteTaskRequest{
 		Succeeded: false,
 		Message:   utils.ToPtr("Server side error occurred"),
 	})
 	return nil
 }
 
-func Poll(ctx context.Context) (bool, error) {
-	res, err := client.PollInstance(ctx, &client.PollInstanceRequest{})
+func Poll(ctx context.Context, c *client.Client) (bool, error) {
+	res, err := c.PollInstance(ctx, &client.PollInstanceRequest{
+		RunnerStatus: hostinfo.Status(GetAdapterNames(), 1),
+	})
 	if err != nil {
 		return false, err
 	}
@@ -137,31 +173,43 @@ func Poll(ctx context.Context) (bool, error) {
 		return false, nil
 	}
 
-	ctx = client.WithInstanceTask(ctx, res.InstanceId, res.TaskId)
+	remote := c.InstanceTask(res.InstanceId, res.TaskId)
+	ctx = logging.WithTask(ctx, c.TaskFields(logrus.Fields{
+		"taskId":     res.TaskId,
+		"instanceId": res.InstanceId,
+		"adapter":    instanceLabel(res),
+	}))
 
 	if res.ErrMsg != "" {
-		return true, handlePollError(ctx, res.ErrMsg)
+		return true, handlePollError(ctx, remote, res.ErrMsg)
 	}
 
-	logrus.Println("Got task   :", res.TaskId)
-	logrus.Println("- ProblemId:", res.ProblemId)
-	logrus.Println("- Hash     :", res.ProblemDataHash)
-	logrus.Println("- Label    :", res.ProblemConfig.InstanceLabel)
-	logrus.Println("- State    :", res.State)
+	metrics.TasksPolled.WithLabelValues("instancer", instanceLabel(res)).Inc()
+	ctx, unregister := Tasks.Add(ctx, c.Name(), res.TaskId, res.InstanceId, instanceLabel(res))
+	defer unregister()
+	ctx, unpin := storage.WithTask(ctx)
+	defer unpin()
+
+	logging.FromContext(ctx).Println("Got task   :", res.TaskId)
+	logging.FromContext(ctx).Println("- ProblemId:", res.ProblemId)
+	logging.FromContext(ctx).Println("- Hash     :", res.ProblemDataHash)
+	logging.FromContext(ctx).Println("- Label    :", res.ProblemConfig.InstanceLabel)
+	logging.FromContext(ctx).Println("- State    :", res.State)
 
 	var actionErr error
+	Tasks.SetState(c.Name(), res.TaskId, "running")
 	switch res.State {
 	case client.InstanceStateAllocating:
-		actionErr = startInstance(ctx, res)
+		actionErr = startInstance(ctx, remote, res)
 	case client.InstanceStateDestroying:
-		actionErr = destroyInstance(ctx, res)
+		actionErr = destroyInstance(ctx, remote, res)
 	default:
 		actionErr = fmt.Errorf("unexpected instance state: %d", res.State)
 	}
 
 	if actionErr != nil {
-		logrus.Printf("Failed to handle instance task: %v", actionErr)
-		client.CompleteTask(ctx, &client.CompleteTaskRequest{
+		logging.FromContext(ctx).Printf("Failed to handle instance task: %v", actionErr)
+		remote.Complete(ctx, &client.CompleteTaskRequest{
 			Succeeded: false,
 			Message:   utils.ToPtr(fmt.Sprintf("Task error:\n```%s```", actionErr)),
 		})
@@ -0,0 +1,151 @@
+package instancer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fedstackjs/azukiiro/aoitest"
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/spf13/viper"
+)
+
+// testAdapter records the instances it started and destroyed
+type testAdapter struct {
+	mu        sync.Mutex
+	started   map[string]string
+	destroyed []string
+}
+
+func (a *testAdapter) Name() string {
+	return "test"
+}
+
+func (a *testAdapter) StartInstance(ctx context.Context, task InstanceTask) error {
+	if task.Type() != TaskTypeStart {
+		return errors.New("unexpected task type")
+	}
+	data, err := os.ReadFile(task.ProblemData())
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.started[task.InstanceId()] = string(data)
+	return nil
+}
+
+func (a *testAdapter) DestroyInstance(ctx context.Context, task InstanceTask) error {
+	if task.Type() != TaskTypeDestroy {
+		return errors.New("unexpected task type")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.destroyed = append(a.destroyed, task.InstanceId())
+	return nil
+}
+
+var adapter = &testAdapter{started: make(map[string]string)}
+
+func init() {
+	RegisterAdapter(adapter)
+}
+
+func setupServer(t *testing.T) (*aoitest.Server, *client.Client) {
+	t.Helper()
+	viper.Set("storagePath", t.TempDir())
+	storage.Initialize()
+	srv := aoitest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.Configure()
+	c, err := client.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, c
+}
+
+func instanceConfig(label string) common.ProblemConfig {
+	return common.ProblemConfig{
+		InstanceLabel: &label,
+		Instance:      &common.ProblemConfigInstance{Adapter: label},
+	}
+}
+
+func poll(t *testing.T, c *client.Client) {
+	t.Helper()
+	if polled, err := Poll(context.Background(), c); !polled || err != nil {
+		t.Fatalf("Poll = %v, %v", polled, err)
+	}
+}
+
+func TestPollStartAndDestroy(t *testing.T) {
+	srv, c := setupServer(t)
+	// The adapter is registered once for all runs of the test
+	adapter.mu.Lock()
+	adapter.destroyed = nil
+	adapter.mu.Unlock()
+	if polled, err := Poll(context.Background(), c); polled || err != nil {
+		t.Fatalf("Poll without tasks = %v, %v", polled, err)
+	}
+
+	start := srv.EnqueueInstance(instanceConfig("test"), []byte("problem"), client.InstanceStateAllocating)
+	poll(t, c)
+	if res := start.Result(); res == nil || !res.Succeeded {
+		t.Fatalf("start result = %+v, want success", res)
+	}
+	adapter.mu.Lock()
+	data := adapter.started[start.InstanceId]
+	adapter.mu.Unlock()
+	if data != "problem" {
+		t.Errorf("instance started with problem data %q", data)
+	}
+	if len(start.Messages()) == 0 {
+		t.Error("no progress was reported")
+	}
+
+	destroy := srv.EnqueueInstanceResponse(client.PollInstanceResponse{
+		TaskId:        "destroy",
+		InstanceId:    start.InstanceId,
+		State:         client.InstanceStateDestroying,
+		ProblemConfig: instanceConfig("test"),
+	})
+	poll(t, c)
+	if res := destroy.Result(); res == nil || !res.Succeeded {
+		t.Fatalf("destroy result = %+v, want success", res)
+	}
+	adapter.mu.Lock()
+	defer adapter.mu.Unlock()
+	if len(adapter.destroyed) != 1 || adapter.destroyed[0] != start.InstanceId {
+		t.Errorf("destroyed %q, want %s", adapter.destroyed, start.InstanceId)
+	}
+}
+
+func TestPollFailures(t *testing.T) {
+	srv, c := setupServer(t)
+	for _, tc := range []struct {
+		name   string
+		task   *aoitest.InstanceTask
+		reason string
+	}{
+		{"unknown adapter", srv.EnqueueInstance(instanceConfig("missing"), []byte("problem"), client.InstanceStateAllocating), "adapter not found"},
+		{"not configured", srv.EnqueueInstance(common.ProblemConfig{}, []byte("problem"), client.InstanceStateAllocating), "instance not configured"},
+		{"unexpected state", srv.EnqueueInstance(instanceConfig("test"), []byte("problem"), client.InstanceStateAllocated), "unexpected instance state"},
+		{"server error", srv.EnqueueInstanceResponse(client.PollInstanceResponse{TaskId: "error", InstanceId: "instance", ErrMsg: "broken"}), "Server side error"},
+	} {
+		poll(t, c)
+		res := tc.task.Result()
+		if res == nil || res.Succeeded {
+			t.Errorf("%s: result = %+v, want failure", tc.name, res)
+			continue
+		}
+		if res.Message == nil || !strings.Contains(*res.Message, tc.reason) {
+			t.Errorf("%s: message does not contain %q", tc.name, tc.reason)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package judge
 
 import (
 	"context"
+	"io"
+	"sync"
 
 	"github.com/fedstackjs/azukiiro/client"
 	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/storage"
 )
 
 type JudgeTask interface {
@@ -14,6 +17,9 @@ type JudgeTask interface {
 	SolutionData() string
 	Update(ctx context.Context, update *common.SolutionInfo) error
 	UploadDetails(ctx context.Context, details *common.SolutionDetails) error
+	// UploadArtifact uploads content as the named artifact of the solution,
+	// the returned description can be added to the details to reference it
+	UploadArtifact(ctx context.Context, name string, content io.Reader) (*common.SolutionDetailsArtifact, error)
 }
 
 type JudgeAdapter interface {
@@ -47,9 +53,35 @@ type RemoteJudgeTask struct {
 	config       common.ProblemConfig
 	problemData  string
 	solutionData string
-	solutionId   string
-	taskId       string
+	remote       *client.SolutionTask
 	env          map[string]string
+	ctx          context.Context
+	stop         context.CancelFunc
+	unregister   func()
+	unpin        func()
+	poll         *client.PollSolutionResponse
+
+	// Slot reserved with Limiter.Reserve if not nil, and whether capacity to run the task was acquired
+	limiter  *Limiter
+	acquired bool
+
+	mu     sync.Mutex
+	status string
+}
+
+// finish stops the heartbeat, removes the task from the registry and releases the files and capacity held by the task
+func (t *RemoteJudgeTask) finish() {
+	t.unregister()
+	t.unpin()
+	t.stop()
+	if t.limiter != nil {
+		if t.acquired {
+			t.limiter.Release(&t.config)
+			t.acquired = false
+		}
+		t.limiter.Unreserve()
+		t.limiter = nil
+	}
 }
 
 func (t *RemoteJudgeTask) Config() common.ProblemConfig {
@@ -69,9 +101,35 @@ func (t *RemoteJudgeTask) SolutionData() string {
 }
 
 func (t *RemoteJudgeTask) Update(ctx context.Context, update *common.SolutionInfo) error {
-	return client.PatchSolutionTask(ctx, update)
+	t.mu.Lock()
+	t.status = update.Status
+	t.mu.Unlock()
+	return t.remote.Patch(ctx, update)
 }
 
+// UploadDetails attaches the runner log to the details if the adapter reported a judge error
 func (t *RemoteJudgeTask) UploadDetails(ctx context.Context, details *common.SolutionDetails) error {
-	return client.SaveSolutionDetails(ctx, details)
+	t.mu.Lock()
+	status := t.status
+	t.mu.Unlock()
+	if status == "Judge Error" {
+		details = withRunnerLog(ctx, details)
+	}
+	return t.remote.SaveDetails(ctx, details)
+}
+
+func (t *RemoteJudgeTask) UploadArtifact(ctx context.Context, name string, content io.Reader) (*common.SolutionDetailsArtifact, error) {
+	artifact, err := SpoolArtifact(name, content)
+	if err != nil {
+		return nil, err
+	}
+	defer artifact.Remove()
+	url, err := t.remote.ArtifactUrl(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.UploadFile(ctx, url, artifact.Path); err != nil {
+		return nil, err
+	}
+	return &artifact.SolutionDetailsArtifact, nil
 }
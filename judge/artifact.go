@@ -0,0 +1,81 @@
+package judge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrArtifactTooLarge = errors.New("artifact exceeds artifact.maxSize")
+	artifactName        = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}$`)
+)
+
+// Artifact is the content of an artifact spooled to a temporary file, ready to be uploaded
+type Artifact struct {
+	common.SolutionDetailsArtifact
+	Path string
+}
+
+func (a *Artifact) Remove() {
+	os.Remove(a.Path)
+}
+
+func artifactMaxSize() int64 {
+	viper.SetDefault("artifact.maxSize", "64M")
+	size, err := storage.ParseSize(viper.GetString("artifact.maxSize"))
+	if err != nil {
+		return 64 << 20
+	}
+	return size
+}
+
+// SpoolArtifact copies content into a temporary file, so its size and hash are known before it is uploaded.
+// Names may contain letters, digits, '.', '_' and '-'. The caller must call Remove on the result.
+func SpoolArtifact(name string, content io.Reader) (*Artifact, error) {
+	if !artifactName.MatchString(name) {
+		return nil, fmt.Errorf("invalid artifact name %q", name)
+	}
+	file, err := storage.CreateTemp("artifact-*")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	artifact := &Artifact{Path: file.Name()}
+	artifact.Name = name
+	maxSize := artifactMaxSize()
+	reader := content
+	if maxSize > 0 {
+		reader = io.LimitReader(content, maxSize+1)
+	}
+	hasher := sha256.New()
+	artifact.Size, err = io.Copy(io.MultiWriter(file, hasher), reader)
+	if err == nil && maxSize > 0 && artifact.Size > maxSize {
+		err = ErrArtifactTooLarge
+	}
+	if err != nil {
+		artifact.Remove()
+		return nil, err
+	}
+	artifact.Hash = hex.EncodeToString(hasher.Sum(nil))
+	return artifact, nil
+}
+
+// UploadArtifactFile uploads a local file as the named artifact of task
+func UploadArtifactFile(ctx context.Context, task JudgeTask, name string, path string) (*common.SolutionDetailsArtifact, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return task.UploadArtifact(ctx, name, file)
+}
@@ -0,0 +1,70 @@
+package judge
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fedstackjs/azukiiro/common"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RegisterAdapter(&funcAdapter{name: "test-artifact", judge: func(ctx context.Context, task JudgeTask) error {
+		artifact, err := task.UploadArtifact(ctx, "compile.log", strings.NewReader("compiled"))
+		if err != nil {
+			return err
+		}
+		if err := task.UploadDetails(ctx, &common.SolutionDetails{Version: 1, Artifacts: []*common.SolutionDetailsArtifact{artifact}}); err != nil {
+			return err
+		}
+		return task.Update(ctx, &common.SolutionInfo{Score: 100, Status: "Accepted"})
+	}})
+}
+
+func TestUploadArtifact(t *testing.T) {
+	srv, c := setupServer(t)
+	task := enqueue(srv, "test-artifact")
+	if polled, err := Poll(context.Background(), c); !polled || err != nil {
+		t.Fatalf("Poll = %v, %v", polled, err)
+	}
+	if info := task.LastPatch(); info == nil || info.Status != "Accepted" {
+		t.Fatalf("last patch = %+v, want Accepted", info)
+	}
+	if content, ok := srv.Artifact(task.TaskId, "compile.log"); !ok || string(content) != "compiled" {
+		t.Errorf("artifact = %q, %v, want the uploaded content", content, ok)
+	}
+	details, ok := srv.Details(task.TaskId)
+	if !ok || len(details.Artifacts) != 1 {
+		t.Fatalf("details = %+v, want the artifact", details)
+	}
+	if artifact := details.Artifacts[0]; artifact.Name != "compile.log" || artifact.Size != 8 || len(artifact.Hash) != 64 {
+		t.Errorf("artifact in details = %+v", artifact)
+	}
+}
+
+func TestSpoolArtifact(t *testing.T) {
+	setupServer(t)
+	for _, name := range []string{"", ".", "..", ".hidden", "a/b", "a b", strings.Repeat("a", 129)} {
+		if artifact, err := SpoolArtifact(name, strings.NewReader("data")); err == nil {
+			artifact.Remove()
+			t.Errorf("SpoolArtifact(%q) succeeded", name)
+		}
+	}
+
+	viper.Set("artifact.maxSize", "4")
+	t.Cleanup(func() { viper.Set("artifact.maxSize", "64M") })
+	artifact, err := SpoolArtifact("fits", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact.Remove()
+	if _, err := os.Stat(artifact.Path); !os.IsNotExist(err) {
+		t.Errorf("spooled file still exists after Remove: %v", err)
+	}
+	if _, err := SpoolArtifact("large", strings.NewReader("data!")); !errors.Is(err, ErrArtifactTooLarge) {
+		t.Errorf("SpoolArtifact of 5 bytes = %v, want ErrArtifactTooLarge", err)
+	}
+}
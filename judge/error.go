@@ -3,6 +3,7 @@ package judge
 import (
 	"fmt"
 
+	"github.com/fedstackjs/azukiiro/archive"
 	"github.com/fedstackjs/azukiiro/common"
 )
 
@@ -35,3 +36,16 @@ func (e *SimpleSolutionError) Details() *common.SolutionDetails {
 		Summary: fmt.Sprintf("An Error has occurred:\n\n```%s```", e.D),
 	}
 }
+
+// BadSolutionArchive turns errors caused by the content of a solution archive into a Bad Solution verdict,
+// other errors are returned as is
+func BadSolutionArchive(err error) error {
+	if err == nil || !archive.IsBadArchive(err) {
+		return err
+	}
+	return &SimpleSolutionError{
+		S: "Bad Solution",
+		M: "Invalid solution archive",
+		D: err.Error(),
+	}
+}
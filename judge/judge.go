@@ -3,15 +3,119 @@ package judge
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/fedstackjs/azukiiro/client"
 	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/hostinfo"
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/fedstackjs/azukiiro/metrics"
+	"github.com/fedstackjs/azukiiro/registry"
 	"github.com/fedstackjs/azukiiro/storage"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
-func judge(ctx context.Context, res *client.PollSolutionResponse) error {
-	err := client.PatchSolutionTask(ctx, &common.SolutionInfo{
+// Tasks tracks the solution tasks accepted by this runner
+var Tasks = registry.New("judge")
+
+func heartbeatInterval() time.Duration {
+	return time.Duration(viper.GetFloat64("heartbeatInterval") * float64(time.Second))
+}
+
+// releaseTask hands a task back to the server so it can be reassigned
+func releaseTask(ctx context.Context, remote *client.SolutionTask, reason string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	logging.FromContext(ctx).Println("Releasing task:", reason)
+	return remote.Release(ctx, &client.ReleaseSolutionTaskRequest{
+		Message: reason,
+	})
+}
+
+// abandonTask hands an unstarted or interrupted task back to the server,
+// or reports it as cancelled if the server does not support releasing tasks
+func abandonTask(ctx context.Context, remote *client.SolutionTask, reason string) {
+	err := releaseTask(ctx, remote, reason)
+	if err == nil {
+		return
+	}
+	logging.FromContext(ctx).Warnln("Release task failed:", err)
+	reportCancelled(ctx, remote, reason)
+}
+
+// reportCancelled completes a task with the Cancelled status
+func reportCancelled(ctx context.Context, remote *client.SolutionTask, reason string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := remote.Patch(ctx, &common.SolutionInfo{
+		Score:   0,
+		Status:  "Cancelled",
+		Message: reason,
+	}); err != nil {
+		logging.FromContext(ctx).Warnln("Patch task failed:", err)
+	}
+	if err := remote.Complete(ctx); err != nil {
+		logging.FromContext(ctx).Warnln("Complete task failed:", err)
+	}
+}
+
+// withRunnerLog appends the captured runner log of the task in ctx to the summary of details
+func withRunnerLog(ctx context.Context, details *common.SolutionDetails) *common.SolutionDetails {
+	log := logging.Captured(ctx)
+	if log == "" {
+		return details
+	}
+	copied := *details
+	copied.Summary += fmt.Sprintf("\n\nRunner log:\n\n```\n%s\n```", log)
+	return &copied
+}
+
+// reportError saves the verdict of a JudgeError, or reports a judge error with the runner log
+func reportError(ctx context.Context, remote *client.SolutionTask, err error) {
+	judgeErr, ok := err.(JudgeError)
+
+	var details *common.SolutionDetails
+	if ok {
+		details = judgeErr.Details()
+	}
+	if details == nil {
+		details = withRunnerLog(ctx, &common.SolutionDetails{
+			Version: 1,
+			Jobs:    []*common.SolutionDetailsJob{},
+			Summary: fmt.Sprintf("An Error has occurred:\n\n```\n%s\n```", err),
+		})
+	}
+	if err := remote.SaveDetails(ctx, details); err != nil {
+		logging.FromContext(ctx).Warnln("Save details failed:", err)
+	}
+
+	var info *common.SolutionInfo
+	if ok {
+		info = judgeErr.Info()
+	}
+	if info == nil {
+		info = &common.SolutionInfo{
+			Score:   0,
+			Status:  "Error",
+			Message: "Judge error",
+		}
+	}
+	if err := remote.Patch(ctx, info); err != nil {
+		logging.FromContext(ctx).Warnln("Patch task failed:", err)
+	}
+}
+
+func runAdapter(ctx context.Context, adapter JudgeAdapter, task JudgeTask) error {
+	start := time.Now()
+	defer func() {
+		metrics.JudgeDuration.WithLabelValues(adapter.Name()).Observe(time.Since(start).Seconds())
+	}()
+	return adapter.Judge(ctx, task)
+}
+
+func judge(ctx context.Context, remote *client.SolutionTask, res *client.PollSolutionResponse) error {
+	err := remote.Patch(ctx, &common.SolutionInfo{
 		Score:   0,
 		Status:  "Running",
 		Message: "Preparing solution",
@@ -19,15 +123,15 @@ func judge(ctx context.Context, res *client.PollSolutionResponse) error {
 	if err != nil {
 		return err
 	}
-	problemData, err := storage.PrepareFile(ctx, res.ProblemDataUrl, res.ProblemDataHash)
+	problemData, err := storage.Fetch(ctx, res.ProblemDataUrl, res.ProblemDataHash)
 	if err != nil {
 		return err
 	}
-	solutionData, err := storage.PrepareFile(ctx, res.SolutionDataUrl, res.SolutionDataHash)
+	solutionData, err := storage.Fetch(ctx, res.SolutionDataUrl, res.SolutionDataHash)
 	if err != nil {
 		return err
 	}
-	err = client.PatchSolutionTask(ctx, &common.SolutionInfo{
+	err = remote.Patch(ctx, &common.SolutionInfo{
 		Score:   0,
 		Status:  "Running",
 		Message: "Judging",
@@ -37,7 +141,7 @@ func judge(ctx context.Context, res *client.PollSolutionResponse) error {
 	}
 	adapter, ok := GetAdapter(res.ProblemConfig.Judge.Adapter)
 	if !ok {
-		return client.PatchSolutionTask(ctx, &common.SolutionInfo{
+		return remote.Patch(ctx, &common.SolutionInfo{
 			Score:   0,
 			Status:  "Error",
 			Message: "Judge adapter not found",
@@ -47,15 +151,18 @@ func judge(ctx context.Context, res *client.PollSolutionResponse) error {
 		config:       res.ProblemConfig,
 		problemData:  problemData,
 		solutionData: solutionData,
+		remote:       remote,
 		env: map[string]string{
 			"userId": res.UserId,
 		},
 	}
-	return adapter.Judge(ctx, task)
+	return runAdapter(ctx, adapter, task)
 }
 
-func Poll(ctx context.Context) (bool, error) {
-	res, err := client.PollSolution(ctx, &client.PollSolutionRequest{})
+func Poll(ctx context.Context, c *client.Client) (bool, error) {
+	res, err := c.PollSolution(ctx, &client.PollSolutionRequest{
+		RunnerStatus: hostinfo.Status(GetAdapterNames(), 1),
+	})
 	if err != nil {
 		return false, err
 	}
@@ -65,64 +172,61 @@ func Poll(ctx context.Context) (bool, error) {
 		return false, nil
 	}
 
-	ctx = client.WithSolutionTask(ctx, res.SolutionId, res.TaskId)
+	remote := c.SolutionTask(res.SolutionId, res.TaskId)
+	ctx = logging.WithTask(ctx, c.TaskFields(logrus.Fields{
+		"taskId":     res.TaskId,
+		"solutionId": res.SolutionId,
+		"adapter":    res.ProblemConfig.Judge.Adapter,
+	}))
+	ctx, stop := remote.WithHeartbeat(ctx, heartbeatInterval())
+	defer stop()
+	adapterName := res.ProblemConfig.Judge.Adapter
+	ctx, unregister := Tasks.Add(ctx, c.Name(), res.TaskId, res.SolutionId, adapterName)
+	defer unregister()
+	ctx, unpin := storage.WithTask(ctx)
+	defer unpin()
+	metrics.TasksPolled.WithLabelValues("judge", adapterName).Inc()
 
 	if res.ErrMsg != "" {
 		// Server side error occurred
-		client.PatchSolutionTask(ctx, &common.SolutionInfo{
+		remote.Patch(ctx, &common.SolutionInfo{
 			Score:   0,
 			Status:  "Error",
 			Message: "Server side error occurred",
 		})
-		client.CompleteSolutionTask(ctx)
+		remote.Complete(ctx)
 		return true, nil
 	}
 
-	logrus.Println("Got task:", res.TaskId)
-	logrus.Println("SolutionId:", res.SolutionId)
+	logging.FromContext(ctx).Println("Got task:", res.TaskId)
+	logging.FromContext(ctx).Println("SolutionId:", res.SolutionId)
 
-	err = judge(ctx, res)
+	Tasks.SetState(c.Name(), res.TaskId, "judging")
+	err = judge(ctx, remote, res)
+	if client.IsTaskRevoked(ctx) {
+		logging.FromContext(ctx).Println("Judge aborted:", context.Cause(ctx))
+		return true, nil
+	}
+	if registry.IsCancelled(ctx) {
+		logging.FromContext(ctx).Println("Judge cancelled by operator")
+		reportCancelled(ctx, remote, "Judge cancelled by runner operator")
+		return true, nil
+	}
+	if ctx.Err() != nil {
+		abandonTask(ctx, remote, "Judge interrupted by runner shutdown")
+		return false, nil
+	}
 	if err != nil {
-		logrus.Println("Judge finished with error:", err)
-		judgeErr, ok := err.(JudgeError)
-
-		var details *common.SolutionDetails
-		if ok {
-			details = judgeErr.Details()
-		}
-		if details == nil {
-			details = &common.SolutionDetails{
-				Version: 1,
-				Jobs:    []*common.SolutionDetailsJob{},
-				Summary: fmt.Sprintf("An Error has occurred:\n\n```\n%s\n```", err),
-			}
-		}
-		err = client.SaveSolutionDetails(ctx, details)
-		if err != nil {
-			logrus.Println("Save details failed:", err)
-		}
-
-		var info *common.SolutionInfo
-		if ok {
-			info = judgeErr.Info()
-		}
-		if info == nil {
-			info = &common.SolutionInfo{
-				Score:   0,
-				Status:  "Error",
-				Message: "Judge error",
-			}
-		}
-		err = client.PatchSolutionTask(ctx, info)
-		if err != nil {
-			logrus.Println("Patch task failed:", err)
-		}
+		logging.FromContext(ctx).Println("Judge finished with error:", err)
+		metrics.TasksErrored.WithLabelValues("judge", adapterName).Inc()
+		reportError(ctx, remote, err)
 	} else {
-		logrus.Println("Judge finished")
+		logging.FromContext(ctx).Println("Judge finished")
+		metrics.TasksCompleted.WithLabelValues("judge", adapterName).Inc()
 	}
-	err = client.CompleteSolutionTask(ctx)
+	err = remote.Complete(ctx)
 	if err != nil {
-		logrus.Println("Complete task failed:", err)
+		logging.FromContext(ctx).Println("Complete task failed:", err)
 	}
 
 	return true, nil
@@ -0,0 +1,281 @@
+package judge
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/aoitest"
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/storage"
+	"github.com/spf13/viper"
+)
+
+// funcAdapter is a judge adapter judging with a function
+type funcAdapter struct {
+	name  string
+	judge func(ctx context.Context, task JudgeTask) error
+}
+
+func (a *funcAdapter) Name() string {
+	return a.name
+}
+
+func (a *funcAdapter) Judge(ctx context.Context, task JudgeTask) error {
+	return a.judge(ctx, task)
+}
+
+// running and maxRunning count the tasks judged at the same time by the test-slow adapter
+var running, maxRunning atomic.Int32
+
+func init() {
+	RegisterAdapter(&funcAdapter{name: "test-accept", judge: func(ctx context.Context, task JudgeTask) error {
+		// The adapter gets the downloaded data of the task
+		solution, err := os.ReadFile(task.SolutionData())
+		if err != nil {
+			return err
+		}
+		if err := task.UploadDetails(ctx, &common.SolutionDetails{Version: 1, Summary: string(solution)}); err != nil {
+			return err
+		}
+		return task.Update(ctx, &common.SolutionInfo{Score: 100, Status: "Accepted"})
+	}})
+	RegisterAdapter(&funcAdapter{name: "test-error", judge: func(ctx context.Context, task JudgeTask) error {
+		return errors.New("adapter failed")
+	}})
+	RegisterAdapter(&funcAdapter{name: "test-block", judge: func(ctx context.Context, task JudgeTask) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	RegisterAdapter(&funcAdapter{name: "test-slow", judge: func(ctx context.Context, task JudgeTask) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			current := maxRunning.Load()
+			if n <= current || maxRunning.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		return task.Update(ctx, &common.SolutionInfo{Score: 100, Status: "Accepted"})
+	}})
+}
+
+// setupServer starts a fake server with the storage in a temporary directory
+func setupServer(t *testing.T) (*aoitest.Server, *client.Client) {
+	t.Helper()
+	viper.Set("storagePath", t.TempDir())
+	storage.Initialize()
+	srv := aoitest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.Configure()
+	c, err := client.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, c
+}
+
+func enqueue(srv *aoitest.Server, adapter string) *aoitest.SolutionTask {
+	return srv.EnqueueSolution(*problemConfig(adapter, ""), []byte("problem"), []byte("solution"))
+}
+
+func waitDone(t *testing.T, task *aoitest.SolutionTask) {
+	t.Helper()
+	select {
+	case <-task.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("task %s was not finished", task.TaskId)
+	}
+}
+
+// waitState waits until the task is registered with state
+func waitState(t *testing.T, server string, taskId string, state string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, info := range Tasks.List() {
+			if info.Server == server && info.TaskId == taskId && info.State == state {
+				return
+			}
+		}
+	}
+	t.Fatalf("task %s did not reach state %s", taskId, state)
+}
+
+func TestPoll(t *testing.T) {
+	srv, c := setupServer(t)
+	ctx := context.Background()
+	if polled, err := Poll(ctx, c); polled || err != nil {
+		t.Fatalf("Poll without tasks = %v, %v", polled, err)
+	}
+
+	task := enqueue(srv, "test-accept")
+	if polled, err := Poll(ctx, c); !polled || err != nil {
+		t.Fatalf("Poll = %v, %v", polled, err)
+	}
+	if !task.Completed() {
+		t.Fatal("task was not completed")
+	}
+	if info := task.LastPatch(); info == nil || info.Status != "Accepted" || info.Score != 100 {
+		t.Errorf("last patch = %+v, want Accepted", info)
+	}
+	if details, ok := srv.Details(task.TaskId); !ok || details.Summary != "solution" {
+		t.Errorf("details = %+v, want the solution data", details)
+	}
+	if len(Tasks.List()) != 0 {
+		t.Error("finished task is still registered")
+	}
+}
+
+func TestPollAdapterError(t *testing.T) {
+	srv, c := setupServer(t)
+	task := enqueue(srv, "test-error")
+	if polled, err := Poll(context.Background(), c); !polled || err != nil {
+		t.Fatalf("Poll = %v, %v", polled, err)
+	}
+	if info := task.LastPatch(); info == nil || info.Status != "Error" {
+		t.Errorf("last patch = %+v, want Error", info)
+	}
+	if details, ok := srv.Details(task.TaskId); !ok || !strings.Contains(details.Summary, "adapter failed") {
+		t.Errorf("details = %+v, want the error", details)
+	}
+	if !task.Completed() {
+		t.Error("task was not completed")
+	}
+}
+
+func TestPollUnknownAdapter(t *testing.T) {
+	srv, c := setupServer(t)
+	task := enqueue(srv, "test-missing")
+	if polled, err := Poll(context.Background(), c); !polled || err != nil {
+		t.Fatalf("Poll = %v, %v", polled, err)
+	}
+	if info := task.LastPatch(); info == nil || info.Message != "Judge adapter not found" {
+		t.Errorf("last patch = %+v, want adapter not found", info)
+	}
+}
+
+func TestPollCancelled(t *testing.T) {
+	srv, c := setupServer(t)
+	task := enqueue(srv, "test-block")
+	done := make(chan bool)
+	go func() {
+		polled, _ := Poll(context.Background(), c)
+		done <- polled
+	}()
+	waitState(t, "", task.TaskId, "judging")
+	if !Tasks.Cancel("", task.TaskId) {
+		t.Fatal("task was not found")
+	}
+	if !<-done {
+		t.Error("Poll reported no task")
+	}
+	if info := task.LastPatch(); info == nil || info.Status != "Cancelled" {
+		t.Errorf("last patch = %+v, want Cancelled", info)
+	}
+	if !task.Completed() {
+		t.Error("cancelled task was not completed")
+	}
+}
+
+func TestPollShutdown(t *testing.T) {
+	srv, c := setupServer(t)
+	task := enqueue(srv, "test-block")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		polled, _ := Poll(ctx, c)
+		done <- polled
+	}()
+	waitState(t, "", task.TaskId, "judging")
+	cancel()
+	if <-done {
+		t.Error("Poll asked to continue after shutdown")
+	}
+	if task.Released() == nil {
+		t.Error("interrupted task was not released")
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	srv, c := setupServer(t)
+	// A second server hands out the same task ids
+	staging := aoitest.NewServer()
+	defer staging.Close()
+	staging.ConfigureAs("staging")
+	defer viper.Set("servers", nil)
+	stagingClient, err := client.Load("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxRunning.Store(0)
+
+	tasks := []*aoitest.SolutionTask{}
+	for i := 0; i < 3; i++ {
+		tasks = append(tasks, enqueue(srv, "test-slow"), enqueue(staging, "test-slow"))
+	}
+	if tasks[0].TaskId != tasks[1].TaskId {
+		t.Fatalf("servers handed out tasks %s and %s, want the same id", tasks[0].TaskId, tasks[1].TaskId)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		RunParallel(ctx, context.Background(), []*client.Client{c, stagingClient}, ParallelOptions{
+			Concurrency:         2,
+			PrefetchConcurrency: 2,
+			QueueDepth:          1,
+			PollInterval:        0.01,
+		})
+		close(stopped)
+	}()
+	for _, task := range tasks {
+		waitDone(t, task)
+		if info := task.LastPatch(); info == nil || info.Status != "Accepted" || !task.Completed() {
+			t.Errorf("task %s finished with %+v", task.TaskId, info)
+		}
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunParallel did not stop")
+	}
+	if n := maxRunning.Load(); n > 2 {
+		t.Errorf("judged %d tasks at the same time, want at most 2", n)
+	}
+}
+
+func TestRunParallelShutdown(t *testing.T) {
+	srv, c := setupServer(t)
+	task := enqueue(srv, "test-block")
+	ctx, cancel := context.WithCancel(context.Background())
+	taskCtx, cancelTasks := context.WithCancel(context.Background())
+	defer cancelTasks()
+	stopped := make(chan struct{})
+	go func() {
+		RunParallel(ctx, taskCtx, []*client.Client{c}, ParallelOptions{Concurrency: 1, QueueDepth: 1, PollInterval: 0.01})
+		close(stopped)
+	}()
+	waitState(t, "", task.TaskId, "judging")
+	// Running tasks outlive the pollers until the drain timeout ends taskCtx
+	cancel()
+	select {
+	case <-stopped:
+		t.Fatal("RunParallel stopped before the running task")
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancelTasks()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunParallel did not stop")
+	}
+	if task.Released() == nil {
+		t.Error("interrupted task was not released")
+	}
+}
@@ -0,0 +1,175 @@
+package judge
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fedstackjs/azukiiro/common"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Limiter bounds the number of tasks accepted from the servers, and the number of running tasks per
+// adapter and per problem label. Accepted tasks include the ones downloading or waiting in the queue,
+// while the class limits only count the tasks being judged.
+type Limiter struct {
+	mu       sync.Mutex
+	changed  chan struct{}
+	total    int
+	accepted int
+	limits   map[string]int
+	counts   map[string]int
+}
+
+func NewLimiter(total int, adapterLimits map[string]int, labelLimits map[string]int) *Limiter {
+	l := &Limiter{
+		changed: make(chan struct{}),
+		total:   total,
+		limits:  make(map[string]int),
+		counts:  make(map[string]int),
+	}
+	for name, limit := range adapterLimits {
+		l.limits["adapter/"+strings.ToLower(name)] = limit
+	}
+	for name, limit := range labelLimits {
+		l.limits["label/"+strings.ToLower(name)] = limit
+	}
+	return l
+}
+
+// NewLimiterFromConfig reads the concurrency.adapters and concurrency.labels config sections
+func NewLimiterFromConfig(total int) *Limiter {
+	adapterLimits := make(map[string]int)
+	if err := viper.UnmarshalKey("concurrency.adapters", &adapterLimits); err != nil {
+		logrus.Warnln("Failed to parse adapter concurrency limits:", err)
+	}
+	labelLimits := make(map[string]int)
+	if err := viper.UnmarshalKey("concurrency.labels", &labelLimits); err != nil {
+		logrus.Warnln("Failed to parse label concurrency limits:", err)
+	}
+	return NewLimiter(total, adapterLimits, labelLimits)
+}
+
+func limiterKeys(config *common.ProblemConfig) []string {
+	return []string{
+		"adapter/" + strings.ToLower(config.Judge.Adapter),
+		"label/" + strings.ToLower(config.Label),
+	}
+}
+
+func (l *Limiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *Limiter) available(config *common.ProblemConfig) bool {
+	for _, key := range limiterKeys(config) {
+		if limit, ok := l.limits[key]; ok && l.counts[key] >= limit {
+			return false
+		}
+	}
+	return true
+}
+
+// Free returns the number of tasks that can be accepted before the total limit is reached
+func (l *Limiter) Free() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return max(l.total-l.accepted, 0)
+}
+
+// Reserve waits until another task can be accepted and reserves a slot for it, which must be handed
+// back with Unreserve once the task is finished or none was polled
+func (l *Limiter) Reserve(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		changed := l.changed
+		if l.accepted < l.total {
+			l.accepted++
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (l *Limiter) Unreserve() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.accepted--
+	l.notify()
+}
+
+// Saturated returns the adapters and labels whose running tasks reached their limit, sorted by name
+func (l *Limiter) Saturated() ([]string, []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	adapters, labels := []string{}, []string{}
+	for key, limit := range l.limits {
+		if l.counts[key] < limit {
+			continue
+		}
+		if name, ok := strings.CutPrefix(key, "adapter/"); ok {
+			adapters = append(adapters, name)
+		} else if name, ok := strings.CutPrefix(key, "label/"); ok {
+			labels = append(labels, name)
+		}
+	}
+	sort.Strings(adapters)
+	sort.Strings(labels)
+	return adapters, labels
+}
+
+// Full reports whether a task with the given config would have to wait for another one to finish
+func (l *Limiter) Full(config *common.ProblemConfig) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.available(config)
+}
+
+// TryAcquire takes capacity to run a task with the given config if its adapter and label have some left
+func (l *Limiter) TryAcquire(config *common.ProblemConfig) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.available(config) {
+		return false
+	}
+	for _, key := range limiterKeys(config) {
+		l.counts[key]++
+	}
+	return true
+}
+
+// Acquire waits until capacity to run a task with the given config is available and takes it
+func (l *Limiter) Acquire(ctx context.Context, config *common.ProblemConfig) error {
+	for {
+		l.mu.Lock()
+		changed := l.changed
+		l.mu.Unlock()
+		if l.TryAcquire(config) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Release hands back the capacity taken by TryAcquire or Acquire
+func (l *Limiter) Release(config *common.ProblemConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range limiterKeys(config) {
+		l.counts[key]--
+	}
+	l.notify()
+}
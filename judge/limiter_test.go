@@ -0,0 +1,93 @@
+package judge
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/common"
+)
+
+func problemConfig(adapter string, label string) *common.ProblemConfig {
+	config := &common.ProblemConfig{Label: label}
+	config.Judge.Adapter = adapter
+	return config
+}
+
+func TestLimiterReserve(t *testing.T) {
+	l := NewLimiter(2, nil, nil)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := l.Reserve(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if free := l.Free(); free != 0 {
+		t.Errorf("Free = %d, want 0", free)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := l.Reserve(timeout); err == nil {
+		t.Fatal("reserved more slots than the total")
+	}
+
+	done := make(chan error)
+	go func() { done <- l.Reserve(ctx) }()
+	l.Unreserve()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reserve was not woken by Unreserve")
+	}
+}
+
+func TestLimiterClasses(t *testing.T) {
+	l := NewLimiter(10, map[string]int{"Native": 1}, map[string]int{"heavy": 2})
+	native := problemConfig("native", "")
+	heavy := problemConfig("uoj", "Heavy")
+
+	if !l.TryAcquire(native) {
+		t.Fatal("first native task was refused")
+	}
+	if !l.Full(native) || l.TryAcquire(native) {
+		t.Error("second native task was accepted")
+	}
+	if l.Full(heavy) {
+		t.Error("heavy task refused because of the native limit")
+	}
+	l.TryAcquire(heavy)
+	l.TryAcquire(heavy)
+	if !l.Full(heavy) {
+		t.Error("third heavy task was accepted")
+	}
+	adapters, labels := l.Saturated()
+	if !slices.Equal(adapters, []string{"native"}) || !slices.Equal(labels, []string{"heavy"}) {
+		t.Errorf("Saturated = %v, %v", adapters, labels)
+	}
+	// Class limits only count running tasks, not reserved slots
+	if free := l.Free(); free != 10 {
+		t.Errorf("Free = %d, want 10", free)
+	}
+
+	done := make(chan error)
+	go func() { done <- l.Acquire(context.Background(), native) }()
+	l.Release(native)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Acquire was not woken by Release")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Acquire(ctx, native); err == nil {
+		t.Error("Acquire succeeded beyond the limit")
+	}
+}
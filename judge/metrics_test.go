@@ -0,0 +1,59 @@
+package judge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fedstackjs/azukiiro/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// counterValue returns the current value of a counter
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// sampleCount returns the number of observations of a histogram
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := o.(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestPollMetrics(t *testing.T) {
+	srv, c := setupServer(t)
+	ctx := context.Background()
+	polled := counterValue(t, metrics.TasksPolled.WithLabelValues("judge", "test-accept"))
+	completed := counterValue(t, metrics.TasksCompleted.WithLabelValues("judge", "test-accept"))
+	errored := counterValue(t, metrics.TasksErrored.WithLabelValues("judge", "test-error"))
+	judged := sampleCount(t, metrics.JudgeDuration.WithLabelValues("test-accept"))
+
+	enqueue(srv, "test-accept")
+	enqueue(srv, "test-error")
+	for range 2 {
+		if ok, err := Poll(ctx, c); !ok || err != nil {
+			t.Fatalf("Poll = %v, %v", ok, err)
+		}
+	}
+	if n := counterValue(t, metrics.TasksPolled.WithLabelValues("judge", "test-accept")) - polled; n != 1 {
+		t.Errorf("polled tasks of test-accept increased by %v, want 1", n)
+	}
+	if n := counterValue(t, metrics.TasksCompleted.WithLabelValues("judge", "test-accept")) - completed; n != 1 {
+		t.Errorf("completed tasks of test-accept increased by %v, want 1", n)
+	}
+	if n := counterValue(t, metrics.TasksErrored.WithLabelValues("judge", "test-error")) - errored; n != 1 {
+		t.Errorf("errored tasks of test-error increased by %v, want 1", n)
+	}
+	if n := sampleCount(t, metrics.JudgeDuration.WithLabelValues("test-accept")) - judged; n != 1 {
+		t.Errorf("judge durations of test-accept increased by %d, want 1", n)
+	}
+}
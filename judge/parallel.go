@@ -3,39 +3,77 @@ package judge
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/fedstackjs/azukiiro/client"
 	"github.com/fedstackjs/azukiiro/common"
+	"github.com/fedstackjs/azukiiro/hostinfo"
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/fedstackjs/azukiiro/metrics"
+	"github.com/fedstackjs/azukiiro/registry"
 	"github.com/fedstackjs/azukiiro/storage"
 	"github.com/sirupsen/logrus"
 )
 
-func parallelPoll(ctx context.Context) (*RemoteJudgeTask, bool, error) {
-	res, err := client.PollSolution(ctx, &client.PollSolutionRequest{})
+type ParallelOptions struct {
+	// Number of tasks judged at the same time
+	Concurrency int
+	// Number of tasks downloading data at the same time
+	PrefetchConcurrency int
+	// Number of tasks accepted in addition to the running ones
+	QueueDepth   int
+	PollInterval float32
+}
+
+// parallelPoll polls a task with a slot reserved from limiter, the returned task holds the slot
+func parallelPoll(ctx context.Context, taskCtx context.Context, c *client.Client, limiter *Limiter) (*RemoteJudgeTask, bool, error) {
+	// The reserved slot is free from the point of view of the server
+	status := hostinfo.Status(GetAdapterNames(), limiter.Free()+1)
+	status.SaturatedAdapters, status.SaturatedLabels = limiter.Saturated()
+	res, err := c.PollSolution(ctx, &client.PollSolutionRequest{
+		RunnerStatus: status,
+	})
 	if err != nil {
+		limiter.Unreserve()
 		return nil, false, err
 	}
 
 	if res.TaskId == "" {
 		// No pending tasks
+		limiter.Unreserve()
 		return nil, false, nil
 	}
 
-	ctx = client.WithSolutionTask(ctx, res.SolutionId, res.TaskId)
+	// The task outlives the poller during a graceful shutdown
+	remote := c.SolutionTask(res.SolutionId, res.TaskId)
+	ctx = logging.WithTask(taskCtx, c.TaskFields(logrus.Fields{
+		"taskId":     res.TaskId,
+		"solutionId": res.SolutionId,
+		"adapter":    res.ProblemConfig.Judge.Adapter,
+	}))
+	ctx, stop := remote.WithHeartbeat(ctx, heartbeatInterval())
+	ctx, unregister := Tasks.Add(ctx, c.Name(), res.TaskId, res.SolutionId, res.ProblemConfig.Judge.Adapter)
+	ctx, unpin := storage.WithTask(ctx)
 
-	logrus.Println("Got task:", res.TaskId)
-	logrus.Println("SolutionId:", res.SolutionId)
+	logging.FromContext(ctx).Println("Got task:", res.TaskId)
+	logging.FromContext(ctx).Println("SolutionId:", res.SolutionId)
+	metrics.TasksPolled.WithLabelValues("judge", res.ProblemConfig.Judge.Adapter).Inc()
 
 	task := &RemoteJudgeTask{
 		config:       res.ProblemConfig,
 		problemData:  "",
 		solutionData: "",
-		solutionId:   res.SolutionId,
-		taskId:       res.TaskId,
+		remote:       remote,
 		env: map[string]string{
 			"userId": res.UserId,
 		},
+		ctx:        ctx,
+		stop:       stop,
+		unregister: unregister,
+		unpin:      unpin,
+		limiter:    limiter,
+		poll:       res,
 	}
 
 	if res.ErrMsg != "" {
@@ -44,7 +82,7 @@ func parallelPoll(ctx context.Context) (*RemoteJudgeTask, bool, error) {
 
 	_, ok := GetAdapter(res.ProblemConfig.Judge.Adapter)
 	if !ok {
-		err := client.PatchSolutionTask(ctx, &common.SolutionInfo{
+		err := remote.Patch(ctx, &common.SolutionInfo{
 			Score:   0,
 			Status:  "Error",
 			Message: "Judge adapter not found",
@@ -52,92 +90,150 @@ func parallelPoll(ctx context.Context) (*RemoteJudgeTask, bool, error) {
 		return task, true, err
 	}
 
-	err = client.PatchSolutionTask(ctx, &common.SolutionInfo{
+	// Servers ignoring the saturated classes may still hand out such tasks. Polling again is delayed
+	// after handing one back, so that the same task does not bounce between the runner and the server.
+	if limiter.Full(&task.config) {
+		if err := releaseTask(ctx, remote, "Runner has no free capacity for this task"); err == nil {
+			task.finish()
+			return nil, false, nil
+		}
+		// The server does not support releasing tasks, the judger waits for capacity once it is downloaded
+		logging.FromContext(ctx).Println("No free capacity for the task, it will wait after download")
+	}
+
+	err = remote.Patch(ctx, &common.SolutionInfo{
 		Score:   0,
 		Status:  "Queued",
-		Message: "Preparing solution",
+		Message: "Waiting for download",
 	})
 	if err != nil {
 		return task, true, err
 	}
 
-	task.problemData, err = storage.PrepareFile(ctx, res.ProblemDataUrl, res.ProblemDataHash)
+	return task, true, nil
+}
+
+func prefetch(task *RemoteJudgeTask) error {
+	ctx := task.ctx
+	Tasks.SetState(task.remote.Server(), task.remote.TaskId, "prefetching")
+	err := task.remote.Patch(ctx, &common.SolutionInfo{
+		Score:   0,
+		Status:  "Queued",
+		Message: "Preparing solution",
+	})
+	if err != nil {
+		return err
+	}
+
+	task.problemData, err = storage.Fetch(ctx, task.poll.ProblemDataUrl, task.poll.ProblemDataHash)
 	if err != nil {
-		return task, true, err
+		return err
 	}
-	task.solutionData, err = storage.PrepareFile(ctx, res.SolutionDataUrl, res.SolutionDataHash)
+	task.solutionData, err = storage.Fetch(ctx, task.poll.SolutionDataUrl, task.poll.SolutionDataHash)
 	if err != nil {
-		return task, true, err
+		return err
 	}
 
-	err = client.PatchSolutionTask(ctx, &common.SolutionInfo{
+	return task.remote.Patch(ctx, &common.SolutionInfo{
 		Score:   0,
 		Status:  "Queued",
 		Message: "Waiting for judge",
 	})
-	if err != nil {
-		return task, true, err
-	}
+}
 
-	return task, true, nil
+// skipTask finishes a task that failed before it was judged
+func skipTask(task *RemoteJudgeTask, err error) {
+	defer task.finish()
+	if client.IsTaskRevoked(task.ctx) {
+		logging.FromContext(task.ctx).Println("Judge skipped:", context.Cause(task.ctx))
+		return
+	}
+	if registry.IsCancelled(task.ctx) {
+		logging.FromContext(task.ctx).Println("Judge cancelled by operator")
+		reportCancelled(task.ctx, task.remote, "Judge cancelled by runner operator")
+		return
+	}
+	if task.ctx.Err() != nil {
+		abandonTask(task.ctx, task.remote, "Judge interrupted by runner shutdown")
+		return
+	}
+	logging.FromContext(task.ctx).Println("Judge skipped with error:", err)
+	metrics.TasksErrored.WithLabelValues("judge", task.config.Judge.Adapter).Inc()
+	reportError(task.ctx, task.remote, err)
+	if err := task.remote.Complete(task.ctx); err != nil {
+		logging.FromContext(task.ctx).Warnln("Complete task failed:", err)
+	}
 }
 
-func ParallelPoller(ctx context.Context, pollInterval float32, queue chan<- *RemoteJudgeTask) {
-	logrus.Infoln("Parallel poller started")
+// ParallelPoller polls tasks from the server of c until ctx is done. Tasks run with contexts derived
+// from taskCtx, tasks that are still pending when ctx is done are handed back to the server.
+func ParallelPoller(ctx context.Context, taskCtx context.Context, c *client.Client, pollInterval float32, limiter *Limiter, pending chan<- *RemoteJudgeTask) {
+	log := c.Logger()
+	log.Infoln("Parallel poller started")
 	for {
-		task, cont, err := parallelPoll(ctx)
+		if err := Tasks.WaitResumed(ctx); err != nil {
+			log.Info("Stopping parallel poller")
+			return
+		}
+		// Only accept new tasks when there is free capacity
+		if err := limiter.Reserve(ctx); err != nil {
+			log.Info("Stopping parallel poller")
+			return
+		}
+		task, cont, err := parallelPoll(ctx, taskCtx, c, limiter)
 		if task != nil {
 			if err != nil {
-				ctx := client.WithSolutionTask(ctx, task.solutionId, task.taskId)
-				logrus.Println("Judge skipped with error:", err)
-
-				if err = client.SaveSolutionDetails(ctx, &common.SolutionDetails{
-					Version: 1,
-					Jobs:    []*common.SolutionDetailsJob{},
-					Summary: fmt.Sprintf("An Error has occurred:\n\n```\n%s\n```", err),
-				}); err != nil {
-					logrus.Warnln("Save details failed:", err)
-				}
-
-				if err := client.PatchSolutionTask(ctx, &common.SolutionInfo{
-					Score:   0,
-					Status:  "Error",
-					Message: "Judge error",
-				}); err != nil {
-					logrus.Warnln("Patch task failed:", err)
-				}
-
-				if err := client.CompleteSolutionTask(ctx); err != nil {
-					logrus.Warnln("Complete task failed:", err)
-				}
+				skipTask(task, err)
 			} else {
-				queue <- task
+				select {
+				case pending <- task:
+				case <-ctx.Done():
+					abandonTask(task.ctx, task.remote, "Runner is shutting down")
+					task.finish()
+				}
 			}
 		} else {
 			if err != nil {
-				logrus.Warnln("Failed to poll:", err)
+				log.Warnln("Failed to poll:", err)
 			}
 		}
 		if cont {
 			select {
 			case <-ctx.Done():
-				logrus.Info("Stopping parallel poller")
-				close(queue)
+				log.Info("Stopping parallel poller")
 				return
 			default:
 			}
 			continue
 		}
-		timer := time.NewTimer(time.Duration(pollInterval) * time.Second)
+		if err := c.IdleWait(ctx, client.KindSolution, time.Duration(pollInterval*float32(time.Second))); err != nil {
+			log.Info("Stopping parallel poller")
+			return
+		}
+	}
+}
+
+// ParallelPrefetcher downloads data of pending tasks and passes them to queue.
+// Once ctx is done, pending tasks are handed back to the server instead.
+func ParallelPrefetcher(ctx context.Context, pending <-chan *RemoteJudgeTask, queue chan<- *RemoteJudgeTask) {
+	for task := range pending {
+		if ctx.Err() != nil {
+			abandonTask(task.ctx, task.remote, "Runner is shutting down")
+			task.finish()
+			continue
+		}
+		if err := prefetch(task); err != nil {
+			skipTask(task, err)
+			continue
+		}
+		Tasks.SetState(task.remote.Server(), task.remote.TaskId, "queued")
+		metrics.JudgeQueueDepth.Inc()
 		select {
+		case queue <- task:
 		case <-ctx.Done():
-			if !timer.Stop() {
-				<-timer.C
-			}
-			logrus.Info("Stopping parallel poller")
-			close(queue)
-			return
-		case <-timer.C:
+			metrics.JudgeQueueDepth.Dec()
+			abandonTask(task.ctx, task.remote, "Runner is shutting down")
+			task.finish()
 		}
 	}
 }
@@ -145,45 +241,133 @@ func ParallelPoller(ctx context.Context, pollInterval float32, queue chan<- *Rem
 func parallelJudge(ctx context.Context, task *RemoteJudgeTask) error {
 	adapter, ok := GetAdapter(task.config.Judge.Adapter)
 	if !ok {
-		return client.PatchSolutionTask(ctx, &common.SolutionInfo{
+		return task.remote.Patch(ctx, &common.SolutionInfo{
 			Score:   0,
 			Status:  "Error",
 			Message: "Judge adapter not found",
 		})
 	}
-	return adapter.Judge(ctx, task)
+	return runAdapter(ctx, adapter, task)
+}
+
+// acquireCapacity waits until the adapter and label of the task may run another task, or until the
+// task or ctx is done
+func acquireCapacity(ctx context.Context, task *RemoteJudgeTask) error {
+	if !task.limiter.TryAcquire(&task.config) {
+		logging.FromContext(task.ctx).Println("Waiting for capacity")
+		waitCtx, cancel := context.WithCancel(task.ctx)
+		defer cancel()
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
+		if err := task.limiter.Acquire(waitCtx, &task.config); err != nil {
+			return err
+		}
+	}
+	task.acquired = true
+	return nil
 }
 
+// ParallelJudger judges tasks from queue until it is closed.
+// Once ctx is done, queued tasks are handed back to the server instead.
 func ParallelJudger(ctx context.Context, queue <-chan *RemoteJudgeTask) {
 	logrus.Infoln("Parallel judger started")
 	for task := range queue {
-		ctx := client.WithSolutionTask(ctx, task.solutionId, task.taskId)
+		metrics.JudgeQueueDepth.Dec()
+		if ctx.Err() != nil {
+			abandonTask(task.ctx, task.remote, "Runner is shutting down")
+			task.finish()
+			continue
+		}
+		if err := task.ctx.Err(); err != nil {
+			// Revoked or cancelled while queued
+			skipTask(task, err)
+			continue
+		}
+		if err := acquireCapacity(ctx, task); err != nil {
+			if ctx.Err() != nil {
+				abandonTask(task.ctx, task.remote, "Runner is shutting down")
+				task.finish()
+			} else {
+				skipTask(task, err)
+			}
+			continue
+		}
+		ctx := task.ctx
+		Tasks.SetState(task.remote.Server(), task.remote.TaskId, "judging")
 		err := parallelJudge(ctx, task)
+		if client.IsTaskRevoked(ctx) {
+			logging.FromContext(ctx).Println("Judge aborted:", context.Cause(ctx))
+			task.finish()
+			continue
+		}
+		if registry.IsCancelled(ctx) {
+			logging.FromContext(ctx).Println("Judge cancelled by operator")
+			reportCancelled(ctx, task.remote, "Judge cancelled by runner operator")
+			task.finish()
+			continue
+		}
+		if ctx.Err() != nil {
+			abandonTask(ctx, task.remote, "Judge interrupted by runner shutdown")
+			task.finish()
+			continue
+		}
 		if err != nil {
-			logrus.Println("Judge finished with error:", err)
-			err = client.SaveSolutionDetails(ctx, &common.SolutionDetails{
-				Version: 1,
-				Jobs:    []*common.SolutionDetailsJob{},
-				Summary: fmt.Sprintf("An Error has occurred:\n\n```\n%s\n```", err),
-			})
-			if err != nil {
-				logrus.Println("Save details failed:", err)
-			}
-			err = client.PatchSolutionTask(ctx, &common.SolutionInfo{
-				Score:   0,
-				Status:  "Error",
-				Message: "Judge error",
-			})
-			if err != nil {
-				logrus.Println("Patch task failed:", err)
-			}
+			logging.FromContext(ctx).Println("Judge finished with error:", err)
+			metrics.TasksErrored.WithLabelValues("judge", task.config.Judge.Adapter).Inc()
+			reportError(ctx, task.remote, err)
 		} else {
-			logrus.Println("Judge finished")
+			logging.FromContext(ctx).Println("Judge finished")
+			metrics.TasksCompleted.WithLabelValues("judge", task.config.Judge.Adapter).Inc()
 		}
-		err = client.CompleteSolutionTask(ctx)
+		err = task.remote.Complete(ctx)
 		if err != nil {
-			logrus.Println("Complete task failed:", err)
+			logging.FromContext(ctx).Println("Complete task failed:", err)
 		}
+		task.finish()
 	}
 	logrus.Info("Stopping parallel judger")
 }
+
+// RunParallel runs the poll, prefetch and judge stages until ctx is done and all tasks are finished.
+// Tasks are polled from every client and share the capacity of the runner.
+func RunParallel(ctx context.Context, taskCtx context.Context, clients []*client.Client, opts ParallelOptions) {
+	pending := make(chan *RemoteJudgeTask)
+	queue := make(chan *RemoteJudgeTask, opts.QueueDepth)
+	limiter := NewLimiterFromConfig(opts.Concurrency + opts.QueueDepth)
+
+	wg := sync.WaitGroup{}
+	pollWg := sync.WaitGroup{}
+	for _, c := range clients {
+		pollWg.Add(1)
+		go func() {
+			ParallelPoller(ctx, taskCtx, c, opts.PollInterval, limiter, pending)
+			pollWg.Done()
+		}()
+	}
+	go func() {
+		pollWg.Wait()
+		close(pending)
+	}()
+
+	prefetchWg := sync.WaitGroup{}
+	for i := 0; i < max(opts.PrefetchConcurrency, 1); i++ {
+		prefetchWg.Add(1)
+		go func() {
+			ParallelPrefetcher(ctx, pending, queue)
+			prefetchWg.Done()
+		}()
+	}
+	go func() {
+		prefetchWg.Wait()
+		close(queue)
+	}()
+
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			ParallelJudger(ctx, queue)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
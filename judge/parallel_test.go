@@ -0,0 +1,115 @@
+package judge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/aoitest"
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/spf13/viper"
+)
+
+// startParallel runs RunParallel until the returned function is called
+func startParallel(t *testing.T, c *client.Client, opts ParallelOptions) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		RunParallel(ctx, context.Background(), []*client.Client{c}, opts)
+		close(stopped)
+	}()
+	return func() {
+		t.Helper()
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("RunParallel did not stop")
+		}
+	}
+}
+
+func TestRunParallelPrefetchesWhileJudging(t *testing.T) {
+	srv, c := setupServer(t)
+	blocker := enqueue(srv, "test-block")
+	next := enqueue(srv, "test-accept")
+	waiting := enqueue(srv, "test-accept")
+	stop := startParallel(t, c, ParallelOptions{Concurrency: 1, PrefetchConcurrency: 1, QueueDepth: 1, PollInterval: 0.01})
+	defer stop()
+
+	waitState(t, "", blocker.TaskId, "judging")
+	// The next task is downloaded while the judger is busy
+	waitState(t, "", next.TaskId, "queued")
+	if info := next.LastPatch(); info == nil || info.Message != "Waiting for judge" {
+		t.Errorf("queued task reported %+v, want waiting for judge", info)
+	}
+	// The running and the queued task take all slots, so no more tasks are accepted
+	time.Sleep(100 * time.Millisecond)
+	if info := waiting.LastPatch(); info != nil {
+		t.Errorf("task beyond the queue depth was accepted: %+v", info)
+	}
+
+	if !Tasks.Cancel("", blocker.TaskId) {
+		t.Fatal("blocking task was not found")
+	}
+	for _, task := range []*aoitest.SolutionTask{next, waiting} {
+		waitDone(t, task)
+		if info := task.LastPatch(); info == nil || info.Status != "Accepted" {
+			t.Errorf("task %s finished with %+v", task.TaskId, info)
+		}
+	}
+}
+
+func TestRunParallelPrefetchFailure(t *testing.T) {
+	srv, c := setupServer(t)
+	viper.Set("download.attempts", 1)
+	defer viper.Set("download.attempts", nil)
+	res := enqueue(srv, "test-accept").PollSolutionResponse
+	// A second task whose problem data does not match its hash
+	sum := sha256.Sum256([]byte("other"))
+	res.TaskId += "-corrupt"
+	res.ProblemDataHash = hex.EncodeToString(sum[:])
+	task := srv.EnqueueSolutionResponse(res)
+	stop := startParallel(t, c, ParallelOptions{Concurrency: 1, PrefetchConcurrency: 1, QueueDepth: 1, PollInterval: 0.01})
+	defer stop()
+
+	waitDone(t, task)
+	if info := task.LastPatch(); info == nil || info.Status != "Error" || !task.Completed() {
+		t.Errorf("task finished with %+v, want a completed error", info)
+	}
+	if details, ok := srv.Details(task.TaskId); !ok || !strings.Contains(details.Summary, "hash mismatch") {
+		t.Errorf("details = %+v, want the download error", details)
+	}
+}
+
+func TestRunParallelAdapterLimit(t *testing.T) {
+	srv, c := setupServer(t)
+	viper.Set("concurrency.adapters", map[string]int{"test-slow": 1})
+	defer viper.Set("concurrency.adapters", nil)
+	maxRunning.Store(0)
+	tasks := []*aoitest.SolutionTask{}
+	for i := 0; i < 4; i++ {
+		tasks = append(tasks, enqueue(srv, "test-slow"))
+	}
+	stop := startParallel(t, c, ParallelOptions{Concurrency: 3, PrefetchConcurrency: 2, QueueDepth: 1, PollInterval: 0.01})
+	defer stop()
+
+	// The fake server ignores the saturated adapters, so tasks polled while one runs are handed back
+	for _, task := range tasks {
+		waitDone(t, task)
+		if released := task.Released(); released != nil {
+			if !strings.Contains(released.Message, "no free capacity") {
+				t.Errorf("task %s released with %q", task.TaskId, released.Message)
+			}
+		} else if info := task.LastPatch(); info == nil || info.Status != "Accepted" {
+			t.Errorf("task %s finished with %+v", task.TaskId, info)
+		}
+	}
+	if n := maxRunning.Load(); n != 1 {
+		t.Errorf("judged %d test-slow tasks at the same time, want 1", n)
+	}
+}
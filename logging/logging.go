@@ -0,0 +1,241 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fedstackjs/azukiiro/secret"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+type loggingContextKey int
+
+const (
+	fieldsKey loggingContextKey = iota
+	captureKey
+)
+
+var (
+	captureLines    = 0
+	captureHookOnce sync.Once
+	redactions      []*regexp.Regexp
+	secretsMu       sync.RWMutex
+	secrets         []string
+	// Signed download URLs carry credentials in their query string
+	urlQuery = regexp.MustCompile(`(https?://[^\s?"]+)\?[^\s"]+`)
+)
+
+// Setup configures the standard logger from the log config section
+func Setup() {
+	switch viper.GetString("log.format") {
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	case "", "text":
+	default:
+		logrus.Warnln("Unknown log format:", viper.GetString("log.format"))
+	}
+	if level := viper.GetString("log.level"); level != "" {
+		if parsed, err := logrus.ParseLevel(level); err != nil {
+			logrus.Warnln("Unknown log level:", level)
+		} else {
+			logrus.SetLevel(parsed)
+		}
+	}
+
+	captureLines = viper.GetInt("log.capture.lines")
+	redactions = nil
+	for _, pattern := range viper.GetStringSlice("log.capture.redact") {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logrus.Warnf("Invalid redact pattern %q: %v", pattern, err)
+			continue
+		}
+		redactions = append(redactions, re)
+	}
+	secretsMu.Lock()
+	secrets = nil
+	secretsMu.Unlock()
+	// Errors are reported by the client when it loads the credentials
+	if key, err := secret.Get("runnerKey"); err == nil {
+		AddSecret(key)
+	}
+	// Setup runs again when the config is reloaded, a second hook would capture every line twice
+	if captureLines > 0 {
+		captureHookOnce.Do(func() { logrus.AddHook(&captureHook{}) })
+	}
+}
+
+// WithTask returns a context whose logger carries fields, and whose log is captured if enabled
+func WithTask(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := logrus.Fields{}
+	if parent, ok := ctx.Value(fieldsKey).(logrus.Fields); ok {
+		for k, v := range parent {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	ctx = context.WithValue(ctx, fieldsKey, merged)
+	if captureLines > 0 {
+		ctx = context.WithValue(ctx, captureKey, &capture{})
+	}
+	return ctx
+}
+
+// FromContext returns the logger of the task in ctx, or the standard logger if there is none
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry := logrus.WithContext(ctx)
+	if fields, ok := ctx.Value(fieldsKey).(logrus.Fields); ok {
+		entry = entry.WithFields(fields)
+	}
+	return entry
+}
+
+// Captured returns the redacted tail of the log captured for the task in ctx
+func Captured(ctx context.Context) string {
+	c, ok := ctx.Value(captureKey).(*capture)
+	if !ok {
+		return ""
+	}
+	return redact(c.String())
+}
+
+// AddSecret makes captured logs hide value, such as a rotated runner key
+func AddSecret(value string) {
+	if value == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, existing := range secrets {
+		if existing == value {
+			return
+		}
+	}
+	secrets = append(secrets, value)
+}
+
+func redact(content string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		content = strings.ReplaceAll(content, secret, "<redacted>")
+	}
+	secretsMu.RUnlock()
+	content = urlQuery.ReplaceAllString(content, "$1?<redacted>")
+	for _, re := range redactions {
+		content = re.ReplaceAllString(content, "<redacted>")
+	}
+	return content
+}
+
+type capture struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *capture) append(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, line)
+	if len(c.lines) > captureLines {
+		c.lines = c.lines[len(c.lines)-captureLines:]
+	}
+}
+
+func (c *capture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return strings.Join(c.lines, "\n")
+}
+
+type captureHook struct{}
+
+func (h *captureHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *captureHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	c, ok := entry.Context.Value(captureKey).(*capture)
+	if !ok {
+		return nil
+	}
+	line := entry.Time.Format("15:04:05") + " " + strings.ToUpper(entry.Level.String())
+	if stream, ok := entry.Data["stream"].(string); ok {
+		line += " [" + stream + "]"
+	}
+	c.append(line + " " + entry.Message)
+	return nil
+}
+
+// maxLineLength caps the length of a logged line, the rest of longer lines is dropped
+const maxLineLength = 16 << 10
+
+type lineWriter struct {
+	entry *logrus.Entry
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	// Whether the current line was cut at maxLineLength
+	truncated bool
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i >= 0 {
+			chunk = p[:i]
+		}
+		if !w.truncated {
+			if room := maxLineLength - w.buf.Len(); len(chunk) > room {
+				chunk = chunk[:room]
+				w.truncated = true
+			}
+			w.buf.Write(chunk)
+		}
+		if i < 0 {
+			// Keep the incomplete line for the next write
+			break
+		}
+		w.flush()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush logs the buffered line, w.mu must be held
+func (w *lineWriter) flush() {
+	line := strings.TrimRight(w.buf.String(), "\r")
+	if w.truncated {
+		line += " [truncated]"
+	}
+	w.entry.Info(line)
+	w.buf.Reset()
+	w.truncated = false
+}
+
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() > 0 {
+		w.flush()
+	}
+	return nil
+}
+
+// Writer returns a writer that logs each line written to it, used for subprocess output.
+// Close must be called to flush the last incomplete line.
+func Writer(ctx context.Context, stream string) io.WriteCloser {
+	return &lineWriter{entry: FromContext(ctx).WithField("stream", stream)}
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+)
+
+func newTestWriter() (*lineWriter, *test.Hook) {
+	logger, hook := test.NewNullLogger()
+	return &lineWriter{entry: logrus.NewEntry(logger)}, hook
+}
+
+func messages(hook *test.Hook) []string {
+	lines := []string{}
+	for _, entry := range hook.AllEntries() {
+		lines = append(lines, entry.Message)
+	}
+	return lines
+}
+
+func TestLineWriter(t *testing.T) {
+	w, hook := newTestWriter()
+	io.WriteString(w, "first\r\nsec")
+	io.WriteString(w, "ond\nthird")
+	w.Close()
+	got := strings.Join(messages(hook), "|")
+	if got != "first|second|third" {
+		t.Errorf("logged %q", got)
+	}
+}
+
+func TestLineWriterTruncates(t *testing.T) {
+	w, hook := newTestWriter()
+	long := strings.Repeat("x", maxLineLength)
+	for i := 0; i < 10; i++ {
+		io.WriteString(w, long)
+	}
+	if w.buf.Len() > maxLineLength {
+		t.Errorf("buffered %d bytes, want at most %d", w.buf.Len(), maxLineLength)
+	}
+	io.WriteString(w, "\nnext\n")
+	lines := messages(hook)
+	if len(lines) != 2 || lines[0] != long+" [truncated]" || lines[1] != "next" {
+		t.Errorf("logged %d lines, want the truncated line and next", len(lines))
+	}
+}
+
+func TestSetupAddsCaptureHookOnce(t *testing.T) {
+	viper.Set("log.capture.lines", 10)
+	defer viper.Set("log.capture.lines", 0)
+	Setup()
+	Setup()
+	hooks := 0
+	for _, hook := range logrus.StandardLogger().Hooks[logrus.InfoLevel] {
+		if _, ok := hook.(*captureHook); ok {
+			hooks++
+		}
+	}
+	if hooks != 1 {
+		t.Fatalf("%d capture hooks registered, want 1", hooks)
+	}
+
+	ctx := WithTask(context.Background(), logrus.Fields{"taskId": "task"})
+	FromContext(ctx).Info("captured")
+	if got := Captured(ctx); strings.Count(got, "captured") != 1 {
+		t.Errorf("captured %q, want the line once", got)
+	}
+}
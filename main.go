@@ -43,5 +43,10 @@ func init() {
 func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
+	go func() {
+		<-ctx.Done()
+		// Restore default signal handling, so a second signal forces exit
+		stop()
+	}()
 	cli.Execute(ctx)
 }
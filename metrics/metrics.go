@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+const namespace = "azukiiro"
+
+var (
+	TasksPolled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_polled_total",
+		Help:      "Number of tasks received from the server",
+	}, []string{"role", "adapter"})
+	TasksCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_completed_total",
+		Help:      "Number of tasks completed without runner side errors",
+	}, []string{"role", "adapter"})
+	TasksErrored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_errored_total",
+		Help:      "Number of tasks failed with runner side errors",
+	}, []string{"role", "adapter"})
+
+	JudgeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "judge_duration_seconds",
+		Help:      "Time spent in judge adapters",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"adapter"})
+	JudgeQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "judge_queue_depth",
+		Help:      "Number of prepared tasks waiting for a free judger",
+	})
+
+	CacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_cache_hits_total",
+		Help:      "Number of files served from the download cache",
+	})
+	CacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_cache_misses_total",
+		Help:      "Number of files not found in the download cache",
+	})
+	DownloadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_download_bytes_total",
+		Help:      "Number of bytes downloaded",
+	})
+
+	APIRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of AOI server API calls",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+	APIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "Number of failed AOI server API calls",
+	}, []string{"endpoint", "code"})
+
+	RankerSyncDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ranker_sync_duration_seconds",
+		Help:      "Time spent generating and uploading ranklists",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+
+	InstanceOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "instance_operations_total",
+		Help:      "Number of instance start and destroy operations",
+	}, []string{"action", "adapter", "result"})
+)
+
+// Serve exposes metrics on addr until ctx is done
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	context.AfterFunc(ctx, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	logrus.Infoln("Serving metrics on", addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -4,9 +4,14 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/fedstackjs/azukiiro/client"
 	"github.com/fedstackjs/azukiiro/db"
+	"github.com/fedstackjs/azukiiro/hostinfo"
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/fedstackjs/azukiiro/metrics"
+	"github.com/fedstackjs/azukiiro/registry"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -48,14 +53,46 @@ func (a ByTotalScoreAndTime) Less(i, j int) bool {
 	return a[i].TotalScore > a[j].TotalScore || a[i].TotalScore == a[j].TotalScore && a[i].LastSolutionTime < a[j].LastSolutionTime
 }
 
-func Poll(ctx context.Context) (bool, error) {
-	res, err := client.PollRanklist(ctx, &client.PollRanklistRequest{})
+// Tasks tracks the ranklist tasks accepted by this runner
+var Tasks = registry.New("ranker")
+
+// collectionName returns the collection caching the participants of a contest. Servers other than
+// the default one get a prefix, since a staging deployment may share contest IDs with production.
+func collectionName(c *client.Client, contestId string) string {
+	name := fmt.Sprintf("contest-%v-participants", contestId)
+	if c.Name() != "" {
+		name = c.Name() + "-" + name
+	}
+	return name
+}
+
+func Poll(ctx context.Context, c *client.Client) (polled bool, err error) {
+	res, err := c.PollRanklist(ctx, &client.PollRanklistRequest{
+		RunnerStatus: hostinfo.Status(nil, 1),
+	})
 	if err != nil || res.TaskId == "" {
 		return false, err
 	}
-	ctx = client.WithRanklistTask(ctx, res.TaskId, res.ContestId)
+	metrics.TasksPolled.WithLabelValues("ranker", "").Inc()
+	start := time.Now()
+	defer func() {
+		metrics.RankerSyncDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.TasksErrored.WithLabelValues("ranker", "").Inc()
+		} else {
+			metrics.TasksCompleted.WithLabelValues("ranker", "").Inc()
+		}
+	}()
+	remote := c.RanklistTask(res.TaskId, res.ContestId)
+	ctx = logging.WithTask(ctx, c.TaskFields(logrus.Fields{
+		"taskId":    res.TaskId,
+		"contestId": res.ContestId,
+	}))
+	ctx, unregister := Tasks.Add(ctx, c.Name(), res.TaskId, res.ContestId, "")
+	defer unregister()
+	Tasks.SetState(c.Name(), res.TaskId, "running")
 	// Sync solution list
-	collection := db.Collection(ctx, fmt.Sprintf("contest-%v-participants", res.ContestId))
+	collection := db.Collection(ctx, collectionName(c, res.ContestId))
 	now := res.RanklistUpdatedAt
 	since := 0
 	lastId := ""
@@ -70,7 +107,7 @@ func Poll(ctx context.Context) (bool, error) {
 		lastId = participant.Id
 	}
 	for {
-		res, err := client.GetRanklistParticipants(ctx, since, lastId)
+		res, err := remote.Participants(ctx, since, lastId)
 		if err != nil {
 			return true, err
 		}
@@ -122,7 +159,7 @@ func Poll(ctx context.Context) (bool, error) {
 	sort.Sort(ByTotalScoreAndTime(participants))
 
 	// Sync ranklist
-	problems, err := client.GetRanklistProblems(ctx)
+	problems, err := remote.Problems(ctx)
 	if err != nil {
 		return true, err
 	}
@@ -142,7 +179,7 @@ func Poll(ctx context.Context) (bool, error) {
 	ranklistMap := make(map[string]*client.Ranklist)
 	for _, value := range res.Ranklists {
 		// Currently there is no settings associated with ranklist, which is subject to change
-		logrus.Println("Processing ranklist: ", value.Key)
+		logging.FromContext(ctx).Println("Processing ranklist: ", value.Key)
 		rank := 0
 		var items []*client.RanklistParticipantItem
 		for _, participant := range participants {
@@ -188,10 +225,10 @@ func Poll(ctx context.Context) (bool, error) {
 		}
 		ranklistMap[value.Key] = ranklist
 	}
-	if err = client.SaveRanklist(ctx, ranklistMap); err != nil {
+	if err = remote.SaveRanklist(ctx, ranklistMap); err != nil {
 		return true, err
 	}
-	if err = client.CompleteRanklistTask(ctx, &client.CompleteRanklistTaskRequest{
+	if err = remote.Complete(ctx, &client.CompleteRanklistTaskRequest{
 		RanklistUpdatedAt: now,
 	}); err != nil {
 		return true, err
@@ -0,0 +1,99 @@
+package ranker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/aoitest"
+	"github.com/fedstackjs/azukiiro/client"
+	"github.com/fedstackjs/azukiiro/db"
+	"github.com/spf13/viper"
+)
+
+func setupServer(t *testing.T) (*aoitest.Server, *client.Client) {
+	t.Helper()
+	srv := aoitest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.Configure()
+	c, err := client.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, c
+}
+
+func TestSortByTotalScoreAndTime(t *testing.T) {
+	participants := []ParticipantView{
+		{TotalScore: 100, LastSolutionTime: 30, Raw: &Participant{Id: "late"}},
+		{TotalScore: 50, LastSolutionTime: 10, Raw: &Participant{Id: "low"}},
+		{TotalScore: 100, LastSolutionTime: 20, Raw: &Participant{Id: "early"}},
+	}
+	sort.Sort(ByTotalScoreAndTime(participants))
+	order := []string{}
+	for _, p := range participants {
+		order = append(order, p.Raw.Id)
+	}
+	if fmt.Sprint(order) != "[early late low]" {
+		t.Errorf("order = %v, want higher scores first and earlier solutions first on ties", order)
+	}
+}
+
+func TestPollIdle(t *testing.T) {
+	_, c := setupServer(t)
+	// Without a task no database access is needed
+	if polled, err := Poll(context.Background(), c); polled || err != nil {
+		t.Fatalf("Poll without tasks = %v, %v", polled, err)
+	}
+}
+
+// TestPoll needs a MongoDB server, given as a connection string with a database in AZUKIIRO_TEST_DB_ADDR
+func TestPoll(t *testing.T) {
+	dbAddr := os.Getenv("AZUKIIRO_TEST_DB_ADDR")
+	if dbAddr == "" {
+		t.Skip("AZUKIIRO_TEST_DB_ADDR not set")
+	}
+	srv, c := setupServer(t)
+	viper.Set("dbAddr", dbAddr)
+	defer viper.Set("dbAddr", "")
+	ctx, cleanup := db.WithMongo(context.Background())
+	defer cleanup()
+
+	task := &aoitest.RanklistTask{}
+	task.ContestId = fmt.Sprintf("test-%d", time.Now().UnixNano())
+	task.RanklistUpdatedAt = 100
+	task.Ranklists = []client.RanklistDTO{{Key: "public", Name: "Public"}}
+	defer db.Collection(ctx, collectionName(c, task.ContestId)).Drop(ctx)
+	if err := json.Unmarshal([]byte(`[
+		{"_id": "p1", "userId": "alice", "results": {"a": {"lastSolution": {"score": 100, "completedAt": 20}}}, "updatedAt": 50},
+		{"_id": "p2", "userId": "bob", "results": {"a": {"lastSolution": {"score": 100, "completedAt": 10}}, "b": {"lastSolution": {"score": 50, "completedAt": 30}}}, "updatedAt": 100}
+	]`), &task.Participants); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`[
+		{"_id": "a", "title": "A", "settings": {"slug": "A"}},
+		{"_id": "b", "title": "B", "settings": {"slug": "B"}}
+	]`), &task.Problems); err != nil {
+		t.Fatal(err)
+	}
+	srv.EnqueueRanklist(task)
+
+	if polled, err := Poll(ctx, c); !polled || err != nil {
+		t.Fatalf("Poll = %v, %v", polled, err)
+	}
+	if res := task.Result(); res == nil || res.RanklistUpdatedAt != 100 {
+		t.Fatalf("completion = %+v, want ranklistUpdatedAt 100", res)
+	}
+	ranklist, ok := srv.Ranklist(task.TaskId, "public")
+	if !ok {
+		t.Fatal("ranklist was not uploaded")
+	}
+	list := ranklist.Participant.List
+	if len(list) != 2 || list[0].UserId != "bob" || list[0].Columns[0].Content != "150" || list[1].UserId != "alice" {
+		t.Errorf("ranklist = %+v, want bob with 150 ahead of alice", list)
+	}
+}
@@ -0,0 +1,190 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrCancelled = errors.New("task cancelled by runner operator")
+
+type TaskInfo struct {
+	Role string `json:"role"`
+	// Name of the server under servers the task was polled from, empty for the default server
+	Server    string    `json:"server"`
+	TaskId    string    `json:"taskId"`
+	SubjectId string    `json:"subjectId"`
+	Adapter   string    `json:"adapter"`
+	State     string    `json:"state"`
+	StartedAt time.Time `json:"startedAt"`
+	// Elapsed time in seconds
+	Elapsed float64 `json:"elapsed"`
+}
+
+// taskKey identifies a task, task ids are only unique within a server
+type taskKey struct {
+	server string
+	taskId string
+}
+
+type entry struct {
+	info   TaskInfo
+	cancel context.CancelCauseFunc
+}
+
+// Registry tracks the in-flight tasks of a role and whether the role is accepting new tasks
+type Registry struct {
+	role    string
+	mu      sync.Mutex
+	tasks   map[taskKey]*entry
+	paused  bool
+	resumed chan struct{}
+}
+
+var (
+	registriesMu sync.Mutex
+	registries   []*Registry
+)
+
+func New(role string) *Registry {
+	r := &Registry{
+		role:    role,
+		tasks:   make(map[taskKey]*entry),
+		resumed: make(chan struct{}),
+	}
+	close(r.resumed)
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	registries = append(registries, r)
+	return r
+}
+
+// All returns all registries in creation order
+func All() []*Registry {
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	return append([]*Registry(nil), registries...)
+}
+
+// Get returns the registry of the given role
+func Get(role string) (*Registry, bool) {
+	for _, r := range All() {
+		if r.role == role {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+func (r *Registry) Role() string {
+	return r.role
+}
+
+// Add registers a task of server until the returned function is called.
+// The returned context is canceled with ErrCancelled when the task is cancelled through Cancel.
+func (r *Registry) Add(ctx context.Context, server string, taskId string, subjectId string, adapter string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	key := taskKey{server: server, taskId: taskId}
+	r.mu.Lock()
+	r.tasks[key] = &entry{
+		info: TaskInfo{
+			Role:      r.role,
+			Server:    server,
+			TaskId:    taskId,
+			SubjectId: subjectId,
+			Adapter:   adapter,
+			State:     "pending",
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.tasks, key)
+		r.mu.Unlock()
+		cancel(context.Canceled)
+	}
+}
+
+func (r *Registry) SetState(server string, taskId string, state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.tasks[taskKey{server: server, taskId: taskId}]; ok {
+		e.info.State = state
+	}
+}
+
+// List returns the registered tasks, oldest first
+func (r *Registry) List() []TaskInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	tasks := make([]TaskInfo, 0, len(r.tasks))
+	for _, e := range r.tasks {
+		info := e.info
+		info.Elapsed = now.Sub(info.StartedAt).Seconds()
+		tasks = append(tasks, info)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].StartedAt.Before(tasks[j].StartedAt)
+	})
+	return tasks
+}
+
+// Cancel cancels the context of a registered task of server, it reports whether the task was found
+func (r *Registry) Cancel(server string, taskId string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.tasks[taskKey{server: server, taskId: taskId}]
+	if !ok {
+		return false
+	}
+	e.cancel(ErrCancelled)
+	return true
+}
+
+// Pause stops the role from polling new tasks, running tasks are not affected
+func (r *Registry) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.paused {
+		r.paused = true
+		r.resumed = make(chan struct{})
+	}
+}
+
+func (r *Registry) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paused {
+		r.paused = false
+		close(r.resumed)
+	}
+}
+
+func (r *Registry) Paused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+// WaitResumed waits until the role is not paused
+func (r *Registry) WaitResumed(ctx context.Context) error {
+	r.mu.Lock()
+	resumed := r.resumed
+	r.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
+
+// IsCancelled reports whether ctx was canceled through Cancel
+func IsCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrCancelled)
+}
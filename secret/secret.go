@@ -0,0 +1,267 @@
+// Package secret resolves credentials that should not be kept in the config file in plaintext.
+//
+// A config value may be a reference to the secret instead of the secret itself:
+//
+//	env:NAME         the environment variable NAME
+//	file:PATH        the content of PATH
+//	credential:NAME  the systemd credential NAME, passed with LoadCredential=
+//
+// Secrets may also be kept in the YAML file named by secretsFile, which takes precedence over the config.
+// Secret files must not be accessible by group or others.
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var ErrReadOnly = errors.New("secret cannot be written")
+
+// checkPermissions rejects files that group or others may access
+func checkPermissions(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%s is accessible by group or others (mode %04o), use chmod 600", path, perm)
+	}
+	return nil
+}
+
+func credentialPath(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("credential %s: CREDENTIALS_DIRECTORY not set", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func readFile(path string) (string, error) {
+	if err := checkPermissions(path); err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// Resolve returns the secret a reference points to, values that are not references are returned as is
+func Resolve(ref string) (string, error) {
+	scheme, name, ok := strings.Cut(ref, ":")
+	if !ok {
+		return ref, nil
+	}
+	switch scheme {
+	case "env":
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", name)
+		}
+		return value, nil
+	case "file":
+		return readFile(name)
+	case "credential":
+		path, err := credentialPath(name)
+		if err != nil {
+			return "", err
+		}
+		// systemd makes credentials readable by the service user only
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+	return ref, nil
+}
+
+// readSecretsFile reads the secrets file, or returns nil if none is configured
+func readSecretsFile() (*viper.Viper, error) {
+	path := viper.GetString("secretsFile")
+	if path == "" {
+		return nil, nil
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := checkPermissions(path); err != nil {
+		if os.IsNotExist(err) {
+			return v, nil
+		}
+		return nil, err
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Get returns the secret of the config key. The secrets file and referenced files are read again on
+// every call, so a key rotated by another process is picked up.
+func Get(key string) (string, error) {
+	secrets, err := readSecretsFile()
+	if err != nil {
+		return "", err
+	}
+	if secrets != nil && secrets.IsSet(key) {
+		return Resolve(secrets.GetString(key))
+	}
+	return Resolve(viper.GetString(key))
+}
+
+// Reload is like Get but also reads the config file again, for secrets kept in it directly
+func Reload(key string) (string, error) {
+	secrets, err := readSecretsFile()
+	if err != nil {
+		return "", err
+	}
+	if secrets != nil && secrets.IsSet(key) {
+		return Resolve(secrets.GetString(key))
+	}
+	ref := viper.GetString(key)
+	if path := viper.ConfigFileUsed(); path != "" && !isReference(ref) {
+		v := viper.New()
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return "", err
+		}
+		ref = v.GetString(key)
+	}
+	return Resolve(ref)
+}
+
+func isReference(ref string) bool {
+	scheme, _, ok := strings.Cut(ref, ":")
+	return ok && (scheme == "env" || scheme == "file" || scheme == "credential")
+}
+
+// target describes where Store writes a secret
+type target struct {
+	// File of a file: reference
+	file string
+	// Secrets file or config file to update
+	config *viper.Viper
+	path   string
+}
+
+func locate(key string) (*target, error) {
+	secrets, err := readSecretsFile()
+	if err != nil {
+		return nil, err
+	}
+	ref := viper.GetString(key)
+	if secrets != nil {
+		if !secrets.IsSet(key) || !isReference(secrets.GetString(key)) {
+			return &target{config: secrets, path: viper.GetString("secretsFile")}, nil
+		}
+		ref = secrets.GetString(key)
+	}
+	scheme, name, _ := strings.Cut(ref, ":")
+	switch {
+	case !isReference(ref):
+		path := viper.ConfigFileUsed()
+		if path == "" {
+			return nil, fmt.Errorf("%w: no config file in use", ErrReadOnly)
+		}
+		return &target{config: viper.GetViper(), path: path}, nil
+	case scheme == "file":
+		return &target{file: name}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s comes from %s, update it there", ErrReadOnly, key, ref)
+	}
+}
+
+// CheckWritable returns an error if Store cannot write the secret of the config key
+func CheckWritable(key string) error {
+	_, err := locate(key)
+	return err
+}
+
+// Store atomically writes the secret of the config key where it is kept: the file of a file: reference,
+// the secrets file, or the config file. Secrets from env: and credential: references are read-only.
+func Store(key string, value string) error {
+	t, err := locate(key)
+	if err != nil {
+		return err
+	}
+	if t.file != "" {
+		return WriteFile(t.file, value)
+	}
+	t.config.Set(key, value)
+	return writeConfig(t.config, t.path, 0600)
+}
+
+// WriteFile atomically writes a secret to path, which only the current user may read
+func WriteFile(path string, value string) error {
+	return writeAtomic(path, 0600, func(tmp string) error {
+		return os.WriteFile(tmp, []byte(value+"\n"), 0600)
+	})
+}
+
+// SaveRecovery writes a secret that could not be stored to a new file in dir that only the current user
+// may read, so that the operator can move it into place. It returns the path of the file.
+func SaveRecovery(dir string, key string, value string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(dir, key+"-*.recovered")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	// CreateTemp already uses 0600, but the file must not be readable by others even on odd umasks
+	if err := file.Chmod(0600); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	if _, err := file.WriteString(value + "\n"); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// WriteConfig atomically writes the config file. It may hold the runner key in plaintext, so only the
+// current user may read it afterwards.
+func WriteConfig() error {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return errors.New("no config file in use")
+	}
+	return writeConfig(viper.GetViper(), path, 0600)
+}
+
+func writeConfig(v *viper.Viper, path string, perm os.FileMode) error {
+	return writeAtomic(path, perm, v.WriteConfigAs)
+}
+
+// writeAtomic calls write with a temporary file next to path, then renames it to path
+func writeAtomic(path string, perm os.FileMode, write func(tmp string) error) error {
+	dir, base := filepath.Split(path)
+	file, err := os.CreateTemp(dir, "."+base+"-*"+filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	file.Close()
+	defer os.Remove(tmp)
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
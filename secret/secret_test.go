@@ -0,0 +1,155 @@
+package secret
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// useConfig makes viper use a fresh config file with content and mode
+func useConfig(t *testing.T, content string, mode os.FileMode) string {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeSecret(t *testing.T, content string, mode os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func checkMode(t *testing.T, path string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("%s has mode %04o, want 0600", path, perm)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("AZUKIIRO_TEST_SECRET", "from-env")
+	credentials := t.TempDir()
+	t.Setenv("CREDENTIALS_DIRECTORY", credentials)
+	if err := os.WriteFile(filepath.Join(credentials, "key"), []byte("from-credential\n"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	file := writeSecret(t, " from-file\n", 0600)
+	for ref, want := range map[string]string{
+		"plain":                    "plain",
+		"env:AZUKIIRO_TEST_SECRET": "from-env",
+		"file:" + file:             "from-file",
+		"credential:key":           "from-credential",
+		"other:value":              "other:value",
+	} {
+		if got, err := Resolve(ref); err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", ref, got, err, want)
+		}
+	}
+	if _, err := Resolve("env:AZUKIIRO_TEST_MISSING"); err == nil {
+		t.Error("missing environment variable resolved")
+	}
+	if runtime.GOOS != "windows" {
+		if _, err := Resolve("file:" + writeSecret(t, "secret", 0644)); err == nil {
+			t.Error("world-readable file resolved")
+		}
+	}
+}
+
+func TestGetPrefersSecretsFile(t *testing.T) {
+	secrets := writeSecret(t, "runnerKey: from-secrets\n", 0600)
+	useConfig(t, "runnerKey: from-config\nsecretsFile: "+secrets+"\n", 0600)
+	if key, err := Get("runnerKey"); err != nil || key != "from-secrets" {
+		t.Errorf("Get = %q, %v, want the key of the secrets file", key, err)
+	}
+	// The secrets file is read again on every call
+	if err := os.WriteFile(secrets, []byte("runnerKey: rotated\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := Get("runnerKey"); err != nil || key != "rotated" {
+		t.Errorf("Get after rotation = %q, %v", key, err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	t.Run("config file", func(t *testing.T) {
+		path := useConfig(t, "runnerKey: old\n", 0644)
+		if err := Store("runnerKey", "new"); err != nil {
+			t.Fatal(err)
+		}
+		checkMode(t, path)
+		if key, err := Reload("runnerKey"); err != nil || key != "new" {
+			t.Errorf("Reload = %q, %v, want new", key, err)
+		}
+	})
+	t.Run("file reference", func(t *testing.T) {
+		file := writeSecret(t, "old", 0600)
+		path := useConfig(t, "runnerKey: file:"+file+"\n", 0644)
+		if err := Store("runnerKey", "new"); err != nil {
+			t.Fatal(err)
+		}
+		checkMode(t, file)
+		if key, err := Get("runnerKey"); err != nil || key != "new" {
+			t.Errorf("Get = %q, %v, want new", key, err)
+		}
+		// The reference in the config is kept
+		if content, _ := os.ReadFile(path); string(content) != "runnerKey: file:"+file+"\n" {
+			t.Errorf("config was changed to %q", content)
+		}
+	})
+	t.Run("secrets file", func(t *testing.T) {
+		secrets := writeSecret(t, "runnerId: runner\n", 0600)
+		useConfig(t, "secretsFile: "+secrets+"\n", 0644)
+		if err := Store("runnerKey", "new"); err != nil {
+			t.Fatal(err)
+		}
+		checkMode(t, secrets)
+		if key, err := Get("runnerKey"); err != nil || key != "new" {
+			t.Errorf("Get = %q, %v, want new", key, err)
+		}
+		if id, err := Get("runnerId"); err != nil || id != "runner" {
+			t.Errorf("other secrets were lost, runnerId = %q, %v", id, err)
+		}
+	})
+	t.Run("read-only references", func(t *testing.T) {
+		for _, ref := range []string{"env:AZUKIIRO_TEST_SECRET", "credential:key"} {
+			useConfig(t, "runnerKey: "+ref+"\n", 0600)
+			if err := Store("runnerKey", "new"); !errors.Is(err, ErrReadOnly) {
+				t.Errorf("%s: err = %v, want read-only", ref, err)
+			}
+		}
+	})
+}
+
+func TestSaveRecovery(t *testing.T) {
+	path, err := SaveRecovery(filepath.Join(t.TempDir(), "storage"), "runnerKey", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMode(t, path)
+	if content, err := os.ReadFile(path); err != nil || string(content) != "key\n" {
+		t.Errorf("recovery file has %q, %v", content, err)
+	}
+}
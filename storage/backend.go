@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/fedstackjs/azukiiro/transport"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrUnsupportedScheme = errors.New("unsupported storage url scheme")
+	// ErrRangeNotSatisfiable is returned by Backend.Get when offset is past the end of the object
+	ErrRangeNotSatisfiable = errors.New("requested range not satisfiable")
+)
+
+// Object is the content of a stored object, starting at Offset
+type Object struct {
+	Body io.ReadCloser
+	// Offset of the first byte of Body, backends that can not seek return 0 for any requested offset
+	Offset int64
+	// Length of Body in bytes, -1 if unknown
+	Length int64
+}
+
+// Backend reads and writes objects addressed by URL
+type Backend interface {
+	// Get opens the object at url for reading from offset
+	Get(ctx context.Context, url string, offset int64) (*Object, error)
+	// Put replaces the object at url with size bytes read from content
+	Put(ctx context.Context, url string, content io.Reader, size int64) error
+}
+
+var backends = make(map[string]Backend)
+
+func RegisterBackend(scheme string, backend Backend) {
+	if _, ok := backends[scheme]; ok {
+		panic("backend already registered")
+	}
+	backends[scheme] = backend
+}
+
+// GetBackend returns the backend registered for the scheme of rawURL
+func GetBackend(rawURL string) (Backend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	backend, ok := backends[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+	return backend, nil
+}
+
+// checkRemoteURL rejects URLs handed out by the server that do not use http or https. The file: and cas:
+// backends would let the server read or overwrite files of the runner, so they are only used by the
+// local judge and for mirrors and warm lists configured by the operator.
+func checkRemoteURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return nil
+	}
+	return fmt.Errorf("%w: %q is not allowed for urls from the server", ErrUnsupportedScheme, u.Scheme)
+}
+
+func init() {
+	RegisterBackend("http", &httpBackend{})
+	RegisterBackend("https", &httpBackend{})
+	RegisterBackend("file", &fileBackend{})
+	RegisterBackend("cas", &casBackend{})
+}
+
+// httpBackend accesses presigned URLs
+type httpBackend struct{}
+
+func (b *httpBackend) Get(ctx context.Context, url string, offset int64) (*Object, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := transport.Client("storage").Do(req)
+	if err != nil {
+		return nil, err
+	}
+	obj := &Object{Body: res.Body, Length: res.ContentLength}
+	switch {
+	case res.StatusCode == http.StatusPartialContent && offset > 0:
+		obj.Offset = rangeStart(res.Header.Get("Content-Range"))
+	case res.StatusCode == http.StatusOK:
+	case res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		res.Body.Close()
+		return nil, ErrRangeNotSatisfiable
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("download failed: %s", res.Status)
+	}
+	return obj, nil
+}
+
+func (b *httpBackend) Put(ctx context.Context, url string, content io.Reader, size int64) error {
+	// upload content to url as S3 object using PUT
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, io.NopCloser(content))
+	if err != nil {
+		return err
+	}
+	// Presigned URLs do not accept chunked uploads
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := transport.Client("storage").Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Upload failed: %s", res.Status)
+	}
+	return nil
+}
+
+// rangeStart parses the first byte position from a Content-Range header
+func rangeStart(contentRange string) int64 {
+	var start, end int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/", &start, &end); err != nil {
+		return -1
+	}
+	return start
+}
+
+// FileURL returns the file:// URL of a local path
+func FileURL(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	abs = filepath.ToSlash(abs)
+	if !strings.HasPrefix(abs, "/") {
+		// Windows paths start with a volume name
+		abs = "/" + abs
+	}
+	return (&url.URL{Scheme: "file", Path: abs}).String(), nil
+}
+
+// localPath returns the path of a file:// URL
+func localPath(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("file url with remote host %q", u.Host)
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path), nil
+}
+
+// openLocal opens a local file for reading from offset
+func openLocal(path string, offset int64) (*Object, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if offset > info.Size() {
+		file.Close()
+		return nil, ErrRangeNotSatisfiable
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Object{Body: file, Offset: offset, Length: info.Size() - offset}, nil
+}
+
+// writeLocal atomically replaces a local file with content
+func writeLocal(path string, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// SaveFile atomically replaces the local file dst with a copy of src
+func SaveFile(dst string, src string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return writeLocal(dst, file)
+}
+
+// fileBackend accesses local paths through file:// URLs
+type fileBackend struct{}
+
+func (b *fileBackend) Get(ctx context.Context, url string, offset int64) (*Object, error) {
+	path, err := localPath(url)
+	if err != nil {
+		return nil, err
+	}
+	return openLocal(path, offset)
+}
+
+func (b *fileBackend) Put(ctx context.Context, url string, content io.Reader, size int64) error {
+	path, err := localPath(url)
+	if err != nil {
+		return err
+	}
+	return writeLocal(path, content)
+}
+
+// GetCASPath returns the content-addressed directory configured by cas.path, or "" if there is none
+func GetCASPath() string {
+	path := viper.GetString("cas.path")
+	if path == "" {
+		return ""
+	}
+	result, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return result
+}
+
+// CASURL returns the URL of an object in the content-addressed directory
+func CASURL(hash string) string {
+	return "cas:" + hash
+}
+
+// casBackend accesses the content-addressed directory, where objects are named by their sha256 hash.
+// URLs have the form cas:<hash>, a Put to cas: stores the content under its own hash.
+type casBackend struct{}
+
+func (b *casBackend) path(rawURL string) (string, string, error) {
+	dir := GetCASPath()
+	if dir == "" {
+		return "", "", errors.New("cas.path is not configured")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	hash := u.Opaque
+	if strings.ContainsAny(hash, `/\.`) {
+		return "", "", fmt.Errorf("invalid cas url %q", rawURL)
+	}
+	return dir, hash, nil
+}
+
+func (b *casBackend) Get(ctx context.Context, url string, offset int64) (*Object, error) {
+	dir, hash, err := b.path(url)
+	if err != nil {
+		return nil, err
+	}
+	if hash == "" {
+		return nil, fmt.Errorf("invalid cas url %q", url)
+	}
+	return openLocal(filepath.Join(dir, hash), offset)
+}
+
+func (b *casBackend) Put(ctx context.Context, url string, content io.Reader, size int64) error {
+	dir, hash, err := b.path(url)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, "put-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hasher), content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(hasher.Sum(nil))
+	if hash != "" && hash != actual {
+		return ErrHashMismatch
+	}
+	return os.Rename(file.Name(), filepath.Join(dir, actual))
+}
+
+// mirrored returns the cas: URL of hash if the content-addressed directory has it
+func mirrored(hash string) (string, bool) {
+	dir := GetCASPath()
+	if dir == "" {
+		return "", false
+	}
+	if _, err := os.Stat(filepath.Join(dir, hash)); err != nil {
+		return "", false
+	}
+	return CASURL(hash), true
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// setupStorage points the storage at a new temporary directory
+func setupStorage(t *testing.T) {
+	t.Helper()
+	viper.Set("storagePath", t.TempDir())
+	Initialize()
+}
+
+func writeFile(t *testing.T, content string) (string, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return path, hex.EncodeToString(sum[:])
+}
+
+func TestRemoteURLsRejectLocalBackends(t *testing.T) {
+	setupStorage(t)
+	viper.Set("cas.path", t.TempDir())
+	defer viper.Set("cas.path", "")
+	ctx := context.Background()
+	secret, hash := writeFile(t, "runner secret")
+	secretURL, err := FileURL(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "target")
+	targetURL, err := FileURL(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{secretURL, CASURL(hash)} {
+		if _, err := Fetch(ctx, url, hash); !errors.Is(err, ErrUnsupportedScheme) {
+			t.Errorf("Fetch(%s) = %v, want unsupported scheme", url, err)
+		}
+		if err := DownloadFile(ctx, url, hash); !errors.Is(err, ErrUnsupportedScheme) {
+			t.Errorf("DownloadFile(%s) = %v, want unsupported scheme", url, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(GetCachePath(), hash)); err == nil {
+		t.Error("file from a file: url of the server was cached")
+	}
+
+	for _, url := range []string{targetURL, "cas:"} {
+		if err := Upload(ctx, url, []byte("details")); !errors.Is(err, ErrUnsupportedScheme) {
+			t.Errorf("Upload(%s) = %v, want unsupported scheme", url, err)
+		}
+		if err := UploadFile(ctx, url, secret); !errors.Is(err, ErrUnsupportedScheme) {
+			t.Errorf("UploadFile(%s) = %v, want unsupported scheme", url, err)
+		}
+	}
+	if _, err := os.Stat(target); err == nil {
+		t.Error("upload to a file: url of the server was written")
+	}
+}
+
+func TestFetchLocal(t *testing.T) {
+	setupStorage(t)
+	path, hash := writeFile(t, "problem data")
+	cached, err := FetchLocal(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != filepath.Join(GetCachePath(), hash) {
+		t.Errorf("FetchLocal = %s", cached)
+	}
+	if content, _ := os.ReadFile(cached); string(content) != "problem data" {
+		t.Errorf("cached %q", content)
+	}
+}
+
+func TestSaveFile(t *testing.T) {
+	src, _ := writeFile(t, "artifact")
+	dst := filepath.Join(t.TempDir(), "out", "artifact.txt")
+	if err := SaveFile(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(dst); string(content) != "artifact" {
+		t.Errorf("saved %q", content)
+	}
+}
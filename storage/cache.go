@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+type CacheUsage struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+type CacheEntry struct {
+	Hash string `json:"hash"`
+	// Size of the file and its extracted directory
+	Size         int64     `json:"size"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+	LastAccess   time.Time `json:"lastAccess"`
+}
+
+var (
+	pinsMu sync.Mutex
+	// Number of tasks using each cached file
+	pins = make(map[string]int)
+)
+
+type taskContextKey int
+
+const taskKey taskContextKey = iota
+
+// taskScope holds the cache pins and the download budget of a task
+type taskScope struct {
+	mu     sync.Mutex
+	hashes []string
+	// Remaining bytes the task may download, negative for unlimited
+	remaining int64
+}
+
+// WithTask returns a context in which files fetched by Fetch are protected from eviction
+// until the returned function is called, and downloads are limited by download.maxTaskSize
+func WithTask(ctx context.Context) (context.Context, func()) {
+	scope := &taskScope{remaining: -1}
+	if limit, err := ParseSize(viper.GetString("download.maxTaskSize")); err != nil {
+		logrus.Warnln("Invalid download.maxTaskSize:", err)
+	} else if limit > 0 {
+		scope.remaining = limit
+	}
+	return context.WithValue(ctx, taskKey, scope), func() {
+		scope.mu.Lock()
+		defer scope.mu.Unlock()
+		pinsMu.Lock()
+		defer pinsMu.Unlock()
+		for _, hash := range scope.hashes {
+			if pins[hash]--; pins[hash] <= 0 {
+				delete(pins, hash)
+			}
+		}
+		scope.hashes = nil
+	}
+}
+
+func pin(ctx context.Context, hash string) {
+	scope, ok := ctx.Value(taskKey).(*taskScope)
+	if !ok {
+		return
+	}
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	pins[hash]++
+	scope.hashes = append(scope.hashes, hash)
+}
+
+// consume takes n bytes from the download budget of the task in ctx
+func consume(ctx context.Context, n int64) error {
+	scope, ok := ctx.Value(taskKey).(*taskScope)
+	if !ok {
+		return nil
+	}
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	if scope.remaining < 0 {
+		return nil
+	}
+	if n > scope.remaining {
+		scope.remaining = 0
+		return ErrDownloadTooLarge
+	}
+	scope.remaining -= n
+	return nil
+}
+
+// fits reports whether n more bytes fit in the download budget of the task in ctx
+func fits(ctx context.Context, n int64) bool {
+	scope, ok := ctx.Value(taskKey).(*taskScope)
+	if !ok {
+		return true
+	}
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	return scope.remaining < 0 || n <= scope.remaining
+}
+
+// touch records an access to a cached file for LRU eviction
+func touch(hash string) {
+	err := updateManifest(hash, func(manifest *Manifest) {
+		manifest.LastAccess = time.Now()
+	})
+	if err != nil {
+		logrus.Warnln("Failed to update cache access time:", err)
+	}
+}
+
+// ListCache returns the downloaded files in the cache
+func ListCache() ([]CacheEntry, error) {
+	dirEntries, err := os.ReadDir(GetCachePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := make([]CacheEntry, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		// Skip stray files, only files named by their hash are managed
+		if CheckHash(entry.Name()) != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		cached := CacheEntry{
+			Hash:         entry.Name(),
+			Size:         info.Size(),
+			DownloadedAt: info.ModTime(),
+			LastAccess:   info.ModTime(),
+		}
+		if manifest, err := readManifest(entry.Name()); err == nil {
+			cached.Size += manifest.ExtractedSize
+			cached.DownloadedAt = manifest.DownloadedAt
+			cached.LastAccess = manifest.LastAccess
+		}
+		entries = append(entries, cached)
+	}
+	return entries, nil
+}
+
+// GetCacheUsage returns the number and total size of downloaded files, including their extracted directories
+func GetCacheUsage() (*CacheUsage, error) {
+	entries, err := ListCache()
+	if err != nil {
+		return nil, err
+	}
+	usage := &CacheUsage{}
+	for _, entry := range entries {
+		usage.Files++
+		usage.Bytes += entry.Size
+	}
+	return usage, nil
+}
+
+// recentHashes caches the result of RecentHashes
+var recentHashes struct {
+	mu        sync.Mutex
+	updatedAt time.Time
+	hashes    []string
+}
+
+// RecentHashes returns up to limit cached hashes, most recently used first. The list is refreshed at most
+// every 30 seconds since it reads the manifest of every cached file.
+func RecentHashes(limit int) []string {
+	recentHashes.mu.Lock()
+	defer recentHashes.mu.Unlock()
+	if time.Since(recentHashes.updatedAt) >= 30*time.Second {
+		entries, err := ListCache()
+		if err != nil {
+			logrus.Warnln("Failed to list cache:", err)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].LastAccess.After(entries[j].LastAccess)
+		})
+		recentHashes.hashes = make([]string, 0, len(entries))
+		for _, entry := range entries {
+			recentHashes.hashes = append(recentHashes.hashes, entry.Hash)
+		}
+		recentHashes.updatedAt = time.Now()
+	}
+	return recentHashes.hashes[:min(limit, len(recentHashes.hashes))]
+}
+
+// GetFreeSpace returns the free space of the filesystem holding the cache
+func GetFreeSpace() (int64, error) {
+	return freeSpace(GetCachePath())
+}
+
+// ParseSize parses sizes like 1073741824, 512M or 10GiB, units are powers of 1024
+func ParseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	upper := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(value), "B"), "I")
+	shift := 0
+	if n := len(upper); n > 0 {
+		switch upper[n-1] {
+		case 'K':
+			shift = 10
+		case 'M':
+			shift = 20
+		case 'G':
+			shift = 30
+		case 'T':
+			shift = 40
+		}
+		if shift > 0 {
+			upper = upper[:n-1]
+		}
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	return int64(size * float64(int64(1)<<shift)), nil
+}
+
+type CacheBudget struct {
+	// Upper bound of the cache size in bytes, 0 for unlimited
+	MaxSize int64
+	// Lower bound of the free space on the cache filesystem in bytes, 0 for unlimited
+	MinFree int64
+	// Files not accessed for longer than MaxAge are evicted, 0 for unlimited
+	MaxAge time.Duration
+}
+
+// GetCacheBudget reads the cache.maxSize, cache.minFree and cache.maxAge config
+func GetCacheBudget() (*CacheBudget, error) {
+	maxSize, err := ParseSize(viper.GetString("cache.maxSize"))
+	if err != nil {
+		return nil, fmt.Errorf("cache.maxSize: %w", err)
+	}
+	minFree, err := ParseSize(viper.GetString("cache.minFree"))
+	if err != nil {
+		return nil, fmt.Errorf("cache.minFree: %w", err)
+	}
+	maxAge := time.Duration(viper.GetFloat64("cache.maxAge") * float64(time.Second))
+	return &CacheBudget{MaxSize: maxSize, MinFree: minFree, MaxAge: maxAge}, nil
+}
+
+// removeUnpinned removes a cached file and its extracted directory unless a task is using them
+func removeUnpinned(hash string) bool {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	if pins[hash] > 0 {
+		return false
+	}
+	if !removeExtracted(hash) {
+		return false
+	}
+	if err := os.Remove(filepath.Join(GetCachePath(), hash)); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Failed to evict %s: %v", hash, err)
+		return false
+	}
+	os.Remove(manifestFile(hash))
+	return true
+}
+
+// EvictCache removes least recently used files until the cache is within budget,
+// files used by running tasks are kept. It returns the number of bytes freed.
+func EvictCache(ctx context.Context, budget *CacheBudget) (int64, error) {
+	var freed int64
+	pinned := 0
+	over, err := evict(ctx, budget, func(entry CacheEntry) bool {
+		if !removeUnpinned(entry.Hash) {
+			pinned++
+			return false
+		}
+		logging.FromContext(ctx).Infof("Evicted %s (%d bytes)", entry.Hash, entry.Size)
+		freed += entry.Size
+		return true
+	})
+	if over && pinned > 0 {
+		logging.FromContext(ctx).Warnln("Cache is still over budget, remaining files are in use")
+	}
+	return freed, err
+}
+
+// PlanEviction returns the files EvictCache would remove if no task was using them, without removing them
+func PlanEviction(ctx context.Context, budget *CacheBudget) ([]CacheEntry, error) {
+	planned := []CacheEntry{}
+	_, err := evict(ctx, budget, func(entry CacheEntry) bool {
+		planned = append(planned, entry)
+		return true
+	})
+	return planned, err
+}
+
+// evict calls remove on least recently used files until the cache is within budget,
+// remove reports whether the file was removed. It returns whether the cache is still over budget.
+func evict(ctx context.Context, budget *CacheBudget, remove func(CacheEntry) bool) (bool, error) {
+	if budget.MaxSize <= 0 && budget.MinFree <= 0 && budget.MaxAge <= 0 {
+		return false, nil
+	}
+	entries, err := ListCache()
+	if err != nil {
+		return false, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastAccess.Before(entries[j].LastAccess)
+	})
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	var free int64 = -1
+	if budget.MinFree > 0 {
+		free, err = freeSpace(GetCachePath())
+		if err != nil {
+			logging.FromContext(ctx).Warnln("Failed to get free space, only cache.maxSize is enforced:", err)
+			free = -1
+		}
+	}
+	overBudget := func() bool {
+		return (budget.MaxSize > 0 && total > budget.MaxSize) || (free >= 0 && free < budget.MinFree)
+	}
+	expired := func(entry CacheEntry) bool {
+		return budget.MaxAge > 0 && time.Since(entry.LastAccess) > budget.MaxAge
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil || (!overBudget() && !expired(entry)) {
+			// Entries are sorted by access time, so the remaining ones have not expired either
+			break
+		}
+		if !remove(entry) {
+			continue
+		}
+		total -= entry.Size
+		if free >= 0 {
+			free += entry.Size
+		}
+	}
+	return overBudget(), nil
+}
+
+// RunCacheJanitor evicts files from the cache every interval until ctx is done
+func RunCacheJanitor(ctx context.Context, interval time.Duration) {
+	budget, err := GetCacheBudget()
+	if err != nil {
+		logrus.Errorln("Cache janitor disabled:", err)
+		return
+	}
+	if budget.MaxSize <= 0 && budget.MinFree <= 0 && budget.MaxAge <= 0 {
+		return
+	}
+	logrus.Infof("Cache janitor started, max size %d bytes, min free %d bytes, max age %v", budget.MaxSize, budget.MinFree, budget.MaxAge)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := EvictCache(ctx, budget); err != nil {
+			logrus.Warnln("Failed to evict cache:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
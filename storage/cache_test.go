@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for value, want := range map[string]int64{
+		"":           0,
+		"1024":       1024,
+		"512M":       512 << 20,
+		"10GiB":      10 << 30,
+		"1.5k":       1536,
+		" 2 TB ":     2 << 40,
+		"3g":         3 << 30,
+		"100B":       100,
+		"0.5MiB":     512 << 10,
+		"1073741824": 1 << 30,
+	} {
+		got, err := ParseSize(value)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"abc", "-1G", "1X", "G"} {
+		if _, err := ParseSize(value); err == nil {
+			t.Errorf("ParseSize(%q) succeeded", value)
+		}
+	}
+}
+
+// cacheAccessed caches content with the given last access time
+func cacheAccessed(t *testing.T, content string, lastAccess time.Time) string {
+	t.Helper()
+	hash := cacheFile(t, content)
+	info, err := os.Stat(filepath.Join(GetCachePath(), hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeManifest(&Manifest{Hash: hash, Size: info.Size(), ModTime: info.ModTime(), DownloadedAt: lastAccess, LastAccess: lastAccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func cached(hash string) bool {
+	_, err := os.Stat(filepath.Join(GetCachePath(), hash))
+	return err == nil
+}
+
+func TestEvictCacheBySize(t *testing.T) {
+	setupStorage(t)
+	ctx := context.Background()
+	now := time.Now()
+	// Files of 9 bytes each
+	oldest := cacheAccessed(t, "oldest-09", now.Add(-3*time.Hour))
+	older := cacheAccessed(t, "older--09", now.Add(-2*time.Hour))
+	recent := cacheAccessed(t, "recent-09", now.Add(-time.Hour))
+
+	planned, err := PlanEviction(ctx, &CacheBudget{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := []string{}
+	for _, entry := range planned {
+		hashes = append(hashes, entry.Hash)
+	}
+	if !slices.Equal(hashes, []string{oldest, older}) {
+		t.Errorf("planned %v, want the least recently used files first", hashes)
+	}
+	if !cached(oldest) {
+		t.Fatal("PlanEviction removed a file")
+	}
+
+	// Files used by a task are kept, the next one is evicted instead
+	taskCtx, done := WithTask(ctx)
+	pin(taskCtx, oldest)
+	freed, err := EvictCache(ctx, &CacheBudget{MaxSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != 9 || !cached(oldest) || cached(older) || !cached(recent) {
+		t.Errorf("freed %d bytes, cached: oldest %v, older %v, recent %v, want older evicted", freed, cached(oldest), cached(older), cached(recent))
+	}
+	done()
+	if _, err := EvictCache(ctx, &CacheBudget{MaxSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if cached(oldest) || !cached(recent) {
+		t.Errorf("after release cached: oldest %v, recent %v, want only recent", cached(oldest), cached(recent))
+	}
+	if _, err := os.Stat(manifestFile(oldest)); !os.IsNotExist(err) {
+		t.Error("manifest of an evicted file was kept:", err)
+	}
+}
+
+func TestEvictCacheByAge(t *testing.T) {
+	setupStorage(t)
+	now := time.Now()
+	expired := cacheAccessed(t, "expired", now.Add(-48*time.Hour))
+	fresh := cacheAccessed(t, "fresh", now.Add(-time.Hour))
+	if _, err := EvictCache(context.Background(), &CacheBudget{MaxAge: 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if cached(expired) || !cached(fresh) {
+		t.Errorf("cached: expired %v, fresh %v", cached(expired), cached(fresh))
+	}
+}
+
+func TestEvictCacheWithoutBudget(t *testing.T) {
+	setupStorage(t)
+	hash := cacheAccessed(t, "content", time.Now().Add(-1000*time.Hour))
+	if freed, err := EvictCache(context.Background(), &CacheBudget{}); freed != 0 || err != nil || !cached(hash) {
+		t.Errorf("EvictCache without budget = %d, %v", freed, err)
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/fedstackjs/azukiiro/metrics"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -39,6 +42,14 @@ func Initialize() {
 	if err != nil {
 		logrus.Fatalln("Failed to create cache dir:", err)
 	}
+	err = os.MkdirAll(GetManifestPath(), 0700)
+	if err != nil {
+		logrus.Fatalln("Failed to create manifest dir:", err)
+	}
+	err = os.MkdirAll(GetExtractPath(), 0700)
+	if err != nil {
+		logrus.Fatalln("Failed to create extract dir:", err)
+	}
 }
 
 func CreateTemp(pattern string) (*os.File, error) {
@@ -49,70 +60,193 @@ func MkdirTemp(pattern string) (string, error) {
 	return os.MkdirTemp(GetTmpPath(), pattern)
 }
 
-func DownloadFile(ctx context.Context, url string, hash string) error {
-	// get tmp file
-	file, err := CreateTemp("download-" + hash + "-*")
+var (
+	ErrDownloadTooLarge = errors.New("download exceeds the size limit of the task")
+	ErrDownloadStalled  = errors.New("download stalled")
+)
+
+func partPath(hash string) string {
+	return filepath.Join(GetTmpPath(), "download-"+hash+".part")
+}
+
+// downloadLockPath is the lock of the part file, held by the process downloading hash
+func downloadLockPath(hash string) string {
+	return filepath.Join(GetTmpPath(), "download-"+hash+".lock")
+}
+
+// lockDownload locks the download of hash against other processes sharing the storage path,
+// waiting while one of them downloads it
+func lockDownload(ctx context.Context, hash string) (*fileLock, error) {
+	path := downloadLockPath(hash)
+	lock, err := openLock(ctx, path, true, false)
+	if errors.Is(err, ErrLocked) {
+		logging.FromContext(ctx).Println("Waiting for another process downloading", hash)
+		lock, err = openLock(ctx, path, true, true)
+	}
+	return lock, err
+}
+
+// discardPart removes the data kept for resuming the download of hash, unless another process is downloading it
+func discardPart(hash string) {
+	lock, err := openLock(context.Background(), downloadLockPath(hash), true, false)
 	if err != nil {
+		return
+	}
+	os.Remove(partPath(hash))
+	lock.release(true)
+}
+
+func downloadStallTimeout() time.Duration {
+	seconds := viper.GetFloat64("download.stallTimeout")
+	if seconds <= 0 {
+		seconds = 30
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// progressReader resets the stall timer whenever data arrives and charges the task download budget
+type progressReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+		if err := consume(r.ctx, int64(n)); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// DownloadFile downloads url from the server into the cache through the backend of its scheme,
+// verifying its sha256 hash while streaming.
+// Data received before a failure is kept, and the next call resumes with a Range request.
+// Only one process sharing the storage path downloads a file at a time, the others wait for it
+// and use its result.
+func DownloadFile(ctx context.Context, url string, hash string) error {
+	if err := checkRemoteURL(url); err != nil {
 		return err
 	}
-	defer os.Remove(file.Name())
-	defer file.Close()
+	return downloadFile(ctx, url, hash)
+}
 
-	// Download url to tmp file
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// downloadFile is DownloadFile for URLs of any backend
+func downloadFile(ctx context.Context, url string, hash string) error {
+	lock, err := lockDownload(ctx, hash)
+	if err != nil {
+		return err
+	}
+	defer lock.release(true)
+	// The process holding the lock before may have finished the download
+	if _, err := os.Stat(filepath.Join(GetCachePath(), hash)); err == nil {
+		return nil
+	}
+	path := partPath(hash)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return err
 	}
-	res, err := http.DefaultClient.Do(req)
+	defer file.Close()
+	// Rehash the data kept from the previous attempt, which also moves to the end of the file
+	hasher := sha256.New()
+	offset, err := io.Copy(hasher, file)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
 
-	n, err := io.Copy(file, res.Body)
-	if err != nil {
+	timeout := downloadStallTimeout()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	timer := time.AfterFunc(timeout, func() { cancel(ErrDownloadStalled) })
+	defer timer.Stop()
+	stalled := func(err error) error {
+		if errors.Is(context.Cause(ctx), ErrDownloadStalled) {
+			return ErrDownloadStalled
+		}
 		return err
 	}
-	logrus.Println("Downloaded", n, "bytes")
 
-	_, err = file.Seek(0, io.SeekStart)
+	backend, err := GetBackend(url)
 	if err != nil {
 		return err
 	}
-	// Calculate sha256 hash
-	hasher := sha256.New()
-	_, err = io.Copy(hasher, file)
+	obj, err := backend.Get(ctx, url, offset)
+	if errors.Is(err, ErrRangeNotSatisfiable) {
+		// The kept data does not belong to this object, start over on the next attempt
+		os.Remove(path)
+		return fmt.Errorf("download failed: %w", err)
+	}
 	if err != nil {
-		return err
+		return stalled(err)
+	}
+	defer obj.Body.Close()
+	switch {
+	case obj.Offset == offset:
+		if offset > 0 {
+			logging.FromContext(ctx).Println("Resuming download at", offset, "bytes")
+		}
+	case obj.Offset == 0:
+		// The backend does not support ranges, start over
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		hasher.Reset()
+		offset = 0
+	default:
+		os.Remove(path)
+		return fmt.Errorf("download failed: unexpected range starting at %d", obj.Offset)
+	}
+	if obj.Length > 0 && !fits(ctx, obj.Length) {
+		return ErrDownloadTooLarge
 	}
+
+	n, err := io.Copy(io.MultiWriter(file, hasher), &progressReader{
+		ctx:     ctx,
+		reader:  obj.Body,
+		timer:   timer,
+		timeout: timeout,
+	})
+	metrics.DownloadBytes.Add(float64(n))
+	if err != nil {
+		return stalled(err)
+	}
+	logging.FromContext(ctx).Println("Downloaded", n, "bytes")
+
 	fileHash := hex.EncodeToString(hasher.Sum(nil))
 	if fileHash != hash {
-		logrus.Println("Hash mismatch:", fileHash, "!=", hash)
-		return fmt.Errorf("file hash mismatch")
+		logging.FromContext(ctx).Println("Hash mismatch:", fileHash, "!=", hash)
+		os.Remove(path)
+		return ErrHashMismatch
+	}
+	if err := file.Close(); err != nil {
+		return err
 	}
 
-	// Move tmp file to cache
-	cachePath := GetCachePath()
-	filePath := filepath.Join(cachePath, hash)
-	return os.Rename(file.Name(), filePath)
-}
-
-func PrepareFile(ctx context.Context, url string, hash string) (string, error) {
-	logrus.Println("Preparing file:", url, hash)
+	// Move downloaded file to cache
 	cachePath := GetCachePath()
 	filePath := filepath.Join(cachePath, hash)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		// Download file with retry
-		retry := 3
-		for retry > 0 {
-			err := DownloadFile(ctx, url, hash)
-			if err != nil {
-				logrus.Println("Failed to download file:", err)
-				retry--
-				continue
-			}
-			break
-		}
+	if err := os.Rename(path, filePath); err != nil {
+		return err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
 	}
-	return filePath, nil
+	now := time.Now()
+	return writeManifest(&Manifest{
+		Hash:         hash,
+		Size:         info.Size(),
+		ModTime:      info.ModTime(),
+		DownloadedAt: now,
+		LastAccess:   now,
+		VerifiedAt:   now,
+	})
 }
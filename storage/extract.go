@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fedstackjs/azukiiro/archive"
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	extractsMu sync.Mutex
+	// Number of tasks using each extracted directory
+	extractRefs = make(map[string]int)
+	extracts    singleflight.Group
+)
+
+func GetExtractPath() string {
+	return filepath.Join(GetRootPath(), "extract")
+}
+
+// Extract returns a directory with the content of the zip archive at path, which is shared with
+// other tasks and must not be modified. Archives in the cache are extracted once into a read-only
+// directory that is kept until the archive is evicted, other archives are extracted into a
+// temporary directory. The returned function must be called once the directory is no longer used.
+func Extract(ctx context.Context, path string) (string, func(), error) {
+	hash := filepath.Base(path)
+	if abs, err := filepath.Abs(path); err != nil || filepath.Dir(abs) != GetCachePath() {
+		return extractTemp(ctx, path)
+	}
+
+	extractsMu.Lock()
+	extractRefs[hash]++
+	extractsMu.Unlock()
+	release := func() {
+		extractsMu.Lock()
+		defer extractsMu.Unlock()
+		if extractRefs[hash]--; extractRefs[hash] <= 0 {
+			delete(extractRefs, hash)
+		}
+	}
+
+	dir := filepath.Join(GetExtractPath(), hash)
+	_, err, _ := extracts.Do(hash, func() (any, error) {
+		// Directories are renamed into place once complete, so an existing one can be used as is
+		if _, err := os.Stat(dir); err == nil {
+			return nil, nil
+		}
+		return nil, extractShared(ctx, path, hash, dir)
+	})
+	if err != nil {
+		release()
+		return "", nil, err
+	}
+	return dir, release, nil
+}
+
+func extractShared(ctx context.Context, path string, hash string, dir string) error {
+	logging.FromContext(ctx).Infof("Extracting %s to %s", hash, dir)
+	tmp, err := MkdirTemp("extract-" + hash + "-*")
+	if err != nil {
+		return err
+	}
+	if err := archive.ExtractZip(path, tmp, archive.ProblemLimits()); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to extract archive: %w", err)
+	}
+	size, err := makeReadOnly(tmp)
+	if err != nil {
+		removeTree(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		removeTree(tmp)
+		return err
+	}
+	err = updateManifest(hash, func(manifest *Manifest) {
+		manifest.ExtractedSize = size
+	})
+	if err != nil {
+		logging.FromContext(ctx).Warnln("Failed to record extracted size:", err)
+	}
+	return nil
+}
+
+func extractTemp(ctx context.Context, path string) (string, func(), error) {
+	dir, err := MkdirTemp("problem-*")
+	if err != nil {
+		return "", nil, err
+	}
+	logging.FromContext(ctx).Infof("Extracting %s to %s", path, dir)
+	if err := archive.ExtractZip(path, dir, archive.ProblemLimits()); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("failed to extract archive: %w", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// makeReadOnly removes write permissions from a directory tree and returns the total size of its files
+func makeReadOnly(root string) (int64, error) {
+	var size int64
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Directories are locked after the walk, so their entries can still be changed
+			dirs = append(dirs, path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return os.Chmod(path, info.Mode().Perm()&^0222)
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i], 0555); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// removeTree removes a directory tree made read-only by makeReadOnly
+func removeTree(root string) error {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			os.Chmod(path, 0700)
+		} else {
+			os.Chmod(path, 0600)
+		}
+		return nil
+	})
+	return os.RemoveAll(root)
+}
+
+// removeExtracted removes the extracted directory of a cached file unless a task is using it,
+// it reports whether the directory is gone
+func removeExtracted(hash string) bool {
+	extractsMu.Lock()
+	defer extractsMu.Unlock()
+	if extractRefs[hash] > 0 {
+		return false
+	}
+	dir := filepath.Join(GetExtractPath(), hash)
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		return true
+	}
+	if err := removeTree(dir); err != nil {
+		logrus.Warnf("Failed to remove extracted %s: %v", hash, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeZipFile writes a zip archive holding a single file and returns its path
+func writeZipFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "problem.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := zip.NewWriter(file)
+	f, err := w.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractShared(t *testing.T) {
+	setupStorage(t)
+	ctx := context.Background()
+	cached, err := FetchLocal(ctx, writeZipFile(t, "data.txt", "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, release, err := Extract(ctx, cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, releaseOther, err := Extract(ctx, cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != other {
+		t.Errorf("archive extracted twice, to %s and %s", dir, other)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "data.txt")); err != nil || string(content) != "data" {
+		t.Fatalf("data.txt = %q, %v", content, err)
+	}
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(filepath.Join(dir, "data.txt")); err != nil || info.Mode().Perm()&0222 != 0 {
+			t.Errorf("shared file is writable: %v, %v", info.Mode(), err)
+		}
+	}
+	manifest, err := readManifest(filepath.Base(cached))
+	if err != nil || manifest.ExtractedSize != 4 {
+		t.Errorf("manifest = %+v, %v, want the extracted size", manifest, err)
+	}
+
+	// The directory is kept while any task uses it
+	hash := filepath.Base(cached)
+	release()
+	if removeExtracted(hash) {
+		t.Error("directory in use was removed")
+	}
+	releaseOther()
+	if !removeExtracted(hash) {
+		t.Error("unused directory was not removed")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("extracted directory still exists: %v", err)
+	}
+}
+
+func TestExtractTemp(t *testing.T) {
+	setupStorage(t)
+	dir, release, err := Extract(context.Background(), writeZipFile(t, "data.txt", "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dir) != GetTmpPath() {
+		t.Errorf("archive outside of the cache extracted to %s, want a temporary directory", dir)
+	}
+	release()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("temporary directory still exists: %v", err)
+	}
+}
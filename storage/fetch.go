@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fedstackjs/azukiiro/logging"
+	"github.com/fedstackjs/azukiiro/metrics"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrHashMismatch = errors.New("file hash mismatch")
+	ErrInvalidHash  = errors.New("invalid sha256 hash")
+)
+
+var downloads singleflight.Group
+
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// CheckHash returns an error unless hash is a sha256 hash of 64 lowercase hex characters. Hashes name
+// files in the cache, so anything else could reach paths outside of it.
+func CheckHash(hash string) error {
+	if !sha256Hex.MatchString(hash) {
+		return fmt.Errorf("%w %q", ErrInvalidHash, hash)
+	}
+	return nil
+}
+
+// verifyAfter returns the time after which a cached file is rehashed even if it looks unchanged, 0 if never
+func verifyAfter() time.Duration {
+	if !viper.IsSet("cache.verifyAfter") {
+		return 7 * 24 * time.Hour
+	}
+	return time.Duration(viper.GetFloat64("cache.verifyAfter") * float64(time.Second))
+}
+
+// verifyCached checks a cached file against its manifest, rehashing it if the file changed since it
+// was verified, if it was verified longer than cache.verifyAfter ago, or if force is set. It returns
+// false if the file is missing or corrupted.
+func verifyCached(ctx context.Context, hash string, force bool) (bool, error) {
+	filePath := filepath.Join(GetCachePath(), hash)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	manifest, err := readManifest(hash)
+	if err == nil && manifest.Hash == hash && manifest.matches(info) && !manifest.stale(verifyAfter()) && !force {
+		return true, nil
+	}
+	actual, err := HashFile(filePath)
+	if err != nil {
+		return false, err
+	}
+	if actual != hash {
+		logging.FromContext(ctx).Warnf("Cached file %s is corrupted, got hash %s", hash, actual)
+		removeCached(hash)
+		return false, nil
+	}
+	now := time.Now()
+	if manifest == nil || manifest.Hash != hash {
+		// Files cached before manifests were introduced
+		manifest = &Manifest{Hash: hash, DownloadedAt: info.ModTime(), LastAccess: now}
+	}
+	manifest.Size = info.Size()
+	manifest.ModTime = info.ModTime()
+	manifest.VerifiedAt = now
+	if err := writeManifest(manifest); err != nil {
+		logging.FromContext(ctx).Warnln("Failed to write cache manifest:", err)
+	}
+	return true, nil
+}
+
+// VerifyCached rehashes a cached file, a corrupted file is removed. It returns false if the file is missing or corrupted.
+func VerifyCached(ctx context.Context, hash string) (bool, error) {
+	if err := CheckHash(hash); err != nil {
+		return false, err
+	}
+	return verifyCached(ctx, hash, true)
+}
+
+func removeCached(hash string) {
+	if CheckHash(hash) != nil {
+		return
+	}
+	removeExtracted(hash)
+	os.Remove(filepath.Join(GetCachePath(), hash))
+	os.Remove(manifestFile(hash))
+}
+
+// download fetches url into the cache with retries
+func download(ctx context.Context, url string, hash string) error {
+	attempts := viper.GetInt("download.attempts")
+	if attempts <= 0 {
+		attempts = 3
+	}
+	delay := time.Second
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = downloadFile(ctx, url, hash); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrDownloadTooLarge) {
+			discardPart(hash)
+			return err
+		}
+		if attempt < attempts {
+			wait := delay/2 + rand.N(delay/2+1)
+			logging.FromContext(ctx).Warnf("Failed to download file, retrying in %v: %v", wait.Round(time.Millisecond), err)
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			delay *= 2
+		}
+	}
+	discardPart(hash)
+	return fmt.Errorf("download %s failed after %d attempts: %w", hash, attempts, err)
+}
+
+// Fetch returns the path of the cached file with the given sha256 hash, downloading it from url if needed,
+// or from the content-addressed directory if it has the file. The url comes from the server and must use
+// http or https.
+// Concurrent fetches of the same hash share a single download. With WithTask, the file is
+// protected from eviction until the task is finished.
+func Fetch(ctx context.Context, url string, hash string) (string, error) {
+	if err := checkRemoteURL(url); err != nil {
+		return "", err
+	}
+	return fetch(ctx, url, hash)
+}
+
+// fetch is Fetch for URLs of any backend, such as local files and warm lists of the operator
+func fetch(ctx context.Context, url string, hash string) (string, error) {
+	if err := CheckHash(hash); err != nil {
+		return "", err
+	}
+	logging.FromContext(ctx).Println("Fetching file:", hash)
+	filePath := filepath.Join(GetCachePath(), hash)
+	// Pin before checking the cache, so the janitor can not evict the file in between
+	pin(ctx, hash)
+	ok, err := verifyCached(ctx, hash, viper.GetBool("cache.verifyHits"))
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		metrics.CacheHits.Inc()
+		touch(hash)
+		return filePath, nil
+	}
+	metrics.CacheMisses.Inc()
+
+	// The download is shared by every task fetching the file and outlives a caller that gives up, so it
+	// runs in a context of its own. Its budget of download.maxTaskSize stops files no task may download
+	// early, each caller then charges the file to its own budget.
+	result := downloads.DoChan(hash, func() (any, error) {
+		ctx, done := WithTask(logging.WithTask(context.Background(), logrus.Fields{"hash": hash}))
+		defer done()
+		if mirror, ok := mirrored(hash); ok {
+			err := downloadFile(ctx, mirror, hash)
+			if err == nil {
+				return nil, nil
+			}
+			discardPart(hash)
+			logging.FromContext(ctx).Warnln("Failed to copy file from cas.path, downloading it instead:", err)
+		}
+		return nil, download(ctx, url, hash)
+	})
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return "", res.Err
+		}
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if err := consume(ctx, info.Size()); err != nil {
+		return "", fmt.Errorf("%s of %d bytes: %w", hash, info.Size(), err)
+	}
+	return filePath, nil
+}
+
+// PrepareFile is the former name of Fetch.
+//
+// Deprecated: use Fetch.
+func PrepareFile(ctx context.Context, url string, hash string) (string, error) {
+	return Fetch(ctx, url, hash)
+}
+
+// FetchLocal caches a local file through its file:// URL, so it is used like a downloaded file
+func FetchLocal(ctx context.Context, path string) (string, error) {
+	url, err := FileURL(path)
+	if err != nil {
+		return "", err
+	}
+	hash, err := HashFile(path)
+	if err != nil {
+		return "", err
+	}
+	return fetch(ctx, url, hash)
+}
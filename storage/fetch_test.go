@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestInvalidHashRejected(t *testing.T) {
+	setupStorage(t)
+	ctx := context.Background()
+	outside := filepath.Join(GetRootPath(), "outside")
+	if err := os.WriteFile(outside, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{"../outside", "", "ABCDEF", "0123456789abcdef0123456789abcdef0123456789abcdef0123456789ABCDEF"} {
+		if _, err := VerifyCached(ctx, hash); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("VerifyCached(%q) = %v, want invalid hash", hash, err)
+		}
+		if _, err := Fetch(ctx, "https://example.com/file", hash); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("Fetch(%q) = %v, want invalid hash", hash, err)
+		}
+	}
+	removeCached("../outside")
+	if _, err := os.Stat(outside); err != nil {
+		t.Error("file outside of the cache was removed:", err)
+	}
+}
+
+// cacheFile puts content into the cache as if it had been downloaded
+func cacheFile(t *testing.T, content string) string {
+	t.Helper()
+	src, hash := writeFile(t, content)
+	if err := os.Rename(src, filepath.Join(GetCachePath(), hash)); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// tamper replaces a cached file with content of the same size and modification time
+func tamper(t *testing.T, hash string, content string) {
+	t.Helper()
+	path := filepath.Join(GetCachePath(), hash)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyCachedAfterAge(t *testing.T) {
+	setupStorage(t)
+	defer viper.Set("cache.verifyAfter", nil)
+	ctx := context.Background()
+	hash := cacheFile(t, "original")
+	if ok, err := VerifyCached(ctx, hash); !ok || err != nil {
+		t.Fatalf("VerifyCached = %v, %v", ok, err)
+	}
+	manifest, err := readManifest(hash)
+	if err != nil || manifest.VerifiedAt.IsZero() {
+		t.Fatalf("manifest = %+v, %v, want verification time", manifest, err)
+	}
+
+	// An unchanged looking file is trusted until it was verified cache.verifyAfter ago
+	tamper(t, hash, "modified")
+	viper.Set("cache.verifyAfter", 3600)
+	if ok, err := verifyCached(ctx, hash, false); !ok || err != nil {
+		t.Fatalf("verifyCached of a recently verified file = %v, %v", ok, err)
+	}
+	manifest.VerifiedAt = time.Now().Add(-2 * time.Hour)
+	if err := writeManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := verifyCached(ctx, hash, false); ok || err != nil {
+		t.Fatalf("verifyCached of a stale corrupted file = %v, %v", ok, err)
+	}
+	if _, err := os.Stat(filepath.Join(GetCachePath(), hash)); !os.IsNotExist(err) {
+		t.Error("corrupted file was not removed:", err)
+	}
+}
+
+func TestVerifyCachedWithoutVerificationTime(t *testing.T) {
+	setupStorage(t)
+	ctx := context.Background()
+	hash := cacheFile(t, "original")
+	path := filepath.Join(GetCachePath(), hash)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Manifests written before verification times were recorded
+	if err := writeManifest(&Manifest{Hash: hash, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		t.Fatal(err)
+	}
+	tamper(t, hash, "modified")
+	if ok, err := verifyCached(ctx, hash, false); ok || err != nil {
+		t.Fatalf("verifyCached = %v, %v, want corrupted", ok, err)
+	}
+}
+
+func TestListCacheSkipsStrayFiles(t *testing.T) {
+	setupStorage(t)
+	hash := cacheFile(t, "content")
+	if err := os.WriteFile(filepath.Join(GetCachePath(), "stray"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ListCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Hash != hash {
+		t.Errorf("ListCache = %+v, want only %s", entries, hash)
+	}
+}
+
+func TestFetchChargesEveryTask(t *testing.T) {
+	setupStorage(t)
+	defer viper.Set("download.maxTaskSize", nil)
+	content := "shared content"
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	// Only the task joining the download has a budget too small for the file
+	viper.Set("download.maxTaskSize", "4")
+	small, doneSmall := WithTask(context.Background())
+	defer doneSmall()
+	viper.Set("download.maxTaskSize", "")
+	large, doneLarge := WithTask(context.Background())
+	defer doneLarge()
+
+	largeErr := make(chan error, 1)
+	go func() {
+		_, err := Fetch(large, server.URL, hash)
+		largeErr <- err
+	}()
+	<-requested
+	smallErr := make(chan error, 1)
+	go func() {
+		_, err := Fetch(small, server.URL, hash)
+		smallErr <- err
+	}()
+	// Give the second fetch time to join the download
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	if err := <-largeErr; err != nil {
+		t.Errorf("task starting the download: %v", err)
+	}
+	if err := <-smallErr; !errors.Is(err, ErrDownloadTooLarge) {
+		t.Errorf("task joining the download: err = %v, want too large", err)
+	}
+
+	// Downloads of a task add up, cache hits are free
+	viper.Set("download.maxTaskSize", "20")
+	ctx, done := WithTask(context.Background())
+	defer done()
+	if _, err := Fetch(ctx, server.URL, hash); err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range []string{"first content", "other content"} {
+		path, hash := writeFile(t, content)
+		url, err := FileURL(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fetch(ctx, url, hash); i == 0 && err != nil {
+			t.Fatal(err)
+		} else if i == 1 && !errors.Is(err, ErrDownloadTooLarge) {
+			t.Errorf("second download: err = %v, want too large", err)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package storage
+
+import "golang.org/x/sys/unix"
+
+func freeSpace(path string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.F_bavail) * int64(stat.F_bsize), nil
+}
@@ -0,0 +1,15 @@
+package storage
+
+import "golang.org/x/sys/windows"
+
+func freeSpace(path string) (int64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return int64(available), nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrLocked is returned when another process holds a conflicting lock on a file of the storage path
+var ErrLocked = errors.New("locked by another process")
+
+const lockRetryInterval = 200 * time.Millisecond
+
+// fileLock is an advisory lock shared by the processes using the same storage path
+type fileLock struct {
+	file *os.File
+}
+
+// openLock creates and locks path. If another process holds a conflicting lock, it waits until ctx is
+// done when wait is set, and returns ErrLocked otherwise.
+func openLock(ctx context.Context, path string, exclusive bool, wait bool) (*fileLock, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		err = lockFile(file, exclusive)
+		if err == nil {
+			// The previous holder may have removed the file, then the lock does not protect path
+			info, statErr := os.Stat(path)
+			fileInfo, fileErr := file.Stat()
+			if statErr == nil && fileErr == nil && os.SameFile(info, fileInfo) {
+				return &fileLock{file: file}, nil
+			}
+			file.Close()
+			continue
+		}
+		file.Close()
+		if !errors.Is(err, ErrLocked) || !wait {
+			return nil, err
+		}
+		timer := time.NewTimer(lockRetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// release unlocks the file, removing it first if remove is set. Where open files can not be removed,
+// the file is kept for the next holder.
+func (l *fileLock) release(remove bool) {
+	if remove {
+		os.Remove(l.file.Name())
+	}
+	unlockFile(l.file)
+	l.file.Close()
+}
+
+// storageLockPath is locked shared by processes using the storage path and exclusively by maintenance
+func storageLockPath() string {
+	return filepath.Join(GetRootPath(), "storage.lock")
+}
+
+// Hold marks the storage path as used until ctx is done, so maintenance commands do not remove files
+// in use. It waits for a running maintenance command to finish.
+func Hold(ctx context.Context) error {
+	lock, err := openLock(ctx, storageLockPath(), false, false)
+	if errors.Is(err, ErrLocked) {
+		logrus.Infoln("Waiting for storage maintenance to finish")
+		lock, err = openLock(ctx, storageLockPath(), false, true)
+	}
+	if err != nil {
+		return err
+	}
+	context.AfterFunc(ctx, func() { lock.release(false) })
+	return nil
+}
+
+// LockMaintenance locks the storage path for a command removing files, it fails with ErrLocked while
+// a process holds the storage path. The returned function releases the lock.
+func LockMaintenance() (func(), error) {
+	lock, err := openLock(context.Background(), storageLockPath(), true, false)
+	if errors.Is(err, ErrLocked) {
+		return nil, fmt.Errorf("storage path %s is used by a running runner, stop it first: %w", GetRootPath(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() { lock.release(false) }, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !openbsd && !netbsd && !windows
+
+package storage
+
+import "os"
+
+// Without file locks, the processes sharing a storage path are not coordinated
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
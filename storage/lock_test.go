@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenLock(t *testing.T) {
+	setupStorage(t)
+	ctx := context.Background()
+	path := filepath.Join(GetTmpPath(), "test.lock")
+	held, err := openLock(ctx, path, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openLock(ctx, path, false, false); !errors.Is(err, ErrLocked) {
+		t.Fatalf("openLock of a held lock = %v, want locked", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 3*lockRetryInterval)
+	defer cancel()
+	if _, err := openLock(waitCtx, path, true, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiting openLock = %v, want deadline exceeded", err)
+	}
+
+	// A waiter must not lock the removed file of the previous holder
+	acquired := make(chan *fileLock)
+	go func() {
+		lock, err := openLock(ctx, path, true, true)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- lock
+	}()
+	time.Sleep(lockRetryInterval / 2)
+	held.release(true)
+	lock := <-acquired
+	defer lock.release(true)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := lock.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(info, fileInfo) {
+		t.Error("lock is not held on the file at its path")
+	}
+}
+
+func TestDownloadWaitsForOtherProcess(t *testing.T) {
+	setupStorage(t)
+	ctx := context.Background()
+	src, hash := writeFile(t, "content")
+	url, err := FileURL(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stands in for another process downloading the file
+	other, err := openLock(ctx, downloadLockPath(hash), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := []byte("unrelated")
+	if err := os.WriteFile(partPath(hash), partial, 0600); err != nil {
+		t.Fatal(err)
+	}
+	discardPart(hash)
+	if _, err := os.Stat(partPath(hash)); err != nil {
+		t.Fatal("part file of another process was discarded:", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- downloadFile(ctx, url, hash) }()
+	select {
+	case err := <-done:
+		t.Fatal("download did not wait for the lock:", err)
+	case <-time.After(2 * lockRetryInterval):
+	}
+	// The other process finishes the download
+	if err := os.Rename(src, filepath.Join(GetCachePath(), hash)); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(partPath(hash))
+	other.release(true)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(partPath(hash)); !os.IsNotExist(err) {
+		t.Error("download started although the file was cached:", err)
+	}
+}
+
+func TestMaintenanceLock(t *testing.T) {
+	setupStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := Hold(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Several processes may use the storage path at once
+	other, otherCancel := context.WithCancel(context.Background())
+	if err := Hold(other); err != nil {
+		t.Fatal(err)
+	}
+	otherCancel()
+	if _, err := LockMaintenance(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("LockMaintenance while held = %v, want locked", err)
+	}
+	cancel()
+	var unlock func()
+	for deadline := time.Now().Add(time.Second); ; {
+		var err error
+		// Holds are released in the background once their context is done
+		if unlock, err = LockMaintenance(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("LockMaintenance after release:", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	held := make(chan error)
+	waitCtx, waitCancel := context.WithCancel(context.Background())
+	defer waitCancel()
+	go func() { held <- Hold(waitCtx) }()
+	select {
+	case err := <-held:
+		t.Fatal("Hold did not wait for maintenance:", err)
+	case <-time.After(2 * lockRetryInterval):
+	}
+	unlock()
+	if err := <-held; err != nil {
+		t.Fatal(err)
+	}
+}
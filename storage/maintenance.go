@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type AreaUsage struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+func walkUsage(root string) (int, int64, error) {
+	files, bytes := 0, int64(0)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Entries may be removed by running tasks while walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	return files, bytes, err
+}
+
+// DiskUsage returns the number and size of files in each directory of the storage path, such as cache and tmp
+func DiskUsage() ([]AreaUsage, error) {
+	entries, err := os.ReadDir(GetRootPath())
+	if err != nil {
+		return nil, err
+	}
+	areas := make([]AreaUsage, 0, len(entries))
+	for _, entry := range entries {
+		files, bytes, err := walkUsage(filepath.Join(GetRootPath(), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		areas = append(areas, AreaUsage{Name: entry.Name(), Files: files, Bytes: bytes})
+	}
+	return areas, nil
+}
+
+type TmpEntry struct {
+	Name    string    `json:"name"`
+	Bytes   int64     `json:"bytes"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// StaleTmp returns the entries of the tmp directory not modified for longer than age, oldest first
+func StaleTmp(age time.Duration) ([]TmpEntry, error) {
+	entries, err := os.ReadDir(GetTmpPath())
+	if err != nil {
+		return nil, err
+	}
+	stale := []TmpEntry{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) <= age {
+			continue
+		}
+		_, bytes, err := walkUsage(filepath.Join(GetTmpPath(), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, TmpEntry{Name: entry.Name(), Bytes: bytes, ModTime: info.ModTime()})
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].ModTime.Before(stale[j].ModTime)
+	})
+	return stale, nil
+}
+
+// RemoveTmp removes an entry of the tmp directory, including read-only directories left by extraction
+func RemoveTmp(name string) error {
+	if name == "." || !filepath.IsLocal(name) || filepath.Base(name) != name {
+		return fmt.Errorf("invalid tmp entry %q", name)
+	}
+	return removeTree(filepath.Join(GetTmpPath(), name))
+}
+
+// FormatSize formats a size in bytes with a binary unit, such as 1.5 GiB
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGT"[exp])
+}
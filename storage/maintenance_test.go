@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaleTmp(t *testing.T) {
+	setupStorage(t)
+	old := filepath.Join(GetTmpPath(), "old")
+	older := filepath.Join(GetTmpPath(), "older")
+	for _, dir := range []string{old, older, filepath.Join(GetTmpPath(), "new")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	os.Chtimes(old, now, now.Add(-2*time.Hour))
+	os.Chtimes(older, now, now.Add(-3*time.Hour))
+
+	stale, err := StaleTmp(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 2 || stale[0].Name != "older" || stale[1].Name != "old" {
+		t.Fatalf("stale entries = %+v, want older and old", stale)
+	}
+	if stale[0].Bytes != 4 {
+		t.Errorf("size of older = %d, want 4", stale[0].Bytes)
+	}
+}
+
+func TestRemoveTmp(t *testing.T) {
+	setupStorage(t)
+	for _, name := range []string{"", ".", "..", "../cache", "a/b"} {
+		if err := RemoveTmp(name); err == nil {
+			t.Errorf("RemoveTmp(%q) succeeded", name)
+		}
+	}
+	// Extracted directories are read-only
+	dir := filepath.Join(GetTmpPath(), "extracted")
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "file"), []byte("data"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(filepath.Join(dir, "sub"), 0555)
+	os.Chmod(dir, 0555)
+	if err := RemoveTmp("extracted"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("tmp entry still exists: %v", err)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	setupStorage(t)
+	if err := os.WriteFile(filepath.Join(GetTmpPath(), "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	areas, err := DiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, area := range areas {
+		if area.Name == filepath.Base(GetTmpPath()) {
+			if area.Files != 1 || area.Bytes != 4 {
+				t.Errorf("tmp usage = %+v, want 1 file of 4 bytes", area)
+			}
+			return
+		}
+	}
+	t.Errorf("tmp missing from %+v", areas)
+}
+
+func TestLockMaintenance(t *testing.T) {
+	setupStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := Hold(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockMaintenance(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("LockMaintenance while held = %v, want ErrLocked", err)
+	}
+	cancel()
+	// The lock is released in the background once ctx is done
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		unlock, err := LockMaintenance()
+		if err == nil {
+			unlock()
+			return
+		}
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			t.Fatalf("LockMaintenance after release = %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Manifest records what was verified about a cached file
+type Manifest struct {
+	Hash         string    `json:"hash"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"modTime"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+	LastAccess   time.Time `json:"lastAccess"`
+	// Time the content was last hashed, zero for manifests written before it was recorded
+	VerifiedAt time.Time `json:"verifiedAt,omitempty"`
+	// Size of the extracted problem directory, 0 if the file was never extracted
+	ExtractedSize int64 `json:"extractedSize,omitempty"`
+}
+
+// Serializes read-modify-write updates of manifests
+var manifestMu sync.Mutex
+
+func GetManifestPath() string {
+	return filepath.Join(GetRootPath(), "manifest")
+}
+
+func manifestFile(hash string) string {
+	return filepath.Join(GetManifestPath(), hash+".json")
+}
+
+func readManifest(hash string) (*Manifest, error) {
+	if err := CheckHash(hash); err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(manifestFile(hash))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func writeManifest(manifest *Manifest) error {
+	if err := CheckHash(manifest.Hash); err != nil {
+		return err
+	}
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(GetManifestPath(), manifest.Hash+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), manifestFile(manifest.Hash))
+}
+
+// matches reports whether info still describes the file recorded in the manifest
+func (m *Manifest) matches(info os.FileInfo) bool {
+	return m.Size == info.Size() && m.ModTime.Equal(info.ModTime())
+}
+
+// stale reports whether the content was last hashed longer than maxAge ago, a maxAge of 0 never expires
+func (m *Manifest) stale(maxAge time.Duration) bool {
+	return maxAge > 0 && time.Since(m.VerifiedAt) > maxAge
+}
+
+// updateManifest applies update to the manifest of a cached file
+func updateManifest(hash string, update func(*Manifest)) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := readManifest(hash)
+	if err != nil {
+		return err
+	}
+	update(manifest)
+	return writeManifest(manifest)
+}
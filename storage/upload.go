@@ -3,23 +3,38 @@ package storage
 import (
 	"bytes"
 	"context"
-	"fmt"
-	"net/http"
+	"os"
 )
 
+// Upload stores content at url from the server through the backend of its scheme
 func Upload(ctx context.Context, url string, content []byte) error {
-	// upload content to url as S3 object using PUT
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(content))
+	if err := checkRemoteURL(url); err != nil {
+		return err
+	}
+	backend, err := GetBackend(url)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/octet-stream")
-	res, err := http.DefaultClient.Do(req)
+	return backend.Put(ctx, url, bytes.NewReader(content), int64(len(content)))
+}
+
+// UploadFile stores the content of a local file at url from the server through the backend of its scheme
+func UploadFile(ctx context.Context, url string, path string) error {
+	if err := checkRemoteURL(url); err != nil {
+		return err
+	}
+	backend, err := GetBackend(url)
 	if err != nil {
 		return err
 	}
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("Upload failed: %s", res.Status)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
 	}
-	return err
+	return backend.Put(ctx, url, file, info.Size())
 }
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// WarmItem is a file to download into the cache ahead of time
+type WarmItem struct {
+	Hash string `json:"hash" mapstructure:"hash"`
+	Url  string `json:"url" mapstructure:"url"`
+}
+
+func (i *WarmItem) validate() error {
+	if err := CheckHash(i.Hash); err != nil {
+		return err
+	}
+	if _, err := GetBackend(i.Url); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseWarmItem parses a "<sha256> <url>" pair
+func ParseWarmItem(hash string, url string) (WarmItem, error) {
+	item := WarmItem{Hash: strings.ToLower(hash), Url: url}
+	return item, item.validate()
+}
+
+// ReadWarmList reads a list of files to download, one "<sha256> <url>" pair per line.
+// Blank lines and lines starting with # are ignored.
+func ReadWarmList(path string) ([]WarmItem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	items := []WarmItem{}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<sha256> <url>\"", path, n)
+		}
+		item, err := ParseWarmItem(fields[0], fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// WarmProgress is reported after each file of Warm
+type WarmProgress struct {
+	Item    WarmItem
+	Err     error
+	Elapsed time.Duration
+	// Number of finished and failed files so far
+	Done   int
+	Failed int
+	Total  int
+}
+
+// Warm fetches files into the cache with at most concurrency downloads at a time, calling progress
+// after each file. It returns the number of files that could not be fetched.
+func Warm(ctx context.Context, items []WarmItem, concurrency int, progress func(*WarmProgress)) int {
+	var mu sync.Mutex
+	done, failed := 0, 0
+	var group errgroup.Group
+	group.SetLimit(max(concurrency, 1))
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		group.Go(func() error {
+			start := time.Now()
+			// Warm lists come from the operator, so they may use mirrors of any backend
+			_, err := fetch(ctx, item.Url, item.Hash)
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if err != nil {
+				failed++
+			}
+			if progress != nil {
+				progress(&WarmProgress{
+					Item:    item,
+					Err:     err,
+					Elapsed: time.Since(start),
+					Done:    done,
+					Failed:  failed,
+					Total:   len(items),
+				})
+			}
+			return nil
+		})
+	}
+	group.Wait()
+	// Files skipped because ctx was done count as failed
+	return failed + len(items) - done
+}
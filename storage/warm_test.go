@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestReadWarmList(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	path := filepath.Join(t.TempDir(), "warm.txt")
+	content := "# contest files\n\n" + strings.ToUpper(hash) + " https://example.com/problem.zip\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	items, err := ReadWarmList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Hash != hash || items[0].Url != "https://example.com/problem.zip" {
+		t.Errorf("items = %+v", items)
+	}
+
+	for _, line := range []string{"only-one-field", "abc https://example.com/file", hash + " gopher://example.com/file"} {
+		if err := os.WriteFile(path, []byte("# header\n"+line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadWarmList(path); err == nil || !strings.Contains(err.Error(), "warm.txt:2") {
+			t.Errorf("%q: err = %v, want an error on line 2", line, err)
+		}
+	}
+}
+
+func TestWarm(t *testing.T) {
+	setupStorage(t)
+	viper.Set("download.attempts", 1)
+	defer viper.Set("download.attempts", nil)
+	items := []WarmItem{}
+	for _, content := range []string{"first", "second"} {
+		path, hash := writeFile(t, content)
+		url, err := FileURL(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, WarmItem{Hash: hash, Url: url})
+	}
+	// The data of the first item does not match its hash
+	_, hash := writeFile(t, "third")
+	items = append([]WarmItem{{Hash: hash, Url: items[1].Url}}, items...)
+
+	reports := 0
+	failed := Warm(context.Background(), items, 2, func(p *WarmProgress) {
+		reports++
+		if p.Total != len(items) {
+			t.Errorf("progress total = %d, want %d", p.Total, len(items))
+		}
+		if p.Err != nil && !errors.Is(p.Err, ErrHashMismatch) {
+			t.Errorf("unexpected error for %s: %v", p.Item.Url, p.Err)
+		}
+	})
+	if reports != len(items) {
+		t.Errorf("progress reported %d times, want %d", reports, len(items))
+	}
+	if failed != 1 {
+		t.Errorf("failed = %d, want 1", failed)
+	}
+	for _, item := range items[1:] {
+		if _, err := os.Stat(filepath.Join(GetCachePath(), item.Hash)); err != nil {
+			t.Errorf("%s was not cached: %v", item.Url, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ProxyOptions selects the proxy of each request. Rules are tried in order, then NoProxy, then URL.
+// Without URL, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+type ProxyOptions struct {
+	URL string `mapstructure:"url"`
+	// Hosts reached without a proxy
+	NoProxy []string    `mapstructure:"noProxy"`
+	Rules   []ProxyRule `mapstructure:"rules"`
+}
+
+// ProxyRule sends requests to matching hosts through URL, or directly if URL is "direct"
+type ProxyRule struct {
+	// Host names or path.Match patterns such as *.example.com
+	Hosts []string `mapstructure:"hosts"`
+	URL   string   `mapstructure:"url"`
+}
+
+func parseProxy(raw string) (*url.URL, error) {
+	if raw == "direct" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy %q", raw)
+	}
+	return u, nil
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *ProxyOptions) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	type rule struct {
+		hosts []string
+		proxy *url.URL
+	}
+	rules := []rule{}
+	for _, r := range o.Rules {
+		proxy, err := parseProxy(r.URL)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule{hosts: r.Hosts, proxy: proxy})
+	}
+	fallback := http.ProxyFromEnvironment
+	if o.URL != "" {
+		proxy, err := parseProxy(o.URL)
+		if err != nil {
+			return nil, err
+		}
+		fallback = func(*http.Request) (*url.URL, error) { return proxy, nil }
+	}
+	noProxy := o.NoProxy
+	return func(req *http.Request) (*url.URL, error) {
+		host := strings.ToLower(req.URL.Hostname())
+		for _, r := range rules {
+			if matchHost(r.hosts, host) {
+				return r.proxy, nil
+			}
+		}
+		if matchHost(noProxy, host) {
+			return nil, nil
+		}
+		return fallback(req)
+	}, nil
+}
@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestProxyRules(t *testing.T) {
+	opts := &ProxyOptions{
+		URL:     "http://proxy.internal:3128",
+		NoProxy: []string{"*.internal", "localhost"},
+		Rules: []ProxyRule{
+			{Hosts: []string{"vjudge.net", "*.vjudge.net"}, URL: "socks5://127.0.0.1:1080"},
+			{Hosts: []string{"OSS.example.com"}, URL: "direct"},
+			// Earlier rules win
+			{Hosts: []string{"*.vjudge.net"}, URL: "direct"},
+		},
+	}
+	proxy, err := opts.proxyFunc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, want := range map[string]string{
+		"https://vjudge.net/problem":     "socks5://127.0.0.1:1080",
+		"https://www.VJudge.net/problem": "socks5://127.0.0.1:1080",
+		"https://oss.example.com:8443/x": "",
+		"http://api.internal/x":          "",
+		"http://localhost:8080/x":        "",
+		"https://example.com/x":          "http://proxy.internal:3128",
+		// Patterns match whole host names
+		"https://vjudge.net.evil.com/x": "http://proxy.internal:3128",
+	} {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got == nil && want != "") || (got != nil && got.String() != want) {
+			t.Errorf("proxy of %s = %v, want %q", target, got, want)
+		}
+	}
+}
+
+func TestProxyInvalid(t *testing.T) {
+	for _, opts := range []ProxyOptions{
+		{URL: "ftp://proxy.internal"},
+		{Rules: []ProxyRule{{Hosts: []string{"example.com"}, URL: "file:///tmp/proxy"}}},
+		{URL: "http://[::1"},
+	} {
+		if _, err := opts.proxyFunc(); err == nil {
+			t.Errorf("proxy %+v was accepted", opts)
+		}
+	}
+}
+
+func TestProxyOverride(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("http.proxy.url", "http://proxy.internal:3128")
+	viper.Set("http.proxy.noProxy", []string{"*.internal"})
+	viper.Set("http.proxy.rules", []map[string]any{{"hosts": []string{"a.com"}, "url": "direct"}})
+	viper.Set("http.adapters.vjudge.proxy.rules", []map[string]any{{"hosts": []string{"b.com"}, "url": "direct"}})
+	opts, err := GetOptions("adapters.vjudge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lists of a use replace the shared ones, other settings are inherited
+	if len(opts.Proxy.Rules) != 1 || opts.Proxy.Rules[0].Hosts[0] != "b.com" {
+		t.Errorf("rules = %+v, want only the rule of the use", opts.Proxy.Rules)
+	}
+	if len(opts.Proxy.NoProxy) != 1 || opts.Proxy.URL != "http://proxy.internal:3128" {
+		t.Errorf("proxy = %+v, want the shared url and noProxy", opts.Proxy)
+	}
+}
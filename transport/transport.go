@@ -0,0 +1,205 @@
+// Package transport builds the HTTP clients used for all outbound requests from the http config section.
+//
+// Each use of HTTP has a name: "server" for the AOI API, "servers.<name>" for the API of additional
+// AOI servers, "storage" for downloads and uploads, and "adapters.<name>" for adapters that access
+// the network. Settings under http apply to every use and
+// can be overridden per use, such as http.server.tls for mTLS to the AOI server only.
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Options configures the clients of a use, durations are in seconds and 0 disables a timeout
+type Options struct {
+	// Limit of a whole request including reading the body, 60 seconds for the AOI API and none otherwise.
+	// Long poll requests get the wait on top of it. Storage only uses http.storage.timeout, as downloads
+	// of any size share it.
+	Timeout               float64      `mapstructure:"timeout"`
+	DialTimeout           float64      `mapstructure:"dialTimeout"`
+	TLSHandshakeTimeout   float64      `mapstructure:"tlsHandshakeTimeout"`
+	ResponseHeaderTimeout float64      `mapstructure:"responseHeaderTimeout"`
+	IdleConnTimeout       float64      `mapstructure:"idleConnTimeout"`
+	TLS                   TLSOptions   `mapstructure:"tls"`
+	Proxy                 ProxyOptions `mapstructure:"proxy"`
+}
+
+type TLSOptions struct {
+	// PEM file of CA certificates trusted in addition to the system ones
+	CA string `mapstructure:"ca"`
+	// PEM files of the client certificate and key for mTLS
+	Cert               string `mapstructure:"cert"`
+	Key                string `mapstructure:"key"`
+	ServerName         string `mapstructure:"serverName"`
+	InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// GetOptions reads the options of use, the http section overlaid with http.<use>
+func GetOptions(use string) (*Options, error) {
+	opts := &Options{
+		DialTimeout:         30,
+		TLSHandshakeTimeout: 10,
+		IdleConnTimeout:     90,
+	}
+	// A stuck API request would hold up lease renewal and the shutdown drain
+	if use == "server" || strings.HasPrefix(use, "servers.") {
+		opts.Timeout = 60
+	}
+	if err := viper.UnmarshalKey("http", opts); err != nil {
+		return nil, err
+	}
+	// Downloads may take far longer than API requests, they are bounded by download.stallTimeout instead
+	if use == "storage" {
+		opts.Timeout = 0
+	}
+	// Lists given for the use replace the shared ones instead of being merged element by element
+	if viper.IsSet("http." + use + ".proxy.rules") {
+		opts.Proxy.Rules = nil
+	}
+	if viper.IsSet("http." + use + ".proxy.noProxy") {
+		opts.Proxy.NoProxy = nil
+	}
+	if err := viper.UnmarshalKey("http."+use, opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+func (o *TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CA != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(o.CA)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CA)
+		}
+		config.RootCAs = pool
+	}
+	if o.Cert != "" || o.Key != "" {
+		cert, err := tls.LoadX509KeyPair(o.Cert, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// NewTransport builds a transport from opts
+func NewTransport(opts *Options) (*http.Transport, error) {
+	tlsConfig, err := opts.TLS.config()
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	proxy, err := opts.Proxy.proxyFunc()
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	dialer := &net.Dialer{
+		Timeout:   seconds(opts.DialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   seconds(opts.TLSHandshakeTimeout),
+		ResponseHeaderTimeout: seconds(opts.ResponseHeaderTimeout),
+		IdleConnTimeout:       seconds(opts.IdleConnTimeout),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		ExpectContinueTimeout: time.Second,
+	}, nil
+}
+
+type entry struct {
+	transport *http.Transport
+	timeout   time.Duration
+}
+
+var (
+	mu      sync.Mutex
+	entries = make(map[string]*entry)
+)
+
+func get(use string) *entry {
+	mu.Lock()
+	defer mu.Unlock()
+	if e, ok := entries[use]; ok {
+		return e
+	}
+	opts, err := GetOptions(use)
+	if err == nil {
+		var transport *http.Transport
+		if transport, err = NewTransport(opts); err == nil {
+			e := &entry{transport: transport, timeout: seconds(opts.Timeout)}
+			entries[use] = e
+			return e
+		}
+	}
+	// Falling back to defaults could bypass a required proxy or CA, a broken config is fatal
+	logrus.Fatalf("Invalid http config for %s: %v", use, err)
+	return nil
+}
+
+// Transport returns the transport of use, it is built once and shared so connections are reused
+func Transport(use string) http.RoundTripper {
+	return get(use).transport
+}
+
+// Client returns an HTTP client for use with its configured timeout
+func Client(use string) *http.Client {
+	e := get(use)
+	return &http.Client{Transport: e.transport, Timeout: e.timeout}
+}
+
+// Resty returns a resty client for use
+func Resty(use string) *resty.Client {
+	return resty.NewWithClient(Client(use))
+}
+
+// Init reads the http config again and builds the transports of all known uses, so a broken config
+// is reported at startup rather than on first use
+func Init() {
+	mu.Lock()
+	for _, e := range entries {
+		e.transport.CloseIdleConnections()
+	}
+	entries = make(map[string]*entry)
+	mu.Unlock()
+	uses := []string{"server", "storage"}
+	for name := range viper.GetStringMap("servers") {
+		uses = append(uses, "servers."+name)
+	}
+	for name := range viper.GetStringMap("http.adapters") {
+		uses = append(uses, "adapters."+name)
+	}
+	for _, use := range uses {
+		get(use)
+	}
+}
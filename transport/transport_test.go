@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestStorageTimeout(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("http.timeout", 10)
+	opts, err := GetOptions("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Timeout != 10 {
+		t.Errorf("server timeout = %v, want 10", opts.Timeout)
+	}
+	opts, err = GetOptions("storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Timeout != 0 {
+		t.Errorf("storage timeout = %v, want none", opts.Timeout)
+	}
+	viper.Set("http.storage.timeout", 600)
+	opts, err = GetOptions("storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Timeout != 600 {
+		t.Errorf("storage timeout = %v, want 600", opts.Timeout)
+	}
+}
+
+func TestAPITimeout(t *testing.T) {
+	defer viper.Reset()
+	for use, want := range map[string]float64{"server": 60, "servers.staging": 60, "storage": 0, "adapters.vjudge": 0} {
+		opts, err := GetOptions(use)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.Timeout != want {
+			t.Errorf("%s timeout = %v, want %v", use, opts.Timeout, want)
+		}
+	}
+	viper.Set("http.server.timeout", 5)
+	if opts, err := GetOptions("server"); err != nil || opts.Timeout != 5 {
+		t.Errorf("configured server timeout = %+v, %v, want 5", opts, err)
+	}
+}
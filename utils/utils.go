@@ -1,32 +1,28 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
+	"time"
 
+	"github.com/fedstackjs/azukiiro/archive"
 	"github.com/fedstackjs/azukiiro/storage"
 	"github.com/sirupsen/logrus"
 )
 
+// UnzipTemp extracts a zip archive into a new temporary directory, errors caused by
+// the content of the archive satisfy archive.IsBadArchive
 func UnzipTemp(source string, target string) (string, error) {
 	dir, err := storage.MkdirTemp(target)
 	if err != nil {
 		return dir, err
 	}
 	logrus.Infof("Unzipping %s to %s", source, dir)
-	err = exec.Command("unzip", source, "-d", dir).Run()
-	if err != nil {
+	if err := archive.ExtractZip(source, dir, archive.DefaultLimits()); err != nil {
 		os.RemoveAll(dir)
 		logrus.Println("Error unzipping", source, ":", err)
-		return dir, fmt.Errorf("failed to extract solution")
-	}
-	// remove all symlinks to avoid security issues
-	err = exec.Command("find", dir, "-type", "l", "-delete").Run()
-	if err != nil {
-		os.RemoveAll(dir)
-		logrus.Println("Error removing symlinks in", dir, ":", err)
-		return dir, fmt.Errorf("failed to extract solution")
+		return dir, fmt.Errorf("failed to extract archive: %w", err)
 	}
 	return dir, nil
 }
@@ -34,3 +30,22 @@ func UnzipTemp(source string, target string) (string, error) {
 func ToPtr[T any](v T) *T {
 	return &v
 }
+
+// WithGracePeriod returns a context that is canceled grace after ctx is done,
+// or when the returned function is called.
+func WithGracePeriod(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-graceCtx.Done():
+		}
+	})
+	return graceCtx, func() {
+		stop()
+		cancel()
+	}
+}
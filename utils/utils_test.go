@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithGracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	graceCtx, stop := WithGracePeriod(ctx, 50*time.Millisecond)
+	defer stop()
+	cancel()
+	select {
+	case <-graceCtx.Done():
+		t.Fatal("context canceled before the grace period")
+	case <-time.After(10 * time.Millisecond):
+	}
+	select {
+	case <-graceCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not canceled after the grace period")
+	}
+}
+
+func TestWithGracePeriodStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	graceCtx, stop := WithGracePeriod(ctx, time.Hour)
+	stop()
+	if graceCtx.Err() == nil {
+		t.Error("context not canceled by the returned function")
+	}
+	if ctx.Err() != nil {
+		t.Error("parent context canceled by the returned function")
+	}
+}